@@ -0,0 +1,613 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// externalDefinitions are the prefixes of the definitions of the Kubernetes libraries, e.g.
+// ObjectMeta, which are referenced by name and not documented with the project
+var externalDefinitions = []string{"io.k8s.apimachinery.", "io.k8s.api.", "k8s.io/apimachinery/", "k8s.io/api/"}
+
+// openapiSpec is the part of a swagger 2.0 spec the reference docs are generated from
+type openapiSpec struct {
+	Definitions map[string]openapiSchema `json:"definitions"`
+	// Paths maps each path to its operations by method, and to the parameters shared by them
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+type openapiSchema struct {
+	Description          string                   `json:"description"`
+	Type                 string                   `json:"type"`
+	Ref                  string                   `json:"$ref"`
+	Items                *openapiSchema           `json:"items"`
+	AdditionalProperties *openapiSchema           `json:"additionalProperties"`
+	Properties           map[string]openapiSchema `json:"properties"`
+	Required             []string                 `json:"required"`
+	GroupVersionKinds    []openapiGVK             `json:"x-kubernetes-group-version-kind"`
+}
+
+type openapiOperation struct {
+	Description      string      `json:"description"`
+	Action           string      `json:"x-kubernetes-action"`
+	GroupVersionKind *openapiGVK `json:"x-kubernetes-group-version-kind"`
+}
+
+type openapiGVK struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// apiDocs is the API reference of the group versions of a spec
+type apiDocs struct {
+	// Overview is the markdown of static_includes/_overview.md
+	Overview      string
+	GroupVersions []docsGroupVersion
+}
+
+// docsGroupVersion is the page of a group version, documenting its kinds and the definitions
+// their fields reference
+type docsGroupVersion struct {
+	Group   string
+	Version string
+	// Include is the markdown of static_includes/_<group>.md
+	Include     string
+	Kinds       []docsType
+	Definitions []docsType
+}
+
+// Title returns the group version, the legacy group is named core
+func (gv docsGroupVersion) Title() string {
+	return gv.groupName() + "/" + gv.Version
+}
+
+// Page returns the path of the page without extension, relative to the build directory
+func (gv docsGroupVersion) Page() string {
+	return filepath.ToSlash(filepath.Join(gv.groupName(), gv.Version))
+}
+
+func (gv docsGroupVersion) groupName() string {
+	if len(gv.Group) == 0 {
+		return "core"
+	}
+	return gv.Group
+}
+
+type docsType struct {
+	Name        string
+	Anchor      string
+	Description string
+	// APIVersion is set for kinds
+	APIVersion string
+	Fields     []docsField
+	Example    *docsExample
+	Operations []docsOperation
+}
+
+type docsField struct {
+	Name        string
+	Required    bool
+	Type        string
+	Link        docsLink
+	Description string
+}
+
+// docsLink points at the section of a kind or definition, Page is empty on the same page
+type docsLink struct {
+	Page   string
+	Anchor string
+}
+
+// docsExample is read from examples/<kind>/<kind>.yaml
+type docsExample struct {
+	Note   string `json:"note"`
+	Sample string `json:"sample"`
+}
+
+type docsOperation struct {
+	Action      string
+	Method      string
+	Path        string
+	Description string
+}
+
+// parseOpenapiSpec parses a swagger 2.0 spec, e.g. served by an apiserver at /openapi/v2
+func parseOpenapiSpec(data []byte) (openapiSpec, error) {
+	spec := openapiSpec{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, errors.Wrap(err, "could not parse the OpenAPI spec")
+	}
+	if len(spec.Definitions) == 0 {
+		return spec, errors.New("the OpenAPI spec has no definitions")
+	}
+	return spec, nil
+}
+
+// buildAPIDocs groups the kinds of spec by group version, with the examples and static includes
+// read from dir.  Operations are documented if operations is true.
+func buildAPIDocs(spec openapiSpec, dir string, operations bool) (apiDocs, error) {
+	// kinds maps the definitions of the kinds to their section, List kinds are documented with
+	// the fields of their kind
+	kinds := map[string]docsLink{}
+	groupVersions := map[openapiGVK]*docsGroupVersion{}
+	for name, schema := range spec.Definitions {
+		if isExternalDefinition(name) || len(schema.GroupVersionKinds) != 1 {
+			continue
+		}
+		gvk := schema.GroupVersionKinds[0]
+		if strings.HasSuffix(gvk.Kind, "List") && schema.Properties["items"].Items != nil {
+			continue
+		}
+		key := openapiGVK{Group: gvk.Group, Version: gvk.Version}
+		if _, ok := groupVersions[key]; !ok {
+			groupVersions[key] = &docsGroupVersion{Group: gvk.Group, Version: gvk.Version}
+		}
+		kinds[name] = docsLink{Page: groupVersions[key].Page(), Anchor: strings.ToLower(gvk.Kind)}
+	}
+
+	var ops map[openapiGVK][]docsOperation
+	if operations {
+		var err error
+		if ops, err = kindOperations(spec); err != nil {
+			return apiDocs{}, err
+		}
+	}
+
+	docs := apiDocs{Overview: readInclude(dir, "overview")}
+	for _, gv := range groupVersions {
+		gv.Include = readInclude(dir, gv.groupName())
+		// definitions holds the definitions referenced from the kinds of the page
+		definitions := map[string]bool{}
+		var pending []string
+		for name, link := range kinds {
+			if link.Page != gv.Page() {
+				continue
+			}
+			schema := spec.Definitions[name]
+			gvk := schema.GroupVersionKinds[0]
+			kind := newDocsType(gvk.Kind, schema, gv.Page(), kinds)
+			kind.APIVersion = strings.TrimPrefix(gvk.Group+"/"+gvk.Version, "/")
+			example, err := readExample(dir, gvk.Kind)
+			if err != nil {
+				return apiDocs{}, err
+			}
+			kind.Example = example
+			kind.Operations = ops[gvk]
+			gv.Kinds = append(gv.Kinds, kind)
+			pending = append(pending, references(schema)...)
+		}
+		for len(pending) > 0 {
+			name := pending[0]
+			pending = pending[1:]
+			if _, ok := kinds[name]; ok || definitions[name] || isExternalDefinition(name) {
+				continue
+			}
+			schema, ok := spec.Definitions[name]
+			if !ok {
+				continue
+			}
+			definitions[name] = true
+			gv.Definitions = append(gv.Definitions, newDocsType(shortName(name), schema, gv.Page(), kinds))
+			pending = append(pending, references(schema)...)
+		}
+		sortDocsTypes(gv.Kinds)
+		sortDocsTypes(gv.Definitions)
+		docs.GroupVersions = append(docs.GroupVersions, *gv)
+	}
+	sort.Slice(docs.GroupVersions, func(i, j int) bool {
+		return docs.GroupVersions[i].Title() < docs.GroupVersions[j].Title()
+	})
+	if len(docs.GroupVersions) == 0 {
+		return docs, errors.New("the OpenAPI spec has no definitions with an x-kubernetes-group-version-kind")
+	}
+	return docs, nil
+}
+
+// kindOperations returns the operations of the paths by the kind they act on
+func kindOperations(spec openapiSpec) (map[openapiGVK][]docsOperation, error) {
+	ops := map[openapiGVK][]docsOperation{}
+	for path, methods := range spec.Paths {
+		for method, data := range methods {
+			if method == "parameters" {
+				continue
+			}
+			op := openapiOperation{}
+			if err := json.Unmarshal(data, &op); err != nil {
+				return nil, errors.Wrapf(err, "could not parse the %s operation of %s", method, path)
+			}
+			if op.GroupVersionKind == nil {
+				continue
+			}
+			ops[*op.GroupVersionKind] = append(ops[*op.GroupVersionKind], docsOperation{
+				Action:      op.Action,
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				Description: op.Description,
+			})
+		}
+	}
+	for _, o := range ops {
+		sort.Slice(o, func(i, j int) bool {
+			if o[i].Path != o[j].Path {
+				return o[i].Path < o[j].Path
+			}
+			return o[i].Method < o[j].Method
+		})
+	}
+	return ops, nil
+}
+
+func newDocsType(name string, schema openapiSchema, page string, kinds map[string]docsLink) docsType {
+	t := docsType{Name: name, Anchor: strings.ToLower(name), Description: schema.Description}
+	required := map[string]bool{}
+	for _, r := range schema.Required {
+		required[r] = true
+	}
+	for field, property := range schema.Properties {
+		typeName, link := fieldType(property, page, kinds)
+		t.Fields = append(t.Fields, docsField{
+			Name:        field,
+			Required:    required[field],
+			Type:        typeName,
+			Link:        link,
+			Description: property.Description,
+		})
+	}
+	sort.Slice(t.Fields, func(i, j int) bool { return t.Fields[i].Name < t.Fields[j].Name })
+	return t
+}
+
+// fieldType returns the name of the type of a field, and the link to its section if it is
+// documented
+func fieldType(schema openapiSchema, page string, kinds map[string]docsLink) (string, docsLink) {
+	switch {
+	case schema.Items != nil:
+		name, link := fieldType(*schema.Items, page, kinds)
+		return "[]" + name, link
+	case schema.AdditionalProperties != nil:
+		name, link := fieldType(*schema.AdditionalProperties, page, kinds)
+		return "map[string]" + name, link
+	case len(schema.Ref) > 0:
+		name := refName(schema.Ref)
+		if isExternalDefinition(name) {
+			return shortName(name), docsLink{}
+		}
+		if link, ok := kinds[name]; ok {
+			if link.Page == page {
+				link.Page = ""
+			}
+			return shortName(name), link
+		}
+		return shortName(name), docsLink{Anchor: strings.ToLower(shortName(name))}
+	case len(schema.Type) > 0:
+		return schema.Type, docsLink{}
+	}
+	return "object", docsLink{}
+}
+
+// references returns the definitions referenced by the properties of schema
+func references(schema openapiSchema) []string {
+	var refs []string
+	if len(schema.Ref) > 0 {
+		refs = append(refs, refName(schema.Ref))
+	}
+	if schema.Items != nil {
+		refs = append(refs, references(*schema.Items)...)
+	}
+	if schema.AdditionalProperties != nil {
+		refs = append(refs, references(*schema.AdditionalProperties)...)
+	}
+	var fields []string
+	for field := range schema.Properties {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		refs = append(refs, references(schema.Properties[field])...)
+	}
+	return refs
+}
+
+func refName(ref string) string {
+	return strings.TrimPrefix(ref, "#/definitions/")
+}
+
+// shortName returns the type of a definition name, e.g. ObjectMeta of
+// io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func isExternalDefinition(name string) bool {
+	for _, prefix := range externalDefinitions {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortDocsTypes(types []docsType) {
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+}
+
+// readInclude returns the markdown of static_includes/_<name>.md under dir, empty if it does not
+// exist
+func readInclude(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "static_includes", "_"+name+".md"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readExample returns the example of kind from examples/<kind>/<kind>.yaml under dir, nil if it
+// does not exist
+func readExample(dir, kind string) (*docsExample, error) {
+	kind = strings.ToLower(kind)
+	path := filepath.Join(dir, "examples", kind, kind+".yaml")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	example := &docsExample{}
+	if err := yaml.Unmarshal(data, example); err != nil {
+		return nil, errors.Wrapf(err, "could not parse the example %s", path)
+	}
+	example.Note = strings.TrimSpace(example.Note)
+	example.Sample = strings.TrimSpace(example.Sample)
+	return example, nil
+}
+
+// writeAPIDocs writes the index and the group version pages of docs to dir, in markdown if md
+// is true and in html if html is true
+func writeAPIDocs(docs apiDocs, dir string, md, html bool) error {
+	type page struct {
+		path string
+		data interface{}
+		md   *template.Template
+		html *htmltemplate.Template
+	}
+	pages := []page{{"index", docs, markdownIndexTemplate, htmlIndexTemplate}}
+	for _, gv := range docs.GroupVersions {
+		pages = append(pages, page{gv.Page(), gv, markdownPageTemplate, htmlPageTemplate})
+	}
+	for _, p := range pages {
+		if md {
+			out := &bytes.Buffer{}
+			if err := p.md.Execute(out, p.data); err != nil {
+				return err
+			}
+			if err := writeDocsFile(filepath.Join(dir, p.path+".md"), out.Bytes()); err != nil {
+				return err
+			}
+		}
+		if html {
+			out := &bytes.Buffer{}
+			if err := p.html.Execute(out, p.data); err != nil {
+				return err
+			}
+			if err := writeDocsFile(filepath.Join(dir, p.path+".html"), out.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeDocsFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// docsHref returns the href of link from a group version page to the page of extension ext
+func docsHref(link docsLink, ext string) string {
+	if len(link.Page) == 0 {
+		return "#" + link.Anchor
+	}
+	return "../" + link.Page + "." + ext + "#" + link.Anchor
+}
+
+// markdownCell escapes s for a cell of a markdown table, and for the text of links in it
+func markdownCell(s string) string {
+	return markdownCellReplacer.Replace(strings.Join(strings.Fields(s), " "))
+}
+
+var markdownCellReplacer = strings.NewReplacer("|", `\|`, "[", `\[`, "]", `\]`)
+
+var markdownIndexTemplate = template.Must(template.New("markdown-index").Parse(`# API Reference
+{{ with .Overview }}
+{{ . }}
+{{ end }}
+{{- range .GroupVersions }}
+## {{ .Title }}
+
+{{ $gv := . -}}
+{{ range .Kinds -}}
+- [{{ .Name }}]({{ $gv.Page }}.md#{{ .Anchor }})
+{{ end -}}
+{{ end -}}
+`))
+
+var markdownPageTemplate = template.Must(template.New("markdown-page").Funcs(template.FuncMap{
+	"href": docsHref,
+	"cell": markdownCell,
+}).Parse(`# {{ .Title }}
+{{ with .Include }}
+{{ . }}
+{{ end }}
+{{- range .Kinds }}
+## {{ .Name }}
+
+` + "`apiVersion: {{ .APIVersion }}` `kind: {{ .Name }}`" + `
+{{ with .Description }}
+{{ . }}
+{{ end }}
+{{- with .Example }}
+{{ with .Note }}{{ . }}
+
+{{ end -}}
+` + "```yaml\n{{ .Sample }}\n```" + `
+{{ end }}
+{{- template "fields" . }}
+{{- with .Operations }}
+### Operations
+
+Action | Request | Description
+------ | ------- | -----------
+{{ range . -}}
+` + "`{{ .Action }}` | `{{ .Method }} {{ .Path }}`" + ` |{{ with cell .Description }} {{ . }}{{ end }}
+{{ end -}}
+{{ end -}}
+{{ end -}}
+{{ with .Definitions }}
+## Definitions
+{{ range . }}
+### {{ .Name }}
+{{ with .Description }}
+{{ . }}
+{{ end }}
+{{- template "fields" . }}
+{{- end -}}
+{{ end -}}
+
+{{- define "fields" }}{{ with .Fields }}
+Field | Type | Description
+----- | ---- | -----------
+{{ range . -}}
+` + "`{{ .Name }}`" + `{{ if .Required }} *(required)*{{ end }} | {{ if .Link.Anchor }}[{{ cell .Type }}]({{ href .Link "md" }}){{ else }}{{ cell .Type }}{{ end }} |{{ with cell .Description }} {{ . }}{{ end }}
+{{ end -}}
+{{ end }}{{ end -}}
+`))
+
+const htmlHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ template "title" . }}</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; }
+nav { width: 16em; padding: 1em; background: #f5f5f5; min-height: 100vh; }
+nav ul { list-style: none; padding-left: 1em; }
+main { padding: 1em 2em; max-width: 60em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ddd; padding: .4em; text-align: left; vertical-align: top; }
+pre { background: #f5f5f5; padding: 1em; overflow-x: auto; }
+.description { white-space: pre-line; }
+</style>
+</head>
+<body>
+`
+
+var htmlIndexTemplate = htmltemplate.Must(htmltemplate.New("html-index").Funcs(htmltemplate.FuncMap{
+	"markdown": markdownHTML,
+}).Parse(htmlHead + `<main>
+<h1>API Reference</h1>
+{{ markdown .Overview }}
+{{- range .GroupVersions }}
+<h2><a href="{{ .Page }}.html">{{ .Title }}</a></h2>
+<ul>
+{{- $gv := . }}
+{{- range .Kinds }}
+<li><a href="{{ $gv.Page }}.html#{{ .Anchor }}">{{ .Name }}</a></li>
+{{- end }}
+</ul>
+{{- end }}
+</main>
+</body>
+</html>
+{{ define "title" }}API Reference{{ end }}`))
+
+var htmlPageTemplate = htmltemplate.Must(htmltemplate.New("html-page").Funcs(htmltemplate.FuncMap{
+	"href":     docsHref,
+	"markdown": markdownHTML,
+}).Parse(htmlHead + `<nav>
+<a href="../index.html">API Reference</a>
+<ul>
+{{- range .Kinds }}
+<li><a href="#{{ .Anchor }}">{{ .Name }}</a></li>
+{{- end }}
+</ul>
+</nav>
+<main>
+<h1>{{ .Title }}</h1>
+{{ markdown .Include }}
+{{- range .Kinds }}
+<h2 id="{{ .Anchor }}">{{ .Name }}</h2>
+<p><code>apiVersion: {{ .APIVersion }}</code> <code>kind: {{ .Name }}</code></p>
+{{- with .Description }}
+<p class="description">{{ . }}</p>
+{{- end }}
+{{- with .Example }}
+{{- with .Note }}
+<p>{{ . }}</p>
+{{- end }}
+<pre><code>{{ .Sample }}</code></pre>
+{{- end }}
+{{- template "fields" . }}
+{{- with .Operations }}
+<h3>Operations</h3>
+<table>
+<tr><th>Action</th><th>Request</th><th>Description</th></tr>
+{{- range . }}
+<tr><td><code>{{ .Action }}</code></td><td><code>{{ .Method }} {{ .Path }}</code></td><td class="description">{{ .Description }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+{{- with .Definitions }}
+<h2>Definitions</h2>
+{{- range . }}
+<h3 id="{{ .Anchor }}">{{ .Name }}</h3>
+{{- with .Description }}
+<p class="description">{{ . }}</p>
+{{- end }}
+{{- template "fields" . }}
+{{- end }}
+{{- end }}
+</main>
+</body>
+</html>
+{{ define "title" }}{{ .Title }}{{ end }}
+{{- define "fields" }}
+{{- with .Fields }}
+<table>
+<tr><th>Field</th><th>Type</th><th>Description</th></tr>
+{{- range . }}
+<tr><td><code>{{ .Name }}</code>{{ if .Required }} <em>required</em>{{ end }}</td><td>{{ if .Link.Anchor }}<a href="{{ href .Link "html" }}">{{ .Type }}</a>{{ else }}{{ .Type }}{{ end }}</td><td class="description">{{ .Description }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}`))
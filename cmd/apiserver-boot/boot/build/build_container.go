@@ -17,17 +17,33 @@ limitations under the License.
 package build
 
 import (
-	"k8s.io/klog"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-
-	"io/ioutil"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 )
 
 var Image string
+var BaseImage = "gcr.io/distroless/static:nonroot"
+var ContainerBuilder = "docker"
+var containerPlatforms []string
+var multiStage bool
+var pushContainer bool
+var containerOutput string
+
+const (
+	builderDocker  = "docker"
+	builderPodman  = "podman"
+	builderBuildah = "buildah"
+)
+
+var supportedBuilders = []string{builderDocker, builderPodman, builderBuildah}
 
 var createBuildContainerCmd = &cobra.Command{
 	Use:   "container",
@@ -38,7 +54,20 @@ var createBuildContainerCmd = &cobra.Command{
 apiserver-boot build container --image gcr.io/myrepo/myimage:mytag
 
 # Push the newly built image to the image repo
-docker push gcr.io/myrepo/myimage:mytag`,
+docker push gcr.io/myrepo/myimage:mytag
+
+# Build and push a manifest list for amd64 and arm64 with podman
+apiserver-boot build container --image gcr.io/myrepo/myimage:mytag --builder podman \
+    --platforms linux/amd64,linux/arm64 --push
+
+# Compile the binaries inside the container build rather than on the host, for hermetic builds
+apiserver-boot build container --image gcr.io/myrepo/myimage:mytag --multi-stage
+
+# Write an OCI image layout to the image/ directory without a container engine
+apiserver-boot build container --image gcr.io/myrepo/myimage:mytag --output oci:image
+
+# Write a tarball for docker load without a container engine
+apiserver-boot build container --image gcr.io/myrepo/myimage:mytag --output tar:image.tar`,
 	Run: RunBuildContainer,
 }
 
@@ -50,12 +79,51 @@ func AddBuildContainer(cmd *cobra.Command) {
 func AddBuildContainerFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&Image, "image", "", "name of the image with tag")
 	cmd.Flags().StringArrayVar(&BuildTargets, "targets", []string{apiserverTarget, controllerTarget}, "The target binaries to build")
+	cmd.Flags().StringVar(&BaseImage, "base-image", BaseImage, "image the binaries are added to, must provide a user 65532")
+	cmd.Flags().StringVar(&ContainerBuilder, "builder", ContainerBuilder,
+		fmt.Sprintf("container engine building the image, one of %v", supportedBuilders))
+	cmd.Flags().StringSliceVar(&containerPlatforms, "platforms", []string{"linux/amd64"},
+		"<os>/<arch>[/<variant>] platforms of the image, a manifest list is built for multiple platforms")
+	cmd.Flags().BoolVar(&multiStage, "multi-stage", false,
+		"if true, compile the binaries in a builder stage of the container build instead of on the host")
+	cmd.Flags().BoolVar(&pushContainer, "push", false, "if true, push the image or manifest list after building it")
+	cmd.Flags().StringVar(&containerOutput, "output", "",
+		"if set, assemble the image without a container engine and write it as an OCI image layout (oci:<dir>) "+
+			"or a docker load tarball (tar[:<file>]), --base-image may be scratch")
+	cmd.Flags().StringToStringVar(&imageLabels, "labels", map[string]string{}, "labels of the image built with --output")
 }
 
 func RunBuildContainer(cmd *cobra.Command, args []string) {
 	if len(Image) == 0 {
 		klog.Fatalf("Must specify --image")
 	}
+	if !sets.NewString(supportedBuilders...).Has(ContainerBuilder) {
+		klog.Fatalf("--builder must be one of %v was (%s)", supportedBuilders, ContainerBuilder)
+	}
+	if len(containerPlatforms) == 0 {
+		containerPlatforms = []string{"linux/amd64"}
+	}
+	if len(containerOutput) > 0 {
+		if multiStage || pushContainer {
+			klog.Fatalf("--output can't be used with --multi-stage or --push")
+		}
+		dir, err := ioutil.TempDir(os.TempDir(), "apiserver-boot-build-container")
+		if err != nil {
+			klog.Fatalf("failed to create temp directory %s %v", dir, err)
+		}
+		defer os.RemoveAll(dir)
+		klog.Infof("Building binaries for %s.", strings.Join(containerPlatforms, ", "))
+		platforms = containerPlatforms
+		outputdir = dir
+		RunBuildExecutables(cmd, args)
+		buildImageWithoutDaemon(dir, containerOutput)
+		return
+	}
+	multiPlatform := len(containerPlatforms) > 1
+	if multiPlatform && ContainerBuilder == builderDocker && !pushContainer {
+		klog.Fatalf("docker can only build multi-platform images with --push, " +
+			"use --builder podman or buildah to keep the manifest list locally")
+	}
 
 	dir, err := ioutil.TempDir(os.TempDir(), "apiserver-boot-build-container")
 	if err != nil {
@@ -69,36 +137,120 @@ func RunBuildContainer(cmd *cobra.Command, args []string) {
 	util.WriteIfNotFound(path, "dockerfile-template", dockerfileTemplate, dockerfileTemplateArguments{
 		BuildApiserver:  buildApiserver(),
 		BuildController: buildController(),
+		BaseImage:       BaseImage,
+		MultiStage:      multiStage,
 	})
 
-	klog.Infof("Building binaries for linux amd64.")
+	context := dir
+	if multiStage {
+		// the builder stage compiles the sources of the project
+		context = "."
+	} else {
+		klog.Infof("Building binaries for %s.", strings.Join(containerPlatforms, ", "))
+		platforms = containerPlatforms
+		outputdir = dir
+		RunBuildExecutables(cmd, args)
+	}
 
-	// Set the goos and goarch
-	goos = "linux"
-	goarch = "amd64"
-	outputdir = dir
-	RunBuildExecutables(cmd, args)
+	klog.Infof("Building the container Image using %s.", path)
+	util.DoCmd(ContainerBuilder, containerBuildArgs(path, context)...)
+	if pushContainer {
+		PushImage()
+	}
+}
 
-	klog.Infof("Building the docker Image using %s.", path)
+// containerBuildArgs returns the arguments of the container engine building the image.  Single
+// platform builds pass the target platform as build args for builders which don't set them.
+// Multi-stage builds pass the version ldflags of build executables as the LDFLAGS build arg.
+func containerBuildArgs(dockerfile, context string) []string {
+	var buildArgs []string
+	if multiStage {
+		buildArgs = append(buildArgs, "--build-arg", "LDFLAGS="+versionLDFlags())
+	}
+	platform := strings.Join(containerPlatforms, ",")
+	if len(containerPlatforms) == 1 {
+		p, err := parsePlatform(containerPlatforms[0])
+		if err != nil {
+			klog.Fatal(err)
+		}
+		buildArgs = append(buildArgs,
+			"--build-arg", "TARGETOS="+p.OS, "--build-arg", "TARGETARCH="+p.Architecture,
+			"--build-arg", "TARGETVARIANT="+p.targetVariant())
+		return append(append([]string{"build", "--platform", platform}, buildArgs...),
+			"-t", Image, "-f", dockerfile, context)
+	}
+	switch ContainerBuilder {
+	case builderDocker:
+		// buildx pushes the manifest list while building it
+		return append(append([]string{"buildx", "build", "--platform", platform, "--push"}, buildArgs...),
+			"-t", Image, "-f", dockerfile, context)
+	default:
+		return append(append([]string{"build", "--platform", platform, "--manifest", Image}, buildArgs...),
+			"-f", dockerfile, context)
+	}
+}
 
-	util.DoCmd("docker", "build", "-t", Image, dir)
+// PushImage pushes the image built by build container with the selected container engine.
+func PushImage() {
+	switch {
+	case len(containerPlatforms) > 1 && ContainerBuilder == builderDocker:
+		// already pushed by buildx
+	case len(containerPlatforms) > 1:
+		util.DoCmd(ContainerBuilder, "manifest", "push", "--all", Image, "docker://"+Image)
+	default:
+		util.DoCmd(ContainerBuilder, "push", Image)
+	}
 }
 
 type dockerfileTemplateArguments struct {
 	BuildApiserver  bool
 	BuildController bool
+	BaseImage       string
+	// MultiStage compiles the binaries in a builder stage from the project sources
+	MultiStage bool
 }
 
 var dockerfileTemplate = `
-FROM ubuntu:14.04
+{{- if .MultiStage -}}
+FROM --platform=$BUILDPLATFORM golang:1.15 as builder
+ARG TARGETOS
+ARG TARGETARCH
+ARG TARGETVARIANT
+ARG LDFLAGS
+
+WORKDIR /workspace
+COPY go.mod go.sum ./
+RUN go mod download
+
+COPY . .
+{{- if .BuildApiserver }}
+RUN CGO_ENABLED=0 GOOS=$TARGETOS GOARCH=$TARGETARCH GOARM=${TARGETVARIANT#v} go build -trimpath -ldflags "$LDFLAGS" -o bin/apiserver cmd/apiserver/main.go
+{{- end }}
+{{- if .BuildController }}
+RUN CGO_ENABLED=0 GOOS=$TARGETOS GOARCH=$TARGETARCH GOARM=${TARGETVARIANT#v} go build -trimpath -ldflags "$LDFLAGS" -o bin/controller-manager cmd/manager/main.go
+{{- end }}
 
-RUN apt-get update
-RUN apt-get install -y ca-certificates
+FROM {{ .BaseImage }}
+WORKDIR /
+{{- if .BuildApiserver }}
+COPY --from=builder /workspace/bin/apiserver .
+{{- end }}
+{{- if .BuildController }}
+COPY --from=builder /workspace/bin/controller-manager .
+{{- end }}
+{{- else -}}
+FROM {{ .BaseImage }}
+ARG TARGETOS
+ARG TARGETARCH
+ARG TARGETVARIANT
 
-{{ if .BuildApiserver }}
-ADD apiserver .
-{{ end }}
-{{ if .BuildController }}
-ADD controller-manager .
-{{ end }}
+WORKDIR /
+{{- if .BuildApiserver }}
+COPY ${TARGETOS}_${TARGETARCH}${TARGETVARIANT}/apiserver .
+{{- end }}
+{{- if .BuildController }}
+COPY ${TARGETOS}_${TARGETARCH}${TARGETVARIANT}/controller-manager .
+{{- end }}
+{{- end }}
+USER 65532:65532
 `
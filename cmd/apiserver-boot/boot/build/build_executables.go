@@ -17,11 +17,17 @@ limitations under the License.
 package build
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
@@ -33,6 +39,7 @@ var outputdir = "bin"
 var Bazel bool
 var Gazelle bool
 var BuildTargets []string
+var platforms []string
 
 const (
 	apiserverTarget  = "apiserver"
@@ -50,6 +57,10 @@ apiserver-boot build executables
 # Build binaries into the linux/ directory using the cross compiler for linux:amd64
 apiserver-boot build executables --goos linux --goarch amd64 --output linux/
 
+# Build release binaries for several platforms in parallel into bin/<os>_<arch><variant>/, with the version
+# taken from the git tags and the checksums written to bin/checksums.txt
+apiserver-boot build executables --platforms linux/amd64,linux/arm64,darwin/arm64
+
 # Regenerate Bazel BUILD files, and then build with bazel
 # Must first install bazel and gazelle !!!
 apiserver-boot build executables --bazel --gazelle
@@ -67,6 +78,10 @@ func AddBuildExecutables(cmd *cobra.Command) {
 	createBuildExecutablesCmd.Flags().StringVar(&goos, "goos", "", "if specified, set this GOOS")
 	createBuildExecutablesCmd.Flags().StringVar(&goarch, "goarch", "", "if specified, set this GOARCH")
 	createBuildExecutablesCmd.Flags().StringVar(&outputdir, "output", "bin", "if set, write the binaries to this directory")
+	createBuildExecutablesCmd.Flags().StringSliceVar(&platforms, "platforms", []string{},
+		"if set, build the binaries for each <os>/<arch>[/<variant>] into <output>/<os>_<arch><variant>, overrides --goos and --goarch")
+	createBuildExecutablesCmd.Flags().StringVar(&buildVersion, "version", "",
+		"version of the binaries, defaults to git describe --tags")
 	createBuildExecutablesCmd.Flags().BoolVar(&Bazel, "bazel", false, "if true, use bazel to build.  May require updating build rules with gazelle.")
 	createBuildExecutablesCmd.Flags().BoolVar(&Gazelle, "gazelle", false, "if true, run gazelle before running bazel.")
 	createBuildExecutablesCmd.Flags().StringArrayVar(&BuildTargets, "targets", []string{apiserverTarget, controllerTarget}, "The target binaries to build")
@@ -169,55 +184,134 @@ func BazelBuild(cmd *cobra.Command, args []string) {
 func GoBuild(cmd *cobra.Command, args []string) {
 	initApis()
 
-	os.RemoveAll(filepath.Join("bin", "apiserver"))
-	os.RemoveAll(filepath.Join("bin", "controller-manager"))
-
-	if buildApiserver() {
-		// Build the apiserver
-		path := filepath.Join("cmd", "apiserver", "main.go")
-		c := exec.Command("go", "build", "-o", filepath.Join(outputdir, "apiserver"), path)
-		c.Env = append(os.Environ(), "CGO_ENABLED=0")
-		klog.Infof("CGO_ENABLED=0")
-		if len(goos) > 0 {
-			c.Env = append(c.Env, fmt.Sprintf("GOOS=%s", goos))
-			klog.Infof(fmt.Sprintf("GOOS=%s", goos))
-		}
-		if len(goarch) > 0 {
-			c.Env = append(c.Env, fmt.Sprintf("GOARCH=%s", goarch))
-			klog.Infof(fmt.Sprintf("GOARCH=%s", goarch))
+	var jobs []goBuildJob
+	if len(platforms) == 0 {
+		os.RemoveAll(filepath.Join("bin", "apiserver"))
+		os.RemoveAll(filepath.Join("bin", "controller-manager"))
+		jobs = goBuildJobs(goos, goarch, outputdir)
+	} else {
+		for _, s := range platforms {
+			p, err := parsePlatform(s)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			env, err := p.goEnv()
+			if err != nil {
+				klog.Fatal(err)
+			}
+			for _, j := range goBuildJobs(p.OS, p.Architecture, filepath.Join(outputdir, p.dir())) {
+				j.env = env
+				jobs = append(jobs, j)
+			}
 		}
+	}
 
-		klog.Infof("%s", strings.Join(c.Args, " "))
-		c.Stderr = os.Stderr
-		c.Stdout = os.Stdout
-		err := c.Run()
+	ldflags := versionLDFlags()
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs))
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i := range jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = jobs[i].run(ldflags)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
 			klog.Fatal(err)
 		}
 	}
 
+	var binaries []string
+	for _, j := range jobs {
+		binaries = append(binaries, j.output)
+	}
+	writeChecksums(outputdir, binaries)
+}
+
+type goBuildJob struct {
+	main   string
+	output string
+	goos   string
+	goarch string
+	// env selects the variant of the platform, e.g. GOARM
+	env []string
+}
+
+func goBuildJobs(goos, goarch, dir string) []goBuildJob {
+	ext := ""
+	if goos == "windows" {
+		ext = ".exe"
+	}
+	var jobs []goBuildJob
+	if buildApiserver() {
+		jobs = append(jobs, goBuildJob{
+			main:   filepath.Join("cmd", "apiserver", "main.go"),
+			output: filepath.Join(dir, "apiserver"+ext),
+			goos:   goos,
+			goarch: goarch,
+		})
+	}
 	if buildController() {
-		// Build the controller manager
-		path := filepath.Join("cmd", "manager", "main.go")
-		c := exec.Command("go", "build", "-o", filepath.Join(outputdir, "controller-manager"), path)
-		if len(os.Getenv("CGO_ENABLED")) == 0 {
-			c.Env = append(os.Environ(), "CGO_ENABLED=0")
-		}
-		if len(goos) > 0 {
-			c.Env = append(c.Env, fmt.Sprintf("GOOS=%s", goos))
-		}
-		if len(goarch) > 0 {
-			c.Env = append(c.Env, fmt.Sprintf("GOARCH=%s", goarch))
-		}
+		jobs = append(jobs, goBuildJob{
+			main:   filepath.Join("cmd", "manager", "main.go"),
+			output: filepath.Join(dir, "controller-manager"+ext),
+			goos:   goos,
+			goarch: goarch,
+		})
+	}
+	return jobs
+}
 
-		klog.Infof(strings.Join(c.Args, " "))
-		c.Stderr = os.Stderr
-		c.Stdout = os.Stdout
-		err := c.Run()
+func (j goBuildJob) run(ldflags string) error {
+	c := exec.Command("go", "build", "-trimpath", "-ldflags", ldflags, "-o", j.output, j.main)
+	c.Env = os.Environ()
+	if len(os.Getenv("CGO_ENABLED")) == 0 {
+		c.Env = append(c.Env, "CGO_ENABLED=0")
+	}
+	env := []string{}
+	if len(j.goos) > 0 {
+		env = append(env, fmt.Sprintf("GOOS=%s", j.goos))
+	}
+	if len(j.goarch) > 0 {
+		env = append(env, fmt.Sprintf("GOARCH=%s", j.goarch))
+	}
+	env = append(env, j.env...)
+	c.Env = append(c.Env, env...)
+
+	klog.Infof("%s %s", strings.Join(env, " "), strings.Join(c.Args, " "))
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed building %s: %v\n%s", j.output, err, out)
+	}
+	return nil
+}
+
+// writeChecksums writes the sha256 sums of the binaries to checksums.txt in dir, in the format
+// read by sha256sum --check.
+func writeChecksums(dir string, binaries []string) {
+	sort.Strings(binaries)
+	buf := &bytes.Buffer{}
+	for _, b := range binaries {
+		data, err := ioutil.ReadFile(b)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		rel, err := filepath.Rel(dir, b)
 		if err != nil {
 			klog.Fatal(err)
 		}
+		fmt.Fprintf(buf, "%x  %s\n", sha256.Sum256(data), filepath.ToSlash(rel))
+	}
+	path := filepath.Join(dir, "checksums.txt")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		klog.Fatal(err)
 	}
+	klog.Infof("Wrote %s", path)
 }
 
 func buildApiserver() bool {
@@ -18,24 +18,24 @@ package build
 
 import (
 	"bytes"
-	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 )
 
 var Name, Namespace string
-var Versions []schema.GroupVersion
+var Versions []APIVersion
 var ResourceConfigDir string
 var ControllerArgs []string
 var ApiserverArgs []string
@@ -45,6 +45,15 @@ var ControllerSecretEnv []string
 var ImagePullSecrets []string
 var ServiceAccount string
 var StorageClass string
+var ConfigFormat = formatYAML
+
+const (
+	formatYAML      = "yaml"
+	formatKustomize = "kustomize"
+	formatHelm      = "helm"
+)
+
+var supportedConfigFormats = []string{formatYAML, formatKustomize, formatHelm}
 
 var buildResourceConfigCmd = &cobra.Command{
 	Use:   "config",
@@ -56,10 +65,54 @@ var buildResourceConfigCmd = &cobra.Command{
 # Generates CA and apiserver certificates.
 apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag
 
-# Build yaml resource config into the config/ directory for running the apiserver and
-# controller-manager locally, but registered through aggregation into a local minikube cluster
+# Build yaml resource config into the config/local directory aggregating the apiserver started
+# with run local into the kind or minikube cluster of the current kubeconfig context.
 # Generates CA and apiserver certificates.
-apiserver-boot build config --name nameofservice --namespace mysystemnamespace --local-minikube
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --local
+kubectl apply -f config/local
+apiserver-boot run local --cluster-kubeconfig ~/.kube/config
+
+# Build a kustomize base and dev and prod overlays into the config/ directory
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --format kustomize
+kubectl apply -k config/overlays/prod
+
+# Build a helm chart into charts/nameofservice generating the certificates at install time
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --format helm --chart-certs helm
+
+# Build yaml resource config with ECDSA certificates valid for 90 days, which are also valid for
+# the name the apiserver is exposed as by an ingress
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --key-algorithm ecdsa --validity 2160h --cert-dns-names apiserver.example.com
+
+# Build yaml resource config with the certificates issued by cert-manager in the cluster
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --cert-provider cert-manager
+
+# Build yaml resource config with a 3 member etcd cluster
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --etcd-replicas 3
+
+# Build yaml resource config connecting the apiserver to an existing etcd cluster with the client
+# certificate of the etcd-client secret
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --etcd-servers https://etcd-0.example.com:2379 --etcd-client-secret etcd-client
+
+# Build yaml resource config for an apiserver storing its resources in MySQL, with the connection
+# in the generated nameofservice-mysql secret
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --storage mysql --mysql-host mysql.mysystemnamespace.svc --mysql-username apiserver --mysql-password secret --mysql-database apiserver
+
+# Build yaml resource config with the controller reading CLOUD_TOKEN from the existing cloud
+# secret and mounting the settings configmap
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --controller-secret cloud --controller-env CLOUD_TOKEN --controller-volume configmap:settings:/etc/settings
+
+# Build yaml resource config with 3 apiserver replicas of the high-priority PriorityClass and more
+# memory for the apiserver
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --apiserver-replicas 3 --apiserver-limits cpu=2,memory=1Gi --priority-class high-priority
+
+# Merge the changes of the project, e.g. a new API group, into the config edited since it was
+# generated, and fail in CI when the config is out of date
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --update
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --check
+
+# Build yaml resource config giving the insect group precedence over other groups in discovery.
+# The versionPriority of each version is computed from its maturity, GA > beta > alpha.
+apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag --group-priority-minimum insect=1000
 `,
 	Run: RunBuildResourceConfig,
 }
@@ -70,18 +123,29 @@ func AddBuildResourceConfig(cmd *cobra.Command) {
 }
 
 func AddBuildResourceConfigFlags(cmd *cobra.Command) {
-	cmd.Flags().StringSliceVar(&ControllerSecretEnv, "controller-env", []string{}, "")
-	cmd.Flags().StringVar(&ControllerSecret, "controller-secret", "", "")
-	cmd.Flags().StringVar(&ControllerSecretMount, "controller-secret-mount", "", "")
 	cmd.Flags().StringSliceVar(&ControllerArgs, "controller-args", []string{}, "")
 	cmd.Flags().StringSliceVar(&ApiserverArgs, "apiserver-args", []string{}, "")
 	cmd.Flags().StringVar(&Name, "name", "", "")
 	cmd.Flags().StringVar(&Namespace, "namespace", "", "")
 	cmd.Flags().StringSliceVar(&ImagePullSecrets, "image-pull-secrets", []string{}, "List of secret names for docker registry")
-	cmd.Flags().StringVar(&ServiceAccount, "service-account", "", "Name of service account that will be attached to deployed pod")
+	cmd.Flags().StringVar(&ServiceAccount, "service-account", "", "Name of an existing service account the apiserver and controller pods run as, defaults to the generated <name>-apiserver and <name>-controller service accounts")
 	cmd.Flags().StringVar(&Image, "image", "", "name of the apiserver Image with tag")
 	cmd.Flags().StringVar(&ResourceConfigDir, "output", "config", "directory to output resourceconfig")
 	cmd.Flags().StringVar(&StorageClass, "storage-class", "standard", "storageclass of which etcd is using to store data")
+	cmd.Flags().StringVar(&ConfigFormat, "format", ConfigFormat,
+		fmt.Sprintf("format of the resource config, one of %v", supportedConfigFormats))
+	cmd.Flags().StringVar(&ChartDir, "chart-dir", "", "directory of the chart for --format helm, defaults to charts/<name>")
+	cmd.Flags().StringVar(&ChartCerts, "chart-certs", ChartCerts,
+		fmt.Sprintf("how the chart provides the apiserver certificates for --format helm, one of %v", supportedChartCerts))
+	cmd.Flags().StringToIntVar(&GroupPriorityMinimum, "group-priority-minimum", map[string]int{},
+		"groupPriorityMinimum of the APIServices per API group, e.g. insect=1000, overrides the PROJECT file")
+	AddCertFlags(cmd)
+	AddEtcdFlags(cmd)
+	AddStorageFlags(cmd)
+	AddInjectionFlags(cmd)
+	AddSchedulingFlags(cmd)
+	AddLocalFlags(cmd)
+	AddUpdateFlags(cmd)
 }
 
 func RunBuildResourceConfig(cmd *cobra.Command, args []string) {
@@ -91,20 +155,76 @@ func RunBuildResourceConfig(cmd *cobra.Command, args []string) {
 	if len(Namespace) == 0 {
 		klog.Fatalf("must specify --namespace")
 	}
+	util.GetDomain()
+	validateUpdateFlags()
+	if Local {
+		if ConfigFormat != formatYAML {
+			klog.Fatalf("--local writes yaml, it conflicts with --format %s", ConfigFormat)
+		}
+		validateCertFlags()
+		if CertProvider != certProviderLocal {
+			klog.Fatalf("--local serves the locally generated certificates, it conflicts with --cert-provider %s",
+				CertProvider)
+		}
+		if !keepCerts("apiserver") {
+			createCerts()
+		}
+		buildLocalConfig()
+		reportConfig()
+		return
+	}
 	if len(Image) == 0 {
 		klog.Fatalf("Must specify --image")
 	}
-	util.GetDomain()
+	validateConfigFormat()
+	validateCertFlags()
+	validateEtcdFlags()
+	validateStorageFlags()
+	validateSchedulingFlags(cmd)
 
 	if _, err := os.Stat("pkg"); err != nil {
 		klog.Fatalf("could not find 'pkg' directory.  must run apiserver-boot init before generating config")
 	}
 
-	createCerts()
+	if CertProvider == certProviderLocal && ConfigFormat == formatKustomize {
+		createKustomizeCerts()
+	} else if CertProvider == certProviderLocal && (ConfigFormat != formatHelm || ChartCerts == chartCertsStatic) {
+		if !keepCerts("apiserver") {
+			createCerts()
+		}
+		if deployEtcd() && !keepCerts("etcd_client") {
+			createEtcdCerts()
+		}
+	}
 	buildResourceConfig()
+	reportConfig()
+}
+
+// keepCerts is true if --update renders the existing certificate name again, so the config only
+// changes with the project, and with --check, which writes nothing.  apiserver-boot certs rotate
+// renews the certificates.
+func keepCerts(name string) bool {
+	if CheckConfig {
+		return true
+	}
+	if !UpdateConfig {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(certificatesDir(), name+".crt"))
+	return err == nil
+}
+
+func validateConfigFormat() {
+	if !sets.NewString(supportedConfigFormats...).Has(ConfigFormat) {
+		klog.Fatalf("--format must be one of %v was (%s)", supportedConfigFormats, ConfigFormat)
+	}
+	if ConfigFormat == formatHelm {
+		validateChartFlags()
+	}
 }
 
-func getBase64(file string) string {
+// getBase64 returns the base64 encoded content of the files, concatenated
+func getBase64(files ...string) string {
 	//out, err := exec.Command("bash", "-c",
 	//	fmt.Sprintf("base64 %s | awk 'BEGIN{ORS=\"\";} {print}'", file)).CombinedOutput()
 	//if err != nil {
@@ -113,14 +233,21 @@ func getBase64(file string) string {
 
 	buff := bytes.Buffer{}
 	enc := base64.NewEncoder(base64.StdEncoding, &buff)
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		klog.Fatalf("Could not read file %s: %v", file, err)
-	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) && CheckConfig {
+			// --check does not compare the certificates, e.g. of a checkout without them
+			klog.Warningf("Missing %s, its value is not compared by --check", file)
+			return strings.Trim(redacted, `"`)
+		}
+		if err != nil {
+			klog.Fatalf("Could not read file %s: %v", file, err)
+		}
 
-	_, err = enc.Write(data)
-	if err != nil {
-		klog.Fatalf("Could not write bytes: %v", err)
+		_, err = enc.Write(data)
+		if err != nil {
+			klog.Fatalf("Could not write bytes: %v", err)
+		}
 	}
 	enc.Close()
 	return buff.String()
@@ -134,129 +261,102 @@ func getBase64(file string) string {
 
 func buildResourceConfig() {
 	initVersionedApis()
-	dir := filepath.Join(ResourceConfigDir, "certificates")
+	switch ConfigFormat {
+	case formatKustomize:
+		buildKustomizeConfig()
+		return
+	case formatHelm:
+		buildHelmChart()
+		return
+	}
+	dir := certificatesDir()
 
-	created := util.WriteIfNotFound(
+	created := writeConfig(
 		filepath.Join(ResourceConfigDir, "apiservice.yaml"),
-		"apiservice-config-template", apiserviceYamlTemplate, apiserviceYamlTemplateArgs{
-			Name:      Name,
-			Namespace: Namespace,
-			Domain:    util.Domain,
-			Versions:  Versions,
-			CACert:    getBase64(filepath.Join(dir, "apiserver_ca.crt")),
-		})
+		"apiservice-config-template", apiserviceYamlTemplate, newAPIServiceYamlTemplateArgs())
 	if !created {
 		klog.Warningf("Resource config already exists.")
 	}
 
+	if CertProvider == certProviderCertManager {
+		created = writeConfig(
+			filepath.Join(ResourceConfigDir, "certificates.yaml"),
+			"certificates-config-template", certManagerYamlTemplate, newCertManagerYamlTemplateArgs())
+		if !created {
+			klog.Warningf("Certificates config already exists.")
+		}
+	}
+
 	// build apiserver yaml config
-	created = util.WriteIfNotFound(
+	apiserverArgs := resourceConfigApiserverYamlArgs{
+		Name:             Name,
+		Namespace:        Namespace,
+		Image:            Image,
+		ApiserverArgs:    ApiserverArgs,
+		ImagePullSecrets: ImagePullSecrets,
+		ServiceAccount:   apiserverServiceAccount(),
+		GeneratedSecret:  CertProvider == certProviderCertManager,
+		EtcdArgs:         etcdApiserverArgs(),
+		EtcdClientSecret: etcdClientSecret(),
+		FilepathClaim:    filepathClaim(),
+		FilepathMount:    filepathMountPath(),
+		Inject:           apiserverInjection().yaml(8, 6),
+		Scheduling:       apiserverScheduling(),
+	}
+	if !apiserverArgs.GeneratedSecret {
+		apiserverArgs.ClientKey = getBase64(filepath.Join(dir, "apiserver.key"))
+		apiserverArgs.ClientCert = getBase64(filepath.Join(dir, "apiserver.crt"))
+	}
+	created = writeConfig(
 		filepath.Join(ResourceConfigDir, "aggregated-apiserver.yaml"),
-		"apiserver-config-template", resourceConfigApiserverYaml, resourceConfigApiserverYamlArgs{
-			Name:             Name,
-			Namespace:        Namespace,
-			Image:            Image,
-			ApiserverArgs:    ApiserverArgs,
-			ImagePullSecrets: ImagePullSecrets,
-			ServiceAccount:   ServiceAccount,
-			ClientKey:        getBase64(filepath.Join(dir, "apiserver.key")),
-			ClientCert:       getBase64(filepath.Join(dir, "apiserver.crt")),
-		})
+		"apiserver-config-template", resourceConfigApiserverYaml, apiserverArgs)
 	if !created {
 		klog.Warningf("Aggregated Apiserver config already exists.")
 	}
 
 	// build controller yaml config
-	created = util.WriteIfNotFound(
+	created = writeConfig(
 		filepath.Join(ResourceConfigDir, "controller-manager.yaml"),
 		"controller-config-template", resourceConfigControllerYaml, resourceConfigControllerYamlArgs{
 			Name:             Name,
 			Namespace:        Namespace,
 			Image:            Image,
-			ControllerArgs:   ControllerArgs,
+			ControllerArgs:   controllerArgs(),
 			ImagePullSecrets: ImagePullSecrets,
-			ServiceAccount:   ServiceAccount,
+			ServiceAccount:   controllerServiceAccount(),
+			Inject:           controllerInjection().yaml(8, 6),
+			Scheduling:       controllerScheduling(),
 		})
 	if !created {
 		klog.Warningf("Controller-manager config already exists.")
 	}
 
 	// build RBAC yaml config
-	created = util.WriteIfNotFound(
+	created = writeConfig(
 		filepath.Join(ResourceConfigDir, "rbac.yaml"),
-		"rbac-config-template", resourceConfigRBACYaml, resourceConfigRBACYamlArgs{
-			Name:      Name,
-			Namespace: Namespace,
-			Domain:    util.Domain,
-			Versions:  Versions,
-		})
+		"rbac-config-template", resourceConfigRBACYaml, newResourceConfigRBACYamlArgs())
 	if !created {
 		klog.Warningf("RBAC config already exists.")
 	}
 
-	// build etcd yaml config
-	created = util.WriteIfNotFound(
-		filepath.Join(ResourceConfigDir, "etcd.yaml"),
-		"etcd-config-template", etcdYaml, etcdYamlArgs{
-			Namespace:    Namespace,
-			StorageClass: StorageClass,
-		})
-	if !created {
-		klog.Warningf("ETCD config already exists.")
-	}
-}
-
-func createCerts() {
-	dir := filepath.Join(ResourceConfigDir, "certificates")
-	os.MkdirAll(dir, 0700)
-
-	svrName := fmt.Sprintf("%s.%s.svc", Name, Namespace)
-
-	if _, err := os.Stat(filepath.Join(dir, "apiserver_ca.crt")); os.IsNotExist(err) {
-		util.DoCmd("openssl", "req", "-x509",
-			"-newkey", "rsa:2048",
-			"-addext", "basicConstraints=critical,CA:TRUE,pathlen:1",
-			"-keyout", filepath.Join(dir, "apiserver_ca.key"),
-			"-out", filepath.Join(dir, "apiserver_ca.crt"),
-			"-days", "365",
-			"-nodes",
-			"-subj", fmt.Sprintf("/C=un/ST=st/L=l/O=o/OU=ou/CN=%s-certificate-authority", Name),
-		)
-	} else {
-		klog.Infof("Skipping generate CA cert.  File already exists.")
-	}
-
-	caCert, caKey, err := util.TryLoadCertAndKeyFromDisk(dir, "apiserver_ca")
-	if err != nil {
-		klog.Fatal(err)
-	}
-
-	apiserverCert, apiserverKey, err := util.NewCertAndKey(caCert, caKey, util.Config{
-		CommonName:   svrName,
-		Organization: []string{},
-		AltNames: util.AltNames{
-			DNSNames: []string{
-				"localhost",
-				svrName,
-			},
-			IPs: []net.IP{
-				net.ParseIP("127.0.0.1"),
-			},
-		},
-		Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-	})
-	if err != nil {
-		klog.Fatal(err)
+	// build storage yaml config
+	if needsStorageYaml() {
+		created = writeConfig(
+			filepath.Join(ResourceConfigDir, "storage.yaml"),
+			"storage-config-template", storageYaml, newStorageYamlArgs())
+		if !created {
+			klog.Warningf("Storage config already exists.")
+		}
 	}
 
-	apiserverCertData := util.EncodeCertPEM(apiserverCert)
-	apiserverKeyData := util.EncodePrivateKeyPEM(apiserverKey)
-
-	if err := ioutil.WriteFile(filepath.Join(dir, "apiserver.crt"), apiserverCertData, 0644); err != nil {
-		klog.Fatal(err)
-	}
-	if err := ioutil.WriteFile(filepath.Join(dir, "apiserver.key"), apiserverKeyData, 0644); err != nil {
-		klog.Fatal(err)
+	// build etcd yaml config
+	if deployEtcd() {
+		created = writeConfig(
+			filepath.Join(ResourceConfigDir, "etcd.yaml"),
+			"etcd-config-template", etcdYaml, newEtcdYamlArgs(CertProvider == certProviderCertManager))
+		if !created {
+			klog.Warningf("ETCD config already exists.")
+		}
 	}
 }
 
@@ -275,15 +375,27 @@ func initVersionedApis() {
 			versionMatch := regexp.MustCompile("^v\\d+(alpha\\d+|beta\\d+)*$")
 			for _, v := range versionFiles {
 				if v.IsDir() && versionMatch.MatchString(v.Name()) {
-					klog.Infof("\t%s.%s", g.Name(), v.Name())
-					Versions = append(Versions, schema.GroupVersion{
-						Group:   g.Name(),
-						Version: v.Name(),
-					})
+					group := g.Name() + "." + util.GetGroupDomain(g.Name())
+					api := APIVersion{
+						GroupVersion: schema.GroupVersion{Group: group, Version: v.Name()},
+						groupName:    g.Name(),
+					}
+					l, deprecated, err := util.GetVersionLifecycle(g.Name(), v.Name())
+					if err != nil {
+						klog.Fatal(err)
+					}
+					if deprecated {
+						api.deprecated = true
+						klog.Infof("\t%s/%s (deprecated in %s, removed in %s)", group, v.Name(), l.DeprecatedIn, l.RemovedIn)
+					} else {
+						klog.Infof("\t%s/%s", group, v.Name())
+					}
+					Versions = append(Versions, api)
 				}
 			}
 		}
 	}
+	setAPIPriorities()
 	u := map[string]bool{}
 	for _, a := range versionedAPIs {
 		u[path.Dir(a)] = true
@@ -303,9 +415,21 @@ type resourceConfigApiserverYamlArgs struct {
 	ApiserverArgs    []string
 	ClientCert       string
 	ClientKey        string
+	// GeneratedSecret is true if the certificates secret is generated by kustomize or cert-manager
+	GeneratedSecret bool
+	EtcdArgs        []string
+	// EtcdClientSecret is the secret with the etcd client certificate, empty without etcd TLS
+	EtcdClientSecret string
+	// FilepathClaim is the volume claim of the filepath storage, empty without the filepath storage
+	FilepathClaim string
+	FilepathMount string
+	// Inject holds the environment and volumes from the --apiserver flags
+	Inject     injectionYaml
+	Scheduling schedulingArgs
 }
 
-var resourceConfigApiserverYaml = `---
+var resourceConfigApiserverYaml = `{{ $config := . -}}
+---
 apiVersion: apps/v1
 kind: Deployment
 metadata:
@@ -319,7 +443,7 @@ spec:
     matchLabels:
       api: {{.Name}}
       apiserver: "true"
-  replicas: 1
+  replicas: {{ .Scheduling.Replicas }}
   template:
     metadata:
       labels:
@@ -328,23 +452,56 @@ spec:
     spec:
       {{- if .ImagePullSecrets }}
       imagePullSecrets:
-      {{range .ImagePullSecrets }}- name: {{.}}
-      {{ end }}
-      {{- end -}}
-      {{- if .ServiceAccount }}
-      serviceAccount: {{.ServiceAccount}}
+      {{- range .ImagePullSecrets }}
+      - name: {{.}}
+      {{- end }}
       {{- end }}
+      serviceAccountName: {{.ServiceAccount}}
+{{- if .Scheduling.PriorityClass }}
+      priorityClassName: {{ .Scheduling.PriorityClass }}
+{{- end }}
+{{- if .Scheduling.SpreadKeys }}
+      topologySpreadConstraints:
+{{- range .Scheduling.SpreadKeys }}
+      - maxSkew: 1
+        topologyKey: {{ . }}
+        whenUnsatisfiable: ScheduleAnyway
+        labelSelector:
+          matchLabels:
+            api: {{ $config.Name }}
+            apiserver: "true"
+{{- end }}
+{{- end }}
+{{- if .FilepathClaim }}
+      securityContext:
+        fsGroup: 65532
+{{- end }}
       containers:
       - name: apiserver
         image: {{.Image}}
+{{- if .Inject.Env }}
+        env:{{ .Inject.Env }}
+{{- end }}
+{{- if .Inject.EnvFrom }}
+        envFrom:{{ .Inject.EnvFrom }}
+{{- end }}
         volumeMounts:
         - name: apiserver-certs
           mountPath: /apiserver.local.config/certificates
           readOnly: true
+{{- if .FilepathClaim }}
+        - name: data
+          mountPath: {{ .FilepathMount }}
+{{- end }}
+{{- if .EtcdClientSecret }}
+        - name: etcd-client-certs
+          mountPath: ` + etcdCertsDir + `
+          readOnly: true
+{{- end }}{{ .Inject.VolumeMounts }}
         command:
         - "./apiserver"
-        args:
-        - "--etcd-servers=http://etcd-svc:2379"
+        args:{{ range $arg := .EtcdArgs }}
+        - "{{ $arg }}"{{ end }}
         - "--tls-cert-file=/apiserver.local.config/certificates/tls.crt"
         - "--tls-private-key-file=/apiserver.local.config/certificates/tls.key"
         - "--audit-log-path=-"
@@ -353,16 +510,66 @@ spec:
         - "--audit-log-maxbackup=0"{{ range $arg := .ApiserverArgs }}
         - "{{ $arg }}"{{ end }}
         resources:
+{{- if .Scheduling.Requests }}
           requests:
-            cpu: 100m
-            memory: 20Mi
+{{- range $resource, $quantity := .Scheduling.Requests }}
+            {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+{{- end }}
+{{- if .Scheduling.Limits }}
           limits:
-            cpu: 100m
-            memory: 30Mi
+{{- range $resource, $quantity := .Scheduling.Limits }}
+            {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+{{- end }}
+{{- if .Scheduling.Probes }}
+        readinessProbe:
+          httpGet:
+            path: /readyz
+            port: 443
+            scheme: HTTPS
+          periodSeconds: 10
+        livenessProbe:
+          httpGet:
+            path: /livez
+            port: 443
+            scheme: HTTPS
+          initialDelaySeconds: 15
+          periodSeconds: 10
+          failureThreshold: 6
+{{- end }}
       volumes:
       - name: apiserver-certs
         secret:
           secretName: {{ .Name }}
+{{- if .EtcdClientSecret }}
+      - name: etcd-client-certs
+        secret:
+          secretName: {{ .EtcdClientSecret }}
+{{- end }}
+{{- if .FilepathClaim }}
+      - name: data
+        persistentVolumeClaim:
+          claimName: {{ .FilepathClaim }}
+{{- end }}{{ .Inject.Volumes }}
+{{- if .Scheduling.DisruptionBudget }}
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: {{.Name}}-apiserver
+  namespace: {{.Namespace}}
+  labels:
+    api: {{.Name}}
+    apiserver: "true"
+spec:
+  maxUnavailable: 1
+  selector:
+    matchLabels:
+      api: {{.Name}}
+      apiserver: "true"
+{{- end }}
+{{- if not .GeneratedSecret }}
 ---
 apiVersion: v1
 kind: Secret
@@ -376,6 +583,7 @@ metadata:
 data:
   tls.crt: {{ .ClientCert }}
   tls.key: {{ .ClientKey }}
+{{- end }}
 ---
 apiVersion: v1
 kind: Service
@@ -402,9 +610,13 @@ type resourceConfigControllerYamlArgs struct {
 	ServiceAccount   string
 	ImagePullSecrets []string
 	ControllerArgs   []string
+	// Inject holds the environment and volumes from the --controller flags
+	Inject     injectionYaml
+	Scheduling schedulingArgs
 }
 
-var resourceConfigControllerYaml = `---
+var resourceConfigControllerYaml = `{{ $config := . -}}
+---
 apiVersion: apps/v1
 kind: Deployment
 metadata:
@@ -418,7 +630,7 @@ spec:
     matchLabels:
       api: {{.Name}}
       controller: "true"
-  replicas: 1
+  replicas: {{ .Scheduling.Replicas }}
   template:
     metadata:
       labels:
@@ -427,69 +639,161 @@ spec:
     spec:
       {{- if .ImagePullSecrets }}
       imagePullSecrets:
-      {{range .ImagePullSecrets }}- name: {{.}}
-      {{ end }}
-      {{- end -}}
-      {{- if .ServiceAccount }}
-      serviceAccount: {{.ServiceAccount}}
+      {{- range .ImagePullSecrets }}
+      - name: {{.}}
+      {{- end }}
       {{- end }}
+      serviceAccountName: {{.ServiceAccount}}
+{{- if .Scheduling.PriorityClass }}
+      priorityClassName: {{ .Scheduling.PriorityClass }}
+{{- end }}
+{{- if .Scheduling.SpreadKeys }}
+      topologySpreadConstraints:
+{{- range .Scheduling.SpreadKeys }}
+      - maxSkew: 1
+        topologyKey: {{ . }}
+        whenUnsatisfiable: ScheduleAnyway
+        labelSelector:
+          matchLabels:
+            api: {{ $config.Name }}
+            controller: "true"
+{{- end }}
+{{- end }}
       containers:
       - name: controller
         image: {{.Image}}
+{{- if .Inject.Env }}
+        env:{{ .Inject.Env }}
+{{- end }}
+{{- if .Inject.EnvFrom }}
+        envFrom:{{ .Inject.EnvFrom }}
+{{- end }}
+{{- if .Inject.VolumeMounts }}
+        volumeMounts:{{ .Inject.VolumeMounts }}
+{{- end }}
         command:
         - "./controller-manager"
         args:{{ range $arg := .ControllerArgs }}
         - "{{ $arg }}"{{ end }}
         resources:
+{{- if .Scheduling.Requests }}
           requests:
-            cpu: 100m
-            memory: 200Mi
+{{- range $resource, $quantity := .Scheduling.Requests }}
+            {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+{{- end }}
+{{- if .Scheduling.Limits }}
           limits:
-            cpu: 100m
-            memory: 300Mi
-      volumes:
-      - name: apiserver-certs
-        secret:
-          secretName: {{ .Name }}
+{{- range $resource, $quantity := .Scheduling.Limits }}
+            {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+{{- end }}
+{{- if .Inject.Volumes }}
+      volumes:{{ .Inject.Volumes }}
+{{- end }}
+{{- if .Scheduling.DisruptionBudget }}
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: {{.Name}}-controller
+  namespace: {{.Namespace}}
+  labels:
+    api: {{.Name}}
+    controller: "true"
+spec:
+  maxUnavailable: 1
+  selector:
+    matchLabels:
+      api: {{.Name}}
+      controller: "true"
+{{- end }}
 `
 
 type resourceConfigRBACYamlArgs struct {
 	Name      string
 	Namespace string
-	Domain    string
-	Versions  []schema.GroupVersion
+
+	ApiserverServiceAccount  string
+	ControllerServiceAccount string
+	// ServiceAccounts are the service accounts to create, none if --service-account is set
+	ServiceAccounts []string
+	// ControllerRules are the rendered rules of the controller ClusterRole
+	ControllerRules string
+	// ControllerRoles are the rendered rules of the controller Roles by namespace
+	ControllerRoles []controllerRoleArgs
+	// LeaderElectionRules are the rendered rules of the controller leader election Role, empty
+	// without leader election
+	LeaderElectionRules string
+	// Namespaced renders the resources in the namespace of the apiserver and the cluster scoped
+	// ones, OtherNamespaces the Roles and RoleBindings in kube-system and the namespaces of the
+	// controller rbac markers.  The kustomize base holds the former, an overlay moves them to its
+	// namespace.
+	Namespaced      bool
+	OtherNamespaces bool
 }
 
-var resourceConfigRBACYaml = `---
-{{ $config := . -}}
-apiVersion: rbac.authorization.k8s.io/v1
-kind: ClusterRole
+type controllerRoleArgs struct {
+	Namespace string
+	Rules     string
+}
+
+func newResourceConfigRBACYamlArgs() resourceConfigRBACYamlArgs {
+	a := resourceConfigRBACYamlArgs{
+		Name:                     Name,
+		Namespace:                Namespace,
+		ApiserverServiceAccount:  apiserverServiceAccount(),
+		ControllerServiceAccount: controllerServiceAccount(),
+		Namespaced:               true,
+		OtherNamespaces:          true,
+	}
+	if len(ServiceAccount) == 0 {
+		a.ServiceAccounts = []string{a.ApiserverServiceAccount, a.ControllerServiceAccount}
+	}
+	c := getControllerRBAC()
+	a.ControllerRules = rulesYaml(c.ClusterRules, 2)
+	for _, ns := range c.Namespaces() {
+		a.ControllerRoles = append(a.ControllerRoles, controllerRoleArgs{
+			Namespace: ns,
+			Rules:     rulesYaml(c.NamespacedRules[ns], 2),
+		})
+	}
+	if LeaderElection {
+		a.LeaderElectionRules = rulesYaml(leaderElectionRules, 2)
+	}
+	return a
+}
+
+var resourceConfigRBACYaml = `{{ $config := . -}}
+{{- if .Namespaced }}
+{{- range .ServiceAccounts }}
+---
+apiVersion: v1
+kind: ServiceAccount
 metadata:
-  name: {{.Name}}-apiserver-auth-reader
-rules:
-  - apiGroups:
-      - ""
-    resourceNames:
-      - extension-apiserver-authentication
-    resources:
-      - configmaps
-    verbs:
-      - get
-      - list
+  name: {{ . }}
+  namespace: {{ $config.Namespace }}
+  labels:
+    api: {{ $config.Name }}
+{{- end }}
+{{- end }}
+{{- if .OtherNamespaces }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
-kind: ClusterRoleBinding
+kind: RoleBinding
 metadata:
   name: {{.Name}}-apiserver-auth-reader
   namespace: kube-system
 roleRef:
   apiGroup: rbac.authorization.k8s.io
-  kind: ClusterRole
-  name: {{.Name}}-apiserver-auth-reader
+  kind: Role
+  name: extension-apiserver-authentication-reader
 subjects:
   - kind: ServiceAccount
-    namespace: default
-    name: default
+    namespace: {{.Namespace}}
+    name: {{.ApiserverServiceAccount}}
+{{- end }}
+{{- if .Namespaced }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRoleBinding
@@ -501,159 +805,135 @@ roleRef:
   name: system:auth-delegator
 subjects:
   - kind: ServiceAccount
-    namespace: default
-    name: default
+    namespace: {{.Namespace}}
+    name: {{.ApiserverServiceAccount}}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRole
 metadata:
-  name: {{.Name}}-controller
-  namespace: default
+  name: {{.Name}}-apiserver
 rules:
   - apiGroups:
-{{- range $api := .Versions }}
-      - '{{ $api.Group }}.{{ $config.Domain }}'
-{{- end }}
-    resources:
-      - '*'
-    verbs:
-      - '*'
-  - apiGroups:
-      - ''
+      - ""
     resources:
-      - 'configmaps'
-      - 'namespaces'
+      - namespaces
     verbs:
-      - 'get'
-      - 'list'
-      - 'watch'
+      - get
+      - list
+      - watch
   - apiGroups:
-      - 'admissionregistration.k8s.io'
+      - admissionregistration.k8s.io
     resources:
-      - '*'
+      - mutatingwebhookconfigurations
+      - validatingwebhookconfigurations
     verbs:
-      - 'list'
-      - 'watch'
-  - nonResourceURLs:
-      - '*'
-    verbs:
-      - '*'
-
+      - get
+      - list
+      - watch
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{.Name}}-apiserver
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{.Name}}-apiserver
+subjects:
+  - kind: ServiceAccount
+    namespace: {{.Namespace}}
+    name: {{.ApiserverServiceAccount}}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{.Name}}-controller
+rules:{{ .ControllerRules }}
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: ClusterRoleBinding
 metadata:
   name: {{.Name}}-controller
-  namespace: default
 roleRef:
   apiGroup: rbac.authorization.k8s.io
   kind: ClusterRole
   name: {{.Name}}-controller
 subjects:
   - kind: ServiceAccount
-    namespace: default
-    name: default
-`
-
-type etcdYamlArgs struct {
-	Namespace    string
-	StorageClass string
-}
-
-var etcdYaml = `---
-apiVersion: apps/v1
-kind: StatefulSet
+    namespace: {{.Namespace}}
+    name: {{.ControllerServiceAccount}}
+{{- end }}
+{{- range .ControllerRoles }}
+{{- if or (and $config.Namespaced (eq .Namespace $config.Namespace)) (and $config.OtherNamespaces (ne .Namespace $config.Namespace)) }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
 metadata:
-  name: etcd
+  name: {{ $config.Name }}-controller
   namespace: {{ .Namespace }}
-spec:
-  selector:
-    matchLabels:
-      app: etcd
-  serviceName: "etcd"
-  replicas: 1
-  template:
-    metadata:
-      labels:
-        app: etcd
-    spec:
-      terminationGracePeriodSeconds: 10
-      containers:
-      - name: etcd
-        image: quay.io/coreos/etcd:latest
-        imagePullPolicy: Always
-        resources:
-          requests:
-            cpu: 100m
-            memory: 20Mi
-          limits:
-            cpu: 100m
-            memory: 30Mi
-        env:
-        - name: ETCD_DATA_DIR
-          value: /etcd-data-dir
-        command:
-        - /usr/local/bin/etcd
-        - --listen-client-urls
-        - http://0.0.0.0:2379
-        - --advertise-client-urls
-        - http://localhost:2379
-        ports:
-        - containerPort: 2379
-        volumeMounts:
-        - name: etcd-data-dir
-          mountPath: /etcd-data-dir
-        readinessProbe:
-          httpGet:
-            port: 2379
-            path: /health
-          failureThreshold: 1
-          initialDelaySeconds: 10
-          periodSeconds: 10
-          successThreshold: 1
-          timeoutSeconds: 2
-        livenessProbe:
-          httpGet:
-            port: 2379
-            path: /health
-          failureThreshold: 3
-          initialDelaySeconds: 10
-          periodSeconds: 10
-          successThreshold: 1
-          timeoutSeconds: 2
-  volumeClaimTemplates:
-  - metadata:
-     name: etcd-data-dir
-     annotations:
-        volume.beta.kubernetes.io/storage-class: {{.StorageClass}}
-    spec:
-      accessModes: [ "ReadWriteOnce" ]
-      resources:
-        requests:
-         storage: 10Gi
+rules:{{ .Rules }}
 ---
-apiVersion: v1
-kind: Service
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
 metadata:
-  name: etcd-svc
+  name: {{ $config.Name }}-controller
   namespace: {{ .Namespace }}
-  labels:
-    app: etcd
-spec:
-  ports:
-  - port: 2379
-    name: etcd
-    targetPort: 2379
-  selector:
-    app: etcd
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: {{ $config.Name }}-controller
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $config.Namespace }}
+    name: {{ $config.ControllerServiceAccount }}
+{{- end }}
+{{- end }}
+{{- if and .Namespaced .LeaderElectionRules }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: {{.Name}}-controller-leader-election
+  namespace: {{.Namespace}}
+rules:{{ .LeaderElectionRules }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: {{.Name}}-controller-leader-election
+  namespace: {{.Namespace}}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: {{.Name}}-controller-leader-election
+subjects:
+  - kind: ServiceAccount
+    namespace: {{.Namespace}}
+    name: {{.ControllerServiceAccount}}
+{{- end }}
 `
 
 type apiserviceYamlTemplateArgs struct {
-	Versions  []schema.GroupVersion
+	// Versions holds the fully qualified API groups
+	Versions  []APIVersion
 	CACert    string
-	Domain    string
 	Name      string
 	Namespace string
+	// CertManager is true if cert-manager injects the CA bundle
+	CertManager bool
+}
+
+func newAPIServiceYamlTemplateArgs() apiserviceYamlTemplateArgs {
+	a := apiserviceYamlTemplateArgs{
+		Name:        Name,
+		Namespace:   Namespace,
+		Versions:    Versions,
+		CertManager: CertProvider == certProviderCertManager,
+	}
+	if !a.CertManager {
+		a.CACert = getBase64(caBundleFiles(certificatesDir())...)
+	}
+	return a
 }
 
 var apiserviceYamlTemplate = `
@@ -662,42 +942,51 @@ var apiserviceYamlTemplate = `
 apiVersion: apiregistration.k8s.io/v1
 kind: APIService
 metadata:
-  name: {{ $api.Version }}.{{ $api.Group }}.{{ $config.Domain }}
+  name: {{ $api.Version }}.{{ $api.Group }}
   labels:
     api: {{ $config.Name }}
     apiserver: "true"
+{{- if $config.CertManager }}
+  annotations:
+    cert-manager.io/inject-ca-from: {{ $config.Namespace }}/{{ $config.Name }}
+{{- end }}
 spec:
   version: {{ $api.Version }}
-  group: {{ $api.Group }}.{{ $config.Domain }}
-  groupPriorityMinimum: 2000
+  group: {{ $api.Group }}
+  groupPriorityMinimum: {{ $api.GroupPriorityMinimum }}
   service:
     name: {{ $config.Name }}
     namespace: {{ $config.Namespace }}
-  versionPriority: 10
+  versionPriority: {{ $api.VersionPriority }}
+{{- if not $config.CertManager }}
   caBundle: "{{ $config.CACert }}"
+{{- end }}
 ---
 {{ end -}}
 `
 
+// localConfigTemplate aggregates the apiserver started with run local.  The aggregator connects
+// to the externalName and the port of the Service, and verifies the serving certificate for
+// <name>.<namespace>.svc.
 var localConfigTemplate = `
 {{ $config := . -}}
 {{ range $api := .Versions -}}
-apiVersion: apiregistration.k8s.io/v1beta1
+apiVersion: apiregistration.k8s.io/v1
 kind: APIService
 metadata:
-  name: {{ $api.Version }}.{{ $api.Group }}.{{ $config.Domain }}
+  name: {{ $api.Version }}.{{ $api.Group }}
   labels:
     api: {{ $config.Name }}
     apiserver: "true"
 spec:
   version: {{ $api.Version }}
-  group: {{ $api.Group }}.{{ $config.Domain }}
-  groupPriorityMinimum: 2000
-  priority: 200
+  group: {{ $api.Group }}
+  groupPriorityMinimum: {{ $api.GroupPriorityMinimum }}
   service:
     name: {{ $config.Name }}
     namespace: {{ $config.Namespace }}
-  versionPriority: 10
+    port: {{ $config.Port }}
+  versionPriority: {{ $api.VersionPriority }}
   caBundle: "{{ $config.CACert }}"
 ---
 {{ end -}}
@@ -713,6 +1002,6 @@ spec:
   type: ExternalName
   externalName: "{{ .LocalIp }}"
   ports:
-  - port: 443
+  - port: {{ .Port }}
     protocol: TCP
 `
@@ -0,0 +1,348 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+const (
+	// certProviderLocal generates the certificates with build config and embeds them in the config
+	certProviderLocal = "local"
+	// certProviderCertManager has cert-manager issue the certificates in the cluster
+	certProviderCertManager = "cert-manager"
+)
+
+var supportedCertProviders = []string{certProviderLocal, certProviderCertManager}
+
+var CertProvider = certProviderLocal
+var KeyAlgorithm = util.KeyAlgorithmRSA
+var KeySize int
+var CertValidity = time.Hour * 24 * 365
+var CertDNSNames []string
+var CertIPs []string
+
+func AddCertFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&CertProvider, "cert-provider", CertProvider,
+		fmt.Sprintf("how the apiserver certificates are provided, one of %v", supportedCertProviders))
+	cmd.Flags().StringVar(&KeyAlgorithm, "key-algorithm", KeyAlgorithm,
+		fmt.Sprintf("algorithm of the generated private keys, one of %v", util.KeyAlgorithms))
+	cmd.Flags().IntVar(&KeySize, "key-size", 0,
+		"size of the generated private keys in bits, defaults to 2048 for rsa and 256 for ecdsa")
+	cmd.Flags().DurationVar(&CertValidity, "validity", CertValidity, "how long the generated certificates are valid")
+	cmd.Flags().StringSliceVar(&CertDNSNames, "cert-dns-names", []string{},
+		"additional DNS names of the apiserver serving certificate")
+	cmd.Flags().StringSliceVar(&CertIPs, "cert-ips", []string{},
+		"additional IP addresses of the apiserver serving certificate")
+}
+
+func validateCertFlags() {
+	if !sets.NewString(supportedCertProviders...).Has(CertProvider) {
+		klog.Fatalf("--cert-provider must be one of %v was (%s)", supportedCertProviders, CertProvider)
+	}
+	if CertProvider == certProviderCertManager && ConfigFormat == formatHelm {
+		if ChartCerts != chartCertsStatic && ChartCerts != chartCertsCertManager {
+			klog.Fatalf("--cert-provider cert-manager conflicts with --chart-certs %s", ChartCerts)
+		}
+		ChartCerts = chartCertsCertManager
+	}
+	if err := util.ValidateKeyConfig(KeyAlgorithm, KeySize); err != nil {
+		klog.Fatalf("invalid --key-algorithm or --key-size: %v", err)
+	}
+	if CertValidity <= 0 {
+		klog.Fatalf("--validity must be positive was (%s)", CertValidity)
+	}
+	for _, ip := range CertIPs {
+		if net.ParseIP(ip) == nil {
+			klog.Fatalf("--cert-ips must be a list of IP addresses was (%s)", ip)
+		}
+	}
+}
+
+// certConfig returns the key and validity settings of the generated certificates
+func certConfig(commonName string) util.Config {
+	return util.Config{
+		CommonName:   commonName,
+		KeyAlgorithm: KeyAlgorithm,
+		KeySize:      KeySize,
+		Validity:     CertValidity,
+	}
+}
+
+// createCerts creates the CA unless it exists and a serving certificate for the apiserver
+// service signed by it.
+func createCerts() {
+	dir := certificatesDir()
+	createCA(dir)
+	createServingCert(dir, dir, Namespace)
+}
+
+// createCA creates the CA of the apiserver in dir unless it exists
+func createCA(dir string) {
+	os.MkdirAll(dir, 0700)
+	if _, err := os.Stat(filepath.Join(dir, "apiserver_ca.crt")); err == nil {
+		klog.Infof("Skipping generate CA cert.  File already exists.")
+		return
+	}
+	caCert, caKey, err := util.NewCACertAndKey(certConfig(fmt.Sprintf("%s-certificate-authority", Name)))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if err := util.WriteCertAndKey(dir, "apiserver_ca", caCert, caKey); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+// servingCertName returns the name of the apiserver service in namespace, which the serving
+// certificate is issued for
+func servingCertName(namespace string) string {
+	return fmt.Sprintf("%s.%s.svc", Name, namespace)
+}
+
+// createServingCert creates the serving certificate of the apiserver service in namespace in dir,
+// signed by the CA in caDir
+func createServingCert(dir, caDir, namespace string) {
+	caCert, caKey, err := util.TryLoadCertAndKeyFromDisk(caDir, "apiserver_ca")
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	svrName := servingCertName(namespace)
+	cfg := certConfig(svrName)
+	cfg.AltNames.DNSNames = append([]string{"localhost", svrName}, CertDNSNames...)
+	cfg.AltNames.IPs = []net.IP{net.ParseIP("127.0.0.1")}
+	for _, ip := range CertIPs {
+		cfg.AltNames.IPs = append(cfg.AltNames.IPs, net.ParseIP(ip))
+	}
+	cfg.Usages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	apiserverCert, apiserverKey, err := util.NewCertAndKey(caCert, caKey, cfg)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	os.MkdirAll(dir, 0700)
+	if err := util.WriteCertAndKey(dir, "apiserver", apiserverCert, apiserverKey); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+// caBundleFiles returns the CA of the apiserver, followed by the previous CA while apiserver-boot
+// certs rotate is in progress, so the rendered caBundle trusts both like the rotated manifests
+func caBundleFiles(dir string) []string {
+	files := []string{filepath.Join(dir, "apiserver_ca.crt")}
+	previous := filepath.Join(dir, "apiserver_ca_previous.crt")
+	if _, err := os.Stat(previous); err == nil {
+		files = append(files, previous)
+	}
+	return files
+}
+
+type certManagerYamlTemplateArgs struct {
+	Name      string
+	Namespace string
+	// Duration is the validity of the certificates
+	Duration     string
+	KeyAlgorithm string
+	KeySize      int
+	DNSNames     []string
+	IPs          []string
+	// Etcd is true if cert-manager also issues the certificates of the etcd StatefulSet
+	Etcd         bool
+	EtcdDNSNames []string
+	// EtcdServingSecrets are the secrets of the certificates etcd serves clients and peers with
+	EtcdServingSecrets []string
+	EtcdClientSecret   string
+}
+
+func newCertManagerYamlTemplateArgs() certManagerYamlTemplateArgs {
+	algorithms := map[string]string{
+		util.KeyAlgorithmRSA:     "RSA",
+		util.KeyAlgorithmECDSA:   "ECDSA",
+		util.KeyAlgorithmEd25519: "Ed25519",
+	}
+	return certManagerYamlTemplateArgs{
+		Name:         Name,
+		Namespace:    Namespace,
+		Duration:     CertValidity.String(),
+		KeyAlgorithm: algorithms[KeyAlgorithm],
+		KeySize:      KeySize,
+		DNSNames:     CertDNSNames,
+		IPs:          CertIPs,
+
+		Etcd:               deployEtcd(),
+		EtcdDNSNames:       etcdDNSNames(Namespace),
+		EtcdServingSecrets: []string{etcdServerSecret, etcdPeerSecret},
+		EtcdClientSecret:   etcdClientSecret(),
+	}
+}
+
+// certManagerYamlTemplate has cert-manager issue a CA from a self-signed issuer and the serving
+// certificate of the apiserver from the CA.  The APIServices get the CA injected from the
+// serving certificate.  The etcd certificates are issued from a separate etcd CA.
+var certManagerYamlTemplate = `{{ $config := . -}}
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: {{ .Name }}-selfsigned
+  namespace: {{ .Namespace }}
+spec:
+  selfSigned: {}
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ .Name }}-ca
+  namespace: {{ .Namespace }}
+spec:
+  isCA: true
+  commonName: {{ .Name }}-certificate-authority
+  secretName: {{ .Name }}-ca
+  duration: {{ .Duration }}
+  privateKey:
+    algorithm: {{ .KeyAlgorithm }}
+{{- if .KeySize }}
+    size: {{ .KeySize }}
+{{- end }}
+  issuerRef:
+    name: {{ .Name }}-selfsigned
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: {{ .Name }}-ca
+  namespace: {{ .Namespace }}
+spec:
+  ca:
+    secretName: {{ .Name }}-ca
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+spec:
+  commonName: {{ .Name }}.{{ .Namespace }}.svc
+  dnsNames:
+  - localhost
+  - {{ .Name }}.{{ .Namespace }}.svc
+{{- range .DNSNames }}
+  - {{ . }}
+{{- end }}
+  ipAddresses:
+  - 127.0.0.1
+{{- range .IPs }}
+  - {{ . }}
+{{- end }}
+  secretName: {{ .Name }}
+  duration: {{ .Duration }}
+  privateKey:
+    algorithm: {{ .KeyAlgorithm }}
+{{- if .KeySize }}
+    size: {{ .KeySize }}
+{{- end }}
+  usages:
+  - server auth
+  - client auth
+  issuerRef:
+    name: {{ .Name }}-ca
+{{- if .Etcd }}
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: etcd-ca
+  namespace: {{ .Namespace }}
+spec:
+  isCA: true
+  commonName: etcd-certificate-authority
+  secretName: etcd-ca
+  duration: {{ .Duration }}
+  privateKey:
+    algorithm: {{ .KeyAlgorithm }}
+{{- if .KeySize }}
+    size: {{ .KeySize }}
+{{- end }}
+  issuerRef:
+    name: {{ .Name }}-selfsigned
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: etcd-ca
+  namespace: {{ .Namespace }}
+spec:
+  ca:
+    secretName: etcd-ca
+{{- range $secret := .EtcdServingSecrets }}
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ $secret }}
+  namespace: {{ $config.Namespace }}
+spec:
+  commonName: {{ $secret }}
+  dnsNames:
+{{- range $config.EtcdDNSNames }}
+  - "{{ . }}"
+{{- end }}
+  ipAddresses:
+  - 127.0.0.1
+  secretName: {{ $secret }}
+  duration: {{ $config.Duration }}
+  privateKey:
+    algorithm: {{ $config.KeyAlgorithm }}
+{{- if $config.KeySize }}
+    size: {{ $config.KeySize }}
+{{- end }}
+  usages:
+  - server auth
+  - client auth
+  issuerRef:
+    name: etcd-ca
+{{- end }}
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ .EtcdClientSecret }}
+  namespace: {{ .Namespace }}
+spec:
+  commonName: {{ .Name }}-apiserver
+  secretName: {{ .EtcdClientSecret }}
+  duration: {{ .Duration }}
+  privateKey:
+    algorithm: {{ .KeyAlgorithm }}
+{{- if .KeySize }}
+    size: {{ .KeySize }}
+{{- end }}
+  usages:
+  - client auth
+  issuerRef:
+    name: etcd-ca
+{{- end }}
+`
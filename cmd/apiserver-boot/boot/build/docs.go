@@ -17,27 +17,47 @@ limitations under the License.
 package build
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
-	"k8s.io/klog"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
-
-	"bytes"
+	"time"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 )
 
 var docsCmd = &cobra.Command{
 	Use:   "docs",
 	Short: "Generate API reference docs from the openapi spec.",
-	Long:  `Generate API reference docs from the openapi spec.`,
+	Long: `Generate HTML and Markdown API reference docs from the openapi spec to <output-dir>/build.
+
+The kinds are documented by group version with the descriptions of their fields, the examples
+under <output-dir>/examples and the markdown under <output-dir>/static_includes.
+
+apiserver-runtime has no flag printing the openapi spec, so it is fetched from /openapi/v2 of
+the apiserver, which build docs runs along with an etcd unless --etcd is set.  To build the docs
+without etcd pass a saved spec with --build-openapi=false and --openapi-spec.
+
+The static includes are rendered to HTML with a subset of markdown: headings, paragraphs,
+bulleted lists, fenced code blocks, inline code, bold text and links.  Nested list items are
+flattened, and ordered lists, italics and tables are rendered as plain paragraphs, write them
+in HTML instead.`,
 	Example: `# Edit docs examples
 nano -w docs/examples/<kind>/<kind.yaml
 
-# Start a new server, get the swagger.json, and generate docs from the swagger.json
+# Start the apiserver and etcd, get the swagger.json from /openapi/v2, and generate docs from it
 apiserver-boot build executables
 apiserver-boot build docs
 
@@ -48,29 +68,34 @@ apiserver-boot build docs --operations=true
 # of getting it from a server.
 apiserver-boot build docs --build-openapi=false
 
+# Use the openapi spec served by a running apiserver
+kubectl get --raw /openapi/v2 > swagger.json
+apiserver-boot build docs --build-openapi=false --openapi-spec swagger.json
+
 # Use the server at my/bin/apiserver
 apiserver-boot build docs --server my/bin/apiserver
 
-# Instead of generating the table of contents, use the statically defined configuration
-# from docs/config.yaml
-# See an example config.yaml at in kubernetes-incubator/reference-docs
-apiserver-boot build docs --generate-toc=false
+# Use a running etcd instead of starting one
+apiserver-boot build docs --etcd http://localhost:2379
+
+# Only build the Markdown docs, e.g. to publish them with the repository
+apiserver-boot build docs --formats markdown
 
 # Add manual documentation to the generated docs
 # Edit docs/static_includes/*.md
-# e.g. docs/static_include/_overview.md
+# e.g. docs/static_includes/_overview.md
 
-	# <strong>API OVERVIEW</strong>
+	# API Overview
 	Add your markdown here
 
-# Add examples in the right-most column
-# Edit docs/examples/<type>/<type>.yaml
-# e.g. docs/examples/pod/pod.yaml
+# Add examples of the kinds
+# Edit docs/examples/<kind>/<kind>.yaml
+# e.g. docs/examples/deepone/deepone.yaml
 
 	note: <Description of example>.
 	sample: |
 	  apiVersion: <version>
-	  kind: <type>
+	  kind: <kind>
 	  metadata:
 	    name: <name>
 	  spec:
@@ -78,20 +103,27 @@ apiserver-boot build docs --generate-toc=false
 	Run: RunDocs,
 }
 
-var operations, buildOpenapi, generateToc bool
+var operations, buildOpenapi bool
 var server string
+var serverArgs []string
 var disableDelegatedAuth bool
-var cleanup bool
+var openapiEtcd string
 var outputDir string
+var openapiSpecFile string
+var docsFormats []string
+
+var supportedDocsFormats = []string{"html", "markdown"}
 
 func AddDocs(cmd *cobra.Command) {
 	docsCmd.Flags().StringVar(&server, "server", "bin/apiserver", "path to apiserver binary to run to get swagger.json")
-	docsCmd.Flags().BoolVar(&cleanup, "cleanup", true, "If true, cleanup intermediary files")
-	docsCmd.Flags().BoolVar(&buildOpenapi, "build-openapi", true, "If true, run the server and get the new swagger.json")
+	docsCmd.Flags().StringSliceVar(&serverArgs, "server-args", nil, "additional flags of the apiserver run to get swagger.json")
+	docsCmd.Flags().BoolVar(&buildOpenapi, "build-openapi", true, "If true, start the server and get the new swagger.json from /openapi/v2")
+	docsCmd.Flags().StringVar(&openapiSpecFile, "openapi-spec", "", "the swagger.json to generate docs from, <output-dir>/openapi-spec/swagger.json by default")
 	docsCmd.Flags().BoolVar(&operations, "operations", false, "if true, include operations in docs.")
-	docsCmd.Flags().BoolVar(&generateToc, "generate-toc", true, "If true, generate the table of contents from the api groups instead of using a statically configured ToC.")
-	docsCmd.Flags().BoolVar(&disableDelegatedAuth, "disable-delegated-auth", true, "If true, disable delegated auth in the apiserver with --delegated-auth=false.")
+	docsCmd.Flags().BoolVar(&disableDelegatedAuth, "disable-delegated-auth", true, "If true, start the server with --standalone-debug-mode, which requires WithLocalDebugExtension() in the apiserver, instead of delegating auth with the flags of --server-args.")
+	docsCmd.Flags().StringVar(&openapiEtcd, "etcd", "", "etcd servers of the server started to get swagger.json, if empty an etcd is started")
 	docsCmd.Flags().StringVar(&outputDir, "output-dir", "docs", "Build docs into this directory")
+	docsCmd.Flags().StringSliceVar(&docsFormats, "formats", supportedDocsFormats, "formats of the docs, any of html and markdown")
 	cmd.AddCommand(docsCmd)
 	docsCmd.AddCommand(docsCleanCmd)
 }
@@ -106,121 +138,189 @@ var docsCleanCmd = &cobra.Command{
 
 func RunCleanDocs(cmd *cobra.Command, args []string) {
 	os.RemoveAll(filepath.Join(outputDir, "build"))
-	os.RemoveAll(filepath.Join(outputDir, "includes"))
-	os.Remove(filepath.Join(outputDir, "manifest.json"))
 }
 
 func RunDocs(cmd *cobra.Command, args []string) {
-	klog.Fatal("Docs is not yet supported")
 	if len(server) == 0 && buildOpenapi {
 		klog.Fatal("Must specifiy --server or --build-openapi=false")
 	}
+	formats := sets.NewString(docsFormats...)
+	if formats.Len() == 0 || !sets.NewString(supportedDocsFormats...).IsSuperset(formats) {
+		klog.Fatalf("--formats must be any of %v was (%v)", supportedDocsFormats, docsFormats)
+	}
+	if len(openapiSpecFile) == 0 {
+		openapiSpecFile = filepath.Join(outputDir, "openapi-spec", "swagger.json")
+	}
 
-	os.RemoveAll(filepath.Join(outputDir, "includes"))
-	os.MkdirAll(filepath.Join(outputDir, "openapi-spec"), 0700)
-	os.MkdirAll(filepath.Join(outputDir, "static_includes"), 0700)
-	os.MkdirAll(filepath.Join(outputDir, "examples"), 0700)
-
-	// Build the swagger.json
 	if buildOpenapi {
-		flags := []string{
-			"--etcd-servers=http://localhost:2379",
-			"--secure-port=9443",
-			"--print-openapi",
-		}
-
-		if disableDelegatedAuth {
-			flags = append(flags, "--delegated-auth=false")
-		}
-
-		etcdStopFunc := RunEtcd()
-		defer etcdStopFunc()
-		klog.Infof("starting local etcd...")
-
-		c := exec.Command(server,
-			flags...,
-		)
-		klog.Infof("%s", strings.Join(c.Args, " "))
+		getOpenapi()
+	}
+	data, err := ioutil.ReadFile(openapiSpecFile)
+	if err != nil {
+		klog.Fatalf("Could not read the openapi spec, get it with --build-openapi: %v", err)
+	}
+	spec, err := parseOpenapiSpec(data)
+	if err != nil {
+		klog.Fatalf("%s: %v", openapiSpecFile, err)
+	}
+	docs, err := buildAPIDocs(spec, outputDir, operations)
+	if err != nil {
+		klog.Fatalf("%s: %v", openapiSpecFile, err)
+	}
 
-		var b bytes.Buffer
-		c.Stdout = &b
-		c.Stderr = os.Stderr
+	buildDir := filepath.Join(outputDir, "build")
+	os.RemoveAll(buildDir)
+	if err := writeAPIDocs(docs, buildDir, formats.Has("markdown"), formats.Has("html")); err != nil {
+		klog.Fatalf("Could not write the docs: %v", err)
+	}
 
-		err := c.Run()
-		if err != nil {
-			klog.Fatalf("error: %v", err)
-		}
+	// create the static includes once, for the user to fill in
+	util.WriteIfNotFound(filepath.Join(outputDir, "static_includes", "_overview.md"),
+		"overview-include-template", overviewIncludeTemplate, nil)
+	for _, gv := range docs.GroupVersions {
+		util.WriteIfNotFound(filepath.Join(outputDir, "static_includes", "_"+gv.groupName()+".md"),
+			"group-include-template", groupIncludeTemplate, nil)
+	}
+	os.MkdirAll(filepath.Join(outputDir, "examples"), 0700)
 
-		err = ioutil.WriteFile(filepath.Join(outputDir, "openapi-spec", "swagger.json"), b.Bytes(), 0644)
-		if err != nil {
-			klog.Fatalf("error: %v", err)
+	for _, format := range formats.List() {
+		index := "index.html"
+		if format == "markdown" {
+			index = "index.md"
 		}
+		klog.Infof("Wrote the %s docs to %s", format, filepath.Join(buildDir, index))
 	}
+}
 
-	// Build the docs
-	dir, err := os.Executable()
-	if err != nil {
-		klog.Fatalf("error: %v", err)
+// getOpenapi starts the apiserver, gets its openapi spec from /openapi/v2 and writes it to
+// --openapi-spec
+func getOpenapi() {
+	flags := serverArgs
+	if disableDelegatedAuth {
+		help, _ := exec.Command(server, "-h").CombinedOutput()
+		if !bytes.Contains(help, []byte("--standalone-debug-mode")) {
+			klog.Fatalf("%s does not support --standalone-debug-mode, build it with WithLocalDebugExtension() "+
+				"or set --disable-delegated-auth=false and the delegation flags with --server-args", server)
+		}
+		flags = append([]string{"--standalone-debug-mode", "--bind-address=127.0.0.1"}, flags...)
 	}
-	dir = filepath.Dir(dir)
-	c := exec.Command(filepath.Join(dir, "gen-apidocs"),
-		fmt.Sprintf("--build-operations=%v", operations),
-		fmt.Sprintf("--use-tags=%v", generateToc),
-		"--allow-errors",
-		"--config-dir="+outputDir)
-	klog.Infof("%s", strings.Join(c.Args, " "))
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	err = c.Run()
+	spec, err := fetchOpenapi(flags)
 	if err != nil {
-		klog.Fatalf("error: %v", err)
+		klog.Fatalf("Could not get the openapi spec: %v", err)
 	}
 
-	wd, err := os.Getwd()
+	os.MkdirAll(filepath.Dir(openapiSpecFile), 0700)
+	err = ioutil.WriteFile(openapiSpecFile, spec, 0644)
 	if err != nil {
 		klog.Fatalf("error: %v", err)
 	}
+}
 
-	// Run the docker command to build the docs
-	c = exec.Command("docker", "run",
-		"-v", fmt.Sprintf("%s:%s", filepath.Join(wd, outputDir, "includes"), "/source"),
-		"-v", fmt.Sprintf("%s:%s", filepath.Join(wd, outputDir, "build"), "/build"),
-		"-v", fmt.Sprintf("%s:%s", filepath.Join(wd, outputDir), "/manifest"),
-		"pwittrock/brodocs",
-	)
-	klog.Infof(strings.Join(c.Args, " "))
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	err = c.Run()
+// fetchOpenapi runs the apiserver with flags until it serves its openapi spec.  It serves on a free
+// port of 127.0.0.1 with self-signed certificates, and with an etcd started for it unless --etcd
+// is set.  Both are stopped before it returns.
+func fetchOpenapi(flags []string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir, err := ioutil.TempDir("", "apiserver-boot-docs")
 	if err != nil {
-		klog.Fatalf("error: %v", err)
+		return nil, err
 	}
-
-	// Cleanup intermediate files
-	if cleanup {
-		os.RemoveAll(filepath.Join(wd, outputDir, "includes"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "manifest.json"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "openapi-spec"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "build", "documents"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "build", "runbrodocs.sh"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "build", "node_modules", "marked", "Makefile"))
+	defer os.RemoveAll(dir)
+
+	etcdServers := openapiEtcd
+	if len(etcdServers) == 0 {
+		etcdServers = fmt.Sprintf("http://127.0.0.1:%d", freePort())
+		c := exec.CommandContext(ctx, "etcd",
+			"--data-dir", filepath.Join(dir, "etcd"),
+			"--listen-client-urls", etcdServers,
+			"--advertise-client-urls", etcdServers,
+			"--listen-peer-urls", fmt.Sprintf("http://127.0.0.1:%d", freePort()))
+		klog.Infof("%s", strings.Join(c.Args, " "))
+		if err := c.Start(); err != nil {
+			return nil, fmt.Errorf("could not start etcd, install it or set --etcd: %v", err)
+		}
+		defer func() {
+			cancel()
+			c.Wait()
+		}()
 	}
-}
-
-func RunEtcd() func() {
-	etcdCmd := exec.Command("etcd")
 
-	klog.Infof("%s", strings.Join(etcdCmd.Args, " "))
+	port := freePort()
+	var out bytes.Buffer
+	c := exec.CommandContext(ctx, server, append([]string{
+		fmt.Sprintf("--etcd-servers=%s", etcdServers),
+		fmt.Sprintf("--secure-port=%d", port),
+		fmt.Sprintf("--cert-dir=%s", filepath.Join(dir, "certificates")),
+		"--feature-gates=APIPriorityAndFairness=false",
+	}, flags...)...)
+	c.Stdout = &out
+	c.Stderr = &out
+	klog.Infof("%s", strings.Join(c.Args, " "))
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan struct{})
 	go func() {
-		err := etcdCmd.Run()
+		c.Wait()
+		close(exited)
+	}()
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	url := fmt.Sprintf("https://127.0.0.1:%d/openapi/v2", port)
+	var spec []byte
+	err = wait.PollImmediate(time.Second, openapiTimeout, func() (bool, error) {
+		select {
+		case <-exited:
+			return false, fmt.Errorf("%s exited", server)
+		default:
+		}
+		resp, err := client.Get(url)
 		if err != nil {
-			klog.Fatalf("Failed to run etcd %v", err)
-			os.Exit(-1)
+			return false, nil
 		}
-	}()
-	return func() {
-		if etcdCmd.Process != nil {
-			etcdCmd.Process.Kill()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, nil
 		}
+		spec, err = ioutil.ReadAll(resp.Body)
+		return err == nil, nil
+	})
+	if err != nil {
+		// the output is complete once the apiserver exited
+		cancel()
+		<-exited
+		return nil, fmt.Errorf("%s: %v\n%s", url, err, out.String())
+	}
+	return spec, nil
+}
+
+// openapiTimeout is how long getOpenapi waits for the apiserver to serve its openapi spec
+var openapiTimeout = 2 * time.Minute
+
+// freePort returns a free tcp port of 127.0.0.1
+func freePort() int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		klog.Fatalf("Could not find a free port: %v", err)
 	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
 }
+
+var overviewIncludeTemplate = `<!--
+The markdown of this file is included at the top of the API reference.
+-->
+`
+
+var groupIncludeTemplate = `<!--
+The markdown of this file is included at the top of the pages of the versions of the group.
+-->
+`
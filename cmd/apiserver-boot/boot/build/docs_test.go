@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIDocs(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "docs", "swagger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := parseOpenapiSpec(data)
+	if err != nil {
+		t.Fatalf("parseOpenapiSpec: %v", err)
+	}
+	docs, err := buildAPIDocs(spec, filepath.Join("testdata", "docs"), true)
+	if err != nil {
+		t.Fatalf("buildAPIDocs: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "apidocs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeAPIDocs(docs, dir, true, true); err != nil {
+		t.Fatalf("writeAPIDocs: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "docs", "build")
+	for _, page := range []string{
+		"index.md",
+		"index.html",
+		"innsmouth.example.com/v1.md",
+		"innsmouth.example.com/v1.html",
+		"kingsport.example.com/v1.md",
+		"kingsport.example.com/v1.html",
+	} {
+		out, err := ioutil.ReadFile(filepath.Join(dir, page))
+		if err != nil {
+			t.Fatalf("reading %s: %v", page, err)
+		}
+		checkGoldenFile(t, filepath.Join(golden, page+".golden"), out)
+	}
+}
+
+func TestAPIDocsWithoutKinds(t *testing.T) {
+	spec, err := parseOpenapiSpec([]byte(`{"definitions": {"io.k8s.apimachinery.pkg.apis.meta.v1.DeleteOptions": {
+		"x-kubernetes-group-version-kind": [{"group": "", "kind": "DeleteOptions", "version": "v1"}]}}}`))
+	if err != nil {
+		t.Fatalf("parseOpenapiSpec: %v", err)
+	}
+	if _, err := buildAPIDocs(spec, "testdata", false); err == nil {
+		t.Errorf("buildAPIDocs of a spec without kinds of the project succeeded")
+	}
+	if _, err := parseOpenapiSpec([]byte(`{"paths": {}}`)); err == nil {
+		t.Errorf("parseOpenapiSpec of a spec without definitions succeeded")
+	}
+}
+
+func TestMarkdownHTML(t *testing.T) {
+	src := "# Title\n\nSome **bold** `<code>` and a [link](https://example.com)\nwrapped.\n\n" +
+		"- one\n- two\n\n```\na < b\n```\n<div>html</div>\n<table>\n  <tr><td>**kept**</td></tr>\n</table>\n\n" +
+		"text <em>inline</em>\n<br>\n"
+	want := "<h1>Title</h1>\n" +
+		"<p>Some <strong>bold</strong> <code>&lt;code&gt;</code> and a <a href=\"https://example.com\">link</a>\nwrapped.</p>\n" +
+		"<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n" +
+		"<pre><code>a &lt; b\n</code></pre>\n" +
+		"<div>html</div>\n<table>\n  <tr><td>**kept**</td></tr>\n</table>\n" +
+		"<p>text <em>inline</em>\n<br></p>\n"
+	if got := string(markdownHTML(src)); got != want {
+		t.Errorf("markdownHTML\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,424 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+const (
+	// etcdCertsDir is where the apiserver mounts the etcd client certificate
+	etcdCertsDir = "/etcd-certs"
+	// etcdServerSecret and etcdPeerSecret hold the certificates etcd serves clients and peers with
+	etcdServerSecret = "etcd-server"
+	etcdPeerSecret   = "etcd-peer"
+)
+
+var EtcdReplicas = 1
+var EtcdImage = "quay.io/coreos/etcd:v3.4.13"
+var EtcdServers []string
+var EtcdClientSecret string
+
+func AddEtcdFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&EtcdReplicas, "etcd-replicas", EtcdReplicas,
+		"number of etcd members, an odd number such as 3 or 5 tolerates the loss of a minority")
+	cmd.Flags().StringVar(&EtcdImage, "etcd-image", EtcdImage, "image of the etcd members")
+	cmd.Flags().StringSliceVar(&EtcdServers, "etcd-servers", []string{},
+		"URLs of an existing etcd cluster the apiserver connects to instead of deploying etcd")
+	cmd.Flags().StringVar(&EtcdClientSecret, "etcd-client-secret", "",
+		"name of an existing secret with the ca.crt, tls.crt and tls.key the apiserver connects to --etcd-servers with")
+}
+
+func validateEtcdFlags() {
+	if EtcdReplicas < 1 {
+		klog.Fatalf("--etcd-replicas must be at least 1 was (%d)", EtcdReplicas)
+	}
+	if EtcdReplicas%2 == 0 {
+		klog.Warningf("--etcd-replicas %d tolerates no more failed members than %d", EtcdReplicas, EtcdReplicas-1)
+	}
+	if _, tag, digest := splitImage(EtcdImage); digest == "" && (tag == "" || tag == "latest") {
+		klog.Warningf("--etcd-image %s is not pinned to a version", EtcdImage)
+	}
+	for _, s := range EtcdServers {
+		if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			klog.Fatalf("--etcd-servers must be a list of http:// or https:// URLs was (%s)", s)
+		}
+	}
+	if len(EtcdClientSecret) > 0 && !externalEtcd() {
+		klog.Fatalf("--etcd-client-secret requires --etcd-servers")
+	}
+}
+
+// externalEtcd is true if the apiserver connects to an existing etcd cluster instead of
+// the etcd StatefulSet
+func externalEtcd() bool {
+	return len(EtcdServers) > 0
+}
+
+// etcdClientSecret returns the secret with the client certificate of the apiserver, empty if
+// the apiserver connects to etcd without TLS or not at all
+func etcdClientSecret() string {
+	if !usesEtcd() {
+		return ""
+	}
+	if externalEtcd() {
+		return EtcdClientSecret
+	}
+	return Name + "-etcd-client"
+}
+
+// etcdServers returns the --etcd-servers of the apiserver
+func etcdServers() string {
+	if externalEtcd() {
+		return strings.Join(EtcdServers, ",")
+	}
+	return "https://etcd-svc:2379"
+}
+
+// etcdApiserverArgs returns the etcd flags of the apiserver, none if no resource is stored in etcd
+func etcdApiserverArgs() []string {
+	if !usesEtcd() {
+		return nil
+	}
+	args := []string{"--etcd-servers=" + etcdServers()}
+	if len(etcdClientSecret()) > 0 {
+		args = append(args,
+			"--etcd-cafile="+etcdCertsDir+"/ca.crt",
+			"--etcd-certfile="+etcdCertsDir+"/tls.crt",
+			"--etcd-keyfile="+etcdCertsDir+"/tls.key")
+	}
+	return args
+}
+
+// etcdDNSNames returns the names the etcd members in namespace are reached at by the apiserver,
+// through the etcd-svc Service, and by each other, through the headless etcd Service.
+func etcdDNSNames(namespace string) []string {
+	return []string{
+		"localhost",
+		"etcd-svc",
+		fmt.Sprintf("etcd-svc.%s", namespace),
+		fmt.Sprintf("etcd-svc.%s.svc", namespace),
+		"*.etcd",
+		fmt.Sprintf("*.etcd.%s", namespace),
+		fmt.Sprintf("*.etcd.%s.svc", namespace),
+	}
+}
+
+// etcdInitialCluster returns the --initial-cluster the etcd members bootstrap with.  The members
+// read their namespace from the POD_NAMESPACE environment, so a kustomize overlay may move them.
+func etcdInitialCluster() string {
+	var members []string
+	for i := 0; i < EtcdReplicas; i++ {
+		members = append(members, fmt.Sprintf("etcd-%d=https://etcd-%d.etcd.$(POD_NAMESPACE).svc:2380", i, i))
+	}
+	return strings.Join(members, ",")
+}
+
+// createEtcdCerts creates the etcd CA unless it exists, and the serving and peer certificates
+// of the etcd members and the client certificate of the apiserver signed by it.  etcd only
+// trusts its own CA, so the apiserver CA can not be used to access etcd.
+func createEtcdCerts() {
+	dir := certificatesDir()
+	createEtcdCA(dir)
+	createEtcdClientCert(dir)
+	createEtcdMemberCerts(dir, dir, Namespace)
+}
+
+// createEtcdCA creates the etcd CA in dir unless it exists
+func createEtcdCA(dir string) {
+	os.MkdirAll(dir, 0700)
+	if _, err := os.Stat(filepath.Join(dir, "etcd_ca.crt")); err == nil {
+		klog.Infof("Skipping generate etcd CA cert.  File already exists.")
+		return
+	}
+	caCert, caKey, err := util.NewCACertAndKey(certConfig("etcd-certificate-authority"))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if err := util.WriteCertAndKey(dir, "etcd_ca", caCert, caKey); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+// createEtcdClientCert creates the client certificate of the apiserver in dir, signed by the etcd
+// CA in dir
+func createEtcdClientCert(dir string) {
+	cfg := certConfig(Name + "-apiserver")
+	cfg.Usages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	signEtcdCert(dir, dir, "etcd_client", cfg)
+}
+
+// createEtcdMemberCerts creates the serving and peer certificates of the etcd members in namespace
+// in dir, signed by the etcd CA in caDir
+func createEtcdMemberCerts(dir, caDir, namespace string) {
+	for name, cn := range map[string]string{
+		"etcd_server": "etcd-server",
+		"etcd_peer":   "etcd-peer",
+	} {
+		// the members authenticate to each other with their serving certificates
+		cfg := certConfig(cn)
+		cfg.AltNames.DNSNames = etcdDNSNames(namespace)
+		cfg.AltNames.IPs = []net.IP{net.ParseIP("127.0.0.1")}
+		cfg.Usages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		signEtcdCert(dir, caDir, name, cfg)
+	}
+}
+
+func signEtcdCert(dir, caDir, name string, cfg util.Config) {
+	caCert, caKey, err := util.TryLoadCertAndKeyFromDisk(caDir, "etcd_ca")
+	if err != nil {
+		klog.Fatal(err)
+	}
+	cert, key, err := util.NewCertAndKey(caCert, caKey, cfg)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	os.MkdirAll(dir, 0700)
+	if err := util.WriteCertAndKey(dir, name, cert, key); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+type etcdSecretArgs struct {
+	Name   string
+	CACert string
+	Cert   string
+	Key    string
+}
+
+type etcdYamlArgs struct {
+	Namespace      string
+	StorageClass   string
+	Image          string
+	Replicas       int
+	InitialCluster string
+	// Secrets are the certificates secrets, none if they are generated by kustomize or cert-manager
+	Secrets []etcdSecretArgs
+}
+
+func newEtcdYamlArgs(generatedSecrets bool) etcdYamlArgs {
+	a := etcdYamlArgs{
+		Namespace:      Namespace,
+		StorageClass:   StorageClass,
+		Image:          EtcdImage,
+		Replicas:       EtcdReplicas,
+		InitialCluster: etcdInitialCluster(),
+	}
+	if generatedSecrets {
+		return a
+	}
+	dir := certificatesDir()
+	for _, s := range [][2]string{
+		{etcdServerSecret, "etcd_server"},
+		{etcdPeerSecret, "etcd_peer"},
+		{etcdClientSecret(), "etcd_client"},
+	} {
+		a.Secrets = append(a.Secrets, etcdSecretArgs{
+			Name:   s[0],
+			CACert: getBase64(filepath.Join(dir, "etcd_ca.crt")),
+			Cert:   getBase64(filepath.Join(dir, s[1]+".crt")),
+			Key:    getBase64(filepath.Join(dir, s[1]+".key")),
+		})
+	}
+	return a
+}
+
+// etcdYaml deploys the etcd members as a StatefulSet.  The headless etcd Service gives each member
+// the stable name etcd-<i>.etcd.<namespace>.svc it bootstraps the cluster with, the apiserver
+// connects through the etcd-svc Service.  The secrets hold the certificates of the separate etcd
+// CA, which certs rotate keeps as it only replaces the certificates of the apiserver CA.
+var etcdYaml = `{{ $config := . -}}
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: etcd
+  namespace: {{ .Namespace }}
+  labels:
+    app: etcd
+spec:
+  selector:
+    matchLabels:
+      app: etcd
+  serviceName: "etcd"
+  replicas: {{ .Replicas }}
+  podManagementPolicy: Parallel
+  template:
+    metadata:
+      labels:
+        app: etcd
+    spec:
+      terminationGracePeriodSeconds: 30
+      affinity:
+        podAntiAffinity:
+          preferredDuringSchedulingIgnoredDuringExecution:
+          - weight: 100
+            podAffinityTerm:
+              topologyKey: kubernetes.io/hostname
+              labelSelector:
+                matchLabels:
+                  app: etcd
+      containers:
+      - name: etcd
+        image: {{ .Image }}
+        imagePullPolicy: IfNotPresent
+        resources:
+          requests:
+            cpu: 100m
+            memory: 256Mi
+          limits:
+            cpu: "1"
+            memory: 1Gi
+        env:
+        - name: ETCD_DATA_DIR
+          value: /etcd-data-dir
+        - name: POD_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.name
+        - name: POD_NAMESPACE
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.namespace
+        command:
+        - /usr/local/bin/etcd
+        - --name=$(POD_NAME)
+        - --listen-client-urls=https://0.0.0.0:2379
+        - --advertise-client-urls=https://$(POD_NAME).etcd.$(POD_NAMESPACE).svc:2379
+        - --listen-peer-urls=https://0.0.0.0:2380
+        - --initial-advertise-peer-urls=https://$(POD_NAME).etcd.$(POD_NAMESPACE).svc:2380
+        - --listen-metrics-urls=http://0.0.0.0:2381
+        - --initial-cluster={{ .InitialCluster }}
+        - --initial-cluster-state=new
+        - --initial-cluster-token=etcd-$(POD_NAMESPACE)
+        - --client-cert-auth
+        - --trusted-ca-file=/etcd-certs/server/ca.crt
+        - --cert-file=/etcd-certs/server/tls.crt
+        - --key-file=/etcd-certs/server/tls.key
+        - --peer-client-cert-auth
+        - --peer-trusted-ca-file=/etcd-certs/peer/ca.crt
+        - --peer-cert-file=/etcd-certs/peer/tls.crt
+        - --peer-key-file=/etcd-certs/peer/tls.key
+        ports:
+        - name: client
+          containerPort: 2379
+        - name: peer
+          containerPort: 2380
+        - name: metrics
+          containerPort: 2381
+        volumeMounts:
+        - name: etcd-data-dir
+          mountPath: /etcd-data-dir
+        - name: etcd-server-certs
+          mountPath: /etcd-certs/server
+          readOnly: true
+        - name: etcd-peer-certs
+          mountPath: /etcd-certs/peer
+          readOnly: true
+        readinessProbe:
+          httpGet:
+            port: 2381
+            path: /health
+          failureThreshold: 1
+          initialDelaySeconds: 10
+          periodSeconds: 10
+          successThreshold: 1
+          timeoutSeconds: 2
+        livenessProbe:
+          httpGet:
+            port: 2381
+            path: /health
+          failureThreshold: 3
+          initialDelaySeconds: 10
+          periodSeconds: 10
+          successThreshold: 1
+          timeoutSeconds: 2
+      volumes:
+      - name: etcd-server-certs
+        secret:
+          secretName: ` + etcdServerSecret + `
+      - name: etcd-peer-certs
+        secret:
+          secretName: ` + etcdPeerSecret + `
+  volumeClaimTemplates:
+  - metadata:
+     name: etcd-data-dir
+     annotations:
+        volume.beta.kubernetes.io/storage-class: {{.StorageClass}}
+    spec:
+      accessModes: [ "ReadWriteOnce" ]
+      resources:
+        requests:
+         storage: 10Gi
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: etcd
+  namespace: {{ .Namespace }}
+  labels:
+    app: etcd
+spec:
+  clusterIP: None
+  publishNotReadyAddresses: true
+  ports:
+  - port: 2379
+    name: client
+  - port: 2380
+    name: peer
+  selector:
+    app: etcd
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: etcd-svc
+  namespace: {{ .Namespace }}
+  labels:
+    app: etcd
+spec:
+  ports:
+  - port: 2379
+    name: etcd
+    targetPort: 2379
+  selector:
+    app: etcd
+{{- range .Secrets }}
+---
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: {{ .Name }}
+  namespace: {{ $config.Namespace }}
+  labels:
+    app: etcd
+data:
+  ca.crt: {{ .CACert }}
+  tls.crt: {{ .Cert }}
+  tls.key: {{ .Key }}
+{{- end }}
+`
@@ -0,0 +1,1218 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+const (
+	// chartCertsStatic embeds the certificates generated by build config in values.yaml
+	chartCertsStatic = "static"
+	// chartCertsHelm generates the certificates at install time with the helm template functions
+	chartCertsHelm = "helm"
+	// chartCertsCertManager has cert-manager issue the certificates and inject the CA bundle
+	chartCertsCertManager = "cert-manager"
+)
+
+var supportedChartCerts = []string{chartCertsStatic, chartCertsHelm, chartCertsCertManager}
+
+var ChartDir string
+var ChartCerts = chartCertsStatic
+
+func validateChartFlags() {
+	if !sets.NewString(supportedChartCerts...).Has(ChartCerts) {
+		klog.Fatalf("--chart-certs must be one of %v was (%s)", supportedChartCerts, ChartCerts)
+	}
+	if len(ChartDir) == 0 {
+		ChartDir = filepath.Join("charts", Name)
+	}
+}
+
+// buildHelmChart writes the resource config as a helm chart.  Everything which varies between
+// installs is read from values.yaml, the chart templates themselves are static.
+func buildHelmChart() {
+	a := chartValuesTemplateArgs{
+		Name:             Name,
+		Namespace:        Namespace,
+		Image:            Image,
+		Versions:         Versions,
+		ApiserverArgs:    ApiserverArgs,
+		ControllerArgs:   ControllerArgs,
+		PullSecrets:      ImagePullSecrets,
+		ServiceAccount:   ServiceAccount,
+		StorageClass:     StorageClass,
+		Certs:            ChartCerts,
+		Etcd:             deployEtcd(),
+		EtcdReplicas:     EtcdReplicas,
+		EtcdImage:        EtcdImage,
+		EtcdServers:      strings.Join(EtcdServers, ","),
+		EtcdClient:       EtcdClientSecret,
+		MySQL:            usesStorage(storageMySQL),
+		MySQLSecret:      mysqlSecret(),
+		MySQLCreate:      len(MySQLSecret) == 0,
+		MySQLHost:        MySQLHost,
+		MySQLPort:        MySQLPort,
+		MySQLUsername:    MySQLUsername,
+		MySQLPassword:    MySQLPassword,
+		MySQLDatabase:    MySQLDatabase,
+		Filepath:         usesStorage(storageFilepath),
+		ApiserverInject:  apiserverFlagInjection().yaml(2, 2),
+		ApiserverSched:   apiserverScheduling(),
+		ControllerSched:  controllerScheduling(),
+		LeaderElection:   LeaderElection,
+		ControllerInject: controllerInjection().yaml(2, 2),
+		FilepathMount:    filepathMountPath(),
+		FilepathSize:     FilepathSize,
+
+		ApiserverServiceAccount:  apiserverServiceAccount(),
+		ControllerServiceAccount: controllerServiceAccount(),
+	}
+	c := getControllerRBAC()
+	a.ControllerRules = rulesYaml(c.ClusterRules, 2)
+	a.LeaderElectionRules = rulesYaml(leaderElectionRules, 2)
+	for _, ns := range c.Namespaces() {
+		a.ControllerRoles = append(a.ControllerRoles, controllerRoleArgs{
+			Namespace: ns,
+			Rules:     rulesYaml(c.NamespacedRules[ns], 4),
+		})
+	}
+	if ChartCerts == chartCertsStatic {
+		dir := certificatesDir()
+		a.CACert = getBase64(caBundleFiles(dir)...)
+		a.TLSCert = getBase64(filepath.Join(dir, "apiserver.crt"))
+		a.TLSKey = getBase64(filepath.Join(dir, "apiserver.key"))
+		if a.Etcd {
+			a.EtcdCerts = map[string]string{}
+			for _, c := range []string{"etcd_ca", "etcd_server", "etcd_peer", "etcd_client"} {
+				a.EtcdCerts[c+"_crt"] = getBase64(filepath.Join(dir, c+".crt"))
+				a.EtcdCerts[c+"_key"] = getBase64(filepath.Join(dir, c+".key"))
+			}
+		}
+	}
+
+	exists := false
+	write := func(name, templateValue string, data interface{}) {
+		if !writeConfig(filepath.Join(ChartDir, name), name, templateValue, data) {
+			exists = true
+		}
+	}
+	write("Chart.yaml", chartYamlTemplate, a)
+	write("values.yaml", chartValuesTemplate, a)
+	for _, t := range chartTemplates {
+		// the chart templates are written verbatim, helm renders them at install time
+		write(filepath.Join("templates", t.name), "{{ . }}", t.content)
+	}
+	if exists {
+		klog.Warningf("Helm chart already exists, existing files were kept.")
+	}
+	klog.Infof("Wrote helm chart to %s", ChartDir)
+}
+
+type chartValuesTemplateArgs struct {
+	Name           string
+	Namespace      string
+	Image          string
+	Versions       []APIVersion
+	ApiserverArgs  []string
+	ControllerArgs []string
+	PullSecrets    []string
+	ServiceAccount string
+	StorageClass   string
+	Certs          string
+
+	ApiserverServiceAccount  string
+	ControllerServiceAccount string
+	ControllerRules          string
+	ControllerRoles          []controllerRoleArgs
+	LeaderElectionRules      string
+
+	Etcd         bool
+	EtcdReplicas int
+	EtcdImage    string
+	EtcdServers  string
+	EtcdClient   string
+
+	MySQL         bool
+	MySQLSecret   string
+	MySQLCreate   bool
+	MySQLHost     string
+	MySQLPort     int
+	MySQLUsername string
+	MySQLPassword string
+	MySQLDatabase string
+	Filepath      bool
+
+	// ApiserverInject and ControllerInject hold the environment and volumes from the flags
+	ApiserverInject  injectionYaml
+	ControllerInject injectionYaml
+	ApiserverSched   schedulingArgs
+	ControllerSched  schedulingArgs
+	LeaderElection   bool
+	FilepathMount    string
+	FilepathSize     string
+
+	CACert  string
+	TLSCert string
+	TLSKey  string
+	// EtcdCerts holds the etcd certificates and keys by file name, e.g. etcd_ca_crt
+	EtcdCerts map[string]string
+}
+
+var chartYamlTemplate = `apiVersion: v2
+name: {{ .Name }}
+description: Aggregated apiserver and controller-manager for {{ .Name }}
+type: application
+version: 0.1.0
+appVersion: "{{ .Image }}"
+`
+
+var chartValuesTemplate = `# Name of the apiserver service, the apiserver certificates are issued for
+# <name>.<namespace>.svc
+name: "{{ .Name }}"
+# Namespace to install to, defaults to the namespace of the release
+namespace: "{{ .Namespace }}"
+
+image: "{{ .Image }}"
+imagePullPolicy: IfNotPresent
+imagePullSecrets:{{ if not .PullSecrets }} []{{ end }}
+{{- range .PullSecrets }}
+- "{{ . }}"
+{{- end }}
+
+serviceAccount:
+  # Set to false to run the pods as existing service accounts
+  create: {{ not .ServiceAccount }}
+  apiserver: "{{ .ApiserverServiceAccount }}"
+  controller: "{{ .ControllerServiceAccount }}"
+
+rbac:
+  # Rules of the controller ClusterRole, generated from the +kubebuilder:rbac markers
+  controllerRules:{{ .ControllerRules }}
+  # Rules of the controller Roles by namespace, from the markers with a namespace
+  controllerRoles:{{ if not .ControllerRoles }} {}{{ end }}
+{{- range .ControllerRoles }}
+    {{ .Namespace }}:{{ .Rules }}
+{{- end }}
+  # Rules of the controller Role for the leader election in its namespace
+  leaderElectionRules:{{ .LeaderElectionRules }}
+
+# Placement of the apiserver and controller pods
+scheduling:
+  # Allow one pod of each Deployment to be evicted at a time
+  podDisruptionBudget: {{ .ApiserverSched.DisruptionBudget }}
+  # Node labels the pods are spread across
+  topologySpreadKeys:{{ if not .ApiserverSched.SpreadKeys }} []{{ end }}
+{{- range .ApiserverSched.SpreadKeys }}
+  - "{{ . }}"
+{{- end }}
+  priorityClassName: "{{ .ApiserverSched.PriorityClass }}"
+
+apiserver:
+  replicas: {{ .ApiserverSched.Replicas }}
+  # Probe the apiserver for liveness at /livez and readiness at /readyz
+  probes: {{ .ApiserverSched.Probes }}
+  args:{{ if not .ApiserverArgs }} []{{ end }}
+{{- range .ApiserverArgs }}
+  - "{{ . }}"
+{{- end }}
+  # Additional environment, envFrom sources, volumes and volume mounts of the apiserver container
+  env:{{ if not .ApiserverInject.Env }} []{{ end }}{{ .ApiserverInject.Env }}
+  envFrom:{{ if not .ApiserverInject.EnvFrom }} []{{ end }}{{ .ApiserverInject.EnvFrom }}
+  extraVolumes:{{ if not .ApiserverInject.Volumes }} []{{ end }}{{ .ApiserverInject.Volumes }}
+  extraVolumeMounts:{{ if not .ApiserverInject.VolumeMounts }} []{{ end }}{{ .ApiserverInject.VolumeMounts }}
+  resources:
+    requests:{{ if not .ApiserverSched.Requests }} {}{{ end }}
+{{- range $resource, $quantity := .ApiserverSched.Requests }}
+      {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+    limits:{{ if not .ApiserverSched.Limits }} {}{{ end }}
+{{- range $resource, $quantity := .ApiserverSched.Limits }}
+      {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+
+controller:
+  enabled: true
+  replicas: {{ .ControllerSched.Replicas }}
+  # Run the controller with --enable-leader-election, required for more than one replica
+  leaderElection: {{ .LeaderElection }}
+  args:{{ if not .ControllerArgs }} []{{ end }}
+{{- range .ControllerArgs }}
+  - "{{ . }}"
+{{- end }}
+  # Additional environment, envFrom sources, volumes and volume mounts of the controller container
+  env:{{ if not .ControllerInject.Env }} []{{ end }}{{ .ControllerInject.Env }}
+  envFrom:{{ if not .ControllerInject.EnvFrom }} []{{ end }}{{ .ControllerInject.EnvFrom }}
+  extraVolumes:{{ if not .ControllerInject.Volumes }} []{{ end }}{{ .ControllerInject.Volumes }}
+  extraVolumeMounts:{{ if not .ControllerInject.VolumeMounts }} []{{ end }}{{ .ControllerInject.VolumeMounts }}
+  resources:
+    requests:{{ if not .ControllerSched.Requests }} {}{{ end }}
+{{- range $resource, $quantity := .ControllerSched.Requests }}
+      {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+    limits:{{ if not .ControllerSched.Limits }} {}{{ end }}
+{{- range $resource, $quantity := .ControllerSched.Limits }}
+      {{ $resource }}: "{{ $quantity }}"
+{{- end }}
+
+etcd:
+  # Set to false to use the etcd cluster at servers instead
+  enabled: {{ .Etcd }}
+  # URLs of the existing etcd cluster, comma separated
+  servers: "{{ .EtcdServers }}"
+  # Secret with the ca.crt, tls.crt and tls.key the apiserver connects to the existing etcd
+  # cluster with, empty to connect without TLS
+  clientSecret: "{{ .EtcdClient }}"
+  # An odd number of members, 3 tolerates the loss of one member
+  replicas: {{ .EtcdReplicas }}
+  image: "{{ .EtcdImage }}"
+  storageClass: "{{ .StorageClass }}"
+  storage: 10Gi
+  resources:
+    requests:
+      cpu: 100m
+      memory: 256Mi
+    limits:
+      cpu: "1"
+      memory: 1Gi
+  # The certificates of the etcd CA for the static certificates source, generated by
+  # apiserver-boot build config
+  certificates:
+    ca: "{{ index .EtcdCerts "etcd_ca_crt" }}"
+    serverCrt: "{{ index .EtcdCerts "etcd_server_crt" }}"
+    serverKey: "{{ index .EtcdCerts "etcd_server_key" }}"
+    peerCrt: "{{ index .EtcdCerts "etcd_peer_crt" }}"
+    peerKey: "{{ index .EtcdCerts "etcd_peer_key" }}"
+    clientCrt: "{{ index .EtcdCerts "etcd_client_crt" }}"
+    clientKey: "{{ index .EtcdCerts "etcd_client_key" }}"
+
+storage:
+  # Resources stored in MySQL, the apiserver reads the connection from the MYSQL_ environment
+  mysql:
+    enabled: {{ .MySQL }}
+    # Set to false to use an existing secret with the host, port, username, password and
+    # database keys
+    createSecret: {{ .MySQLCreate }}
+    secret: "{{ .MySQLSecret }}"
+    host: "{{ .MySQLHost }}"
+    port: {{ .MySQLPort }}
+    username: "{{ .MySQLUsername }}"
+    password: "{{ .MySQLPassword }}"
+    database: "{{ .MySQLDatabase }}"
+  # Resources stored as files on a volume, which is ReadWriteOnce so keep apiserver.replicas at 1
+  filepath:
+    enabled: {{ .Filepath }}
+    mountPath: "{{ .FilepathMount }}"
+    storageClass: "{{ .StorageClass }}"
+    size: {{ .FilepathSize }}
+
+certificates:
+  # One of static (the certificates below, generated by apiserver-boot build config), helm
+  # (generated at install time) or cert-manager (issued by cert-manager).  The helm source reuses
+  # the certificates of the secrets of the release on upgrades, delete the secrets to have the next
+  # upgrade generate new ones.  helm template can't read the secrets and always generates them
+  source: "{{ .Certs }}"
+  validityDays: 365
+  static:
+    caCrt: "{{ .CACert }}"
+    tlsCrt: "{{ .TLSCert }}"
+    tlsKey: "{{ .TLSKey }}"
+
+apiServices:
+{{- range .Versions }}
+- group: "{{ .Group }}"
+  version: "{{ .Version }}"
+  groupPriorityMinimum: {{ .GroupPriorityMinimum }}
+  versionPriority: {{ .VersionPriority }}
+{{- end }}
+`
+
+type chartTemplate struct {
+	name    string
+	content string
+}
+
+var chartTemplates = []chartTemplate{
+	{"_helpers.tpl", chartHelpersTemplate},
+	{"apiservice.yaml", chartAPIServiceTemplate},
+	{"apiserver.yaml", chartApiserverTemplate},
+	{"controller-manager.yaml", chartControllerTemplate},
+	{"rbac.yaml", chartRBACTemplate},
+	{"etcd.yaml", chartEtcdTemplate},
+	{"storage.yaml", chartStorageTemplate},
+}
+
+var chartHelpersTemplate = `{{- define "apiserver.name" -}}
+{{ .Values.name | default .Release.Name }}
+{{- end }}
+
+{{- define "apiserver.namespace" -}}
+{{ .Values.namespace | default .Release.Namespace }}
+{{- end }}
+
+{{- define "apiserver.imagePullSecrets" -}}
+{{- if .Values.imagePullSecrets }}
+imagePullSecrets:
+{{- range .Values.imagePullSecrets }}
+- name: {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- /* apiserver.placement takes the root context and the component label, apiserver or controller */}}
+{{- define "apiserver.placement" -}}
+{{- $name := include "apiserver.name" .root -}}
+{{- with .root.Values.scheduling.priorityClassName }}
+priorityClassName: {{ . }}
+{{- end }}
+{{- if .root.Values.scheduling.topologySpreadKeys }}
+topologySpreadConstraints:
+{{- range .root.Values.scheduling.topologySpreadKeys }}
+- maxSkew: 1
+  topologyKey: {{ . }}
+  whenUnsatisfiable: ScheduleAnyway
+  labelSelector:
+    matchLabels:
+      api: {{ $name }}
+      {{ $.component }}: "true"
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "apiserver.podDisruptionBudget" -}}
+{{- if .root.Values.scheduling.podDisruptionBudget }}
+{{- $name := include "apiserver.name" .root }}
+---
+{{- if .root.Capabilities.APIVersions.Has "policy/v1/PodDisruptionBudget" }}
+apiVersion: policy/v1
+{{- else }}
+apiVersion: policy/v1beta1
+{{- end }}
+kind: PodDisruptionBudget
+metadata:
+  name: {{ $name }}-{{ .component }}
+  namespace: {{ include "apiserver.namespace" .root }}
+  labels:
+    api: {{ $name }}
+    {{ .component }}: "true"
+spec:
+  maxUnavailable: 1
+  selector:
+    matchLabels:
+      api: {{ $name }}
+      {{ .component }}: "true"
+{{- end }}
+{{- end }}
+`
+
+// chartAPIServiceTemplate holds the certificates and the APIServices, so the CA bundle of
+// certificates generated at install time is in scope of the APIServices.  The generated
+// certificates are looked up from the secret on upgrades, which keeps their CA in the secret.
+var chartAPIServiceTemplate = fmt.Sprintf(`{{- $name := include "apiserver.name" . -}}
+{{- $namespace := include "apiserver.namespace" . -}}
+{{- $source := .Values.certificates.source -}}
+{{- $caBundle := .Values.certificates.static.caCrt -}}
+{{- if eq $source %[1]q }}
+{{- $existing := (lookup "v1" "Secret" $namespace $name).data | default dict }}
+{{- $tlsCrt := get $existing "tls.crt" }}
+{{- $tlsKey := get $existing "tls.key" }}
+{{- $caBundle = get $existing "ca.crt" }}
+{{- if not (and $caBundle $tlsCrt $tlsKey) }}
+{{- $svc := printf "%%s.%%s.svc" $name $namespace }}
+{{- $ca := genCA (printf "%%s-certificate-authority" $name) (int .Values.certificates.validityDays) }}
+{{- $cert := genSignedCert $svc (list "127.0.0.1") (list "localhost" $svc) (int .Values.certificates.validityDays) $ca }}
+{{- $caBundle = $ca.Cert | b64enc }}
+{{- $tlsCrt = $cert.Cert | b64enc }}
+{{- $tlsKey = $cert.Key | b64enc }}
+{{- end }}
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: {{ $name }}
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+data:
+  ca.crt: {{ $caBundle }}
+  tls.crt: {{ $tlsCrt }}
+  tls.key: {{ $tlsKey }}
+---
+{{- else if eq $source %[2]q }}
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: {{ $name }}-selfsigned
+  namespace: {{ $namespace }}
+spec:
+  selfSigned: {}
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ $name }}-ca
+  namespace: {{ $namespace }}
+spec:
+  isCA: true
+  commonName: {{ $name }}-certificate-authority
+  secretName: {{ $name }}-ca
+  duration: {{ mul .Values.certificates.validityDays 24 }}h
+  issuerRef:
+    name: {{ $name }}-selfsigned
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: {{ $name }}-ca
+  namespace: {{ $namespace }}
+spec:
+  ca:
+    secretName: {{ $name }}-ca
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ $name }}
+  namespace: {{ $namespace }}
+spec:
+  commonName: {{ $name }}.{{ $namespace }}.svc
+  dnsNames:
+  - localhost
+  - {{ $name }}.{{ $namespace }}.svc
+  ipAddresses:
+  - 127.0.0.1
+  secretName: {{ $name }}
+  duration: {{ mul .Values.certificates.validityDays 24 }}h
+  usages:
+  - server auth
+  - client auth
+  issuerRef:
+    name: {{ $name }}-ca
+---
+{{- else }}
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: {{ $name }}
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+data:
+  tls.crt: {{ .Values.certificates.static.tlsCrt }}
+  tls.key: {{ .Values.certificates.static.tlsKey }}
+---
+{{- end }}
+{{- range .Values.apiServices }}
+apiVersion: apiregistration.k8s.io/v1
+kind: APIService
+metadata:
+  name: {{ .version }}.{{ .group }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+{{- if eq $source %[2]q }}
+  annotations:
+    cert-manager.io/inject-ca-from: {{ $namespace }}/{{ $name }}
+{{- end }}
+spec:
+  version: {{ .version }}
+  group: {{ .group }}
+  groupPriorityMinimum: {{ .groupPriorityMinimum }}
+  service:
+    name: {{ $name }}
+    namespace: {{ $namespace }}
+  versionPriority: {{ .versionPriority }}
+{{- if ne $source %[2]q }}
+  caBundle: "{{ $caBundle }}"
+{{- end }}
+---
+{{- end }}
+`, chartCertsHelm, chartCertsCertManager)
+
+var chartApiserverTemplate = `{{- $name := include "apiserver.name" . -}}
+{{- $namespace := include "apiserver.namespace" . -}}
+{{- $etcdServers := .Values.etcd.servers -}}
+{{- $etcdClientSecret := .Values.etcd.clientSecret -}}
+{{- if .Values.etcd.enabled }}
+{{- $etcdServers = "https://etcd-svc:2379" }}
+{{- $etcdClientSecret = printf "%s-etcd-client" $name }}
+{{- end }}
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ $name }}-apiserver
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+spec:
+  selector:
+    matchLabels:
+      api: {{ $name }}
+      apiserver: "true"
+  replicas: {{ .Values.apiserver.replicas }}
+  template:
+    metadata:
+      labels:
+        api: {{ $name }}
+        apiserver: "true"
+    spec:
+      {{- include "apiserver.imagePullSecrets" . | nindent 6 }}
+      serviceAccountName: {{ .Values.serviceAccount.apiserver }}
+      {{- with include "apiserver.placement" (dict "root" . "component" "apiserver") | trim }}
+      {{- . | nindent 6 }}
+      {{- end }}
+      {{- if .Values.storage.filepath.enabled }}
+      securityContext:
+        fsGroup: 65532
+      {{- end }}
+      containers:
+      - name: apiserver
+        image: {{ .Values.image }}
+        imagePullPolicy: {{ .Values.imagePullPolicy }}
+        {{- if or .Values.storage.mysql.enabled .Values.apiserver.env }}
+        env:
+        {{- if .Values.storage.mysql.enabled }}
+        {{- range $env, $key := dict "MYSQL_HOST" "host" "MYSQL_PORT" "port" "MYSQL_USERNAME" "username" "MYSQL_PASSWORD" "password" "MYSQL_DATABASE" "database" }}
+        - name: {{ $env }}
+          valueFrom:
+            secretKeyRef:
+              name: {{ $.Values.storage.mysql.secret }}
+              key: {{ $key }}
+        {{- end }}
+        {{- end }}
+        {{- with .Values.apiserver.env }}
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        {{- end }}
+        {{- with .Values.apiserver.envFrom }}
+        envFrom:
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        volumeMounts:
+        - name: apiserver-certs
+          mountPath: /apiserver.local.config/certificates
+          readOnly: true
+        {{- if .Values.storage.filepath.enabled }}
+        - name: data
+          mountPath: {{ .Values.storage.filepath.mountPath }}
+        {{- end }}
+        {{- if $etcdClientSecret }}
+        - name: etcd-client-certs
+          mountPath: ` + etcdCertsDir + `
+          readOnly: true
+        {{- end }}
+        {{- with .Values.apiserver.extraVolumeMounts }}
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        command:
+        - "./apiserver"
+        args:
+        {{- if $etcdServers }}
+        - "--etcd-servers={{ $etcdServers }}"
+        {{- end }}
+        {{- if $etcdClientSecret }}
+        - "--etcd-cafile=` + etcdCertsDir + `/ca.crt"
+        - "--etcd-certfile=` + etcdCertsDir + `/tls.crt"
+        - "--etcd-keyfile=` + etcdCertsDir + `/tls.key"
+        {{- end }}
+        - "--tls-cert-file=/apiserver.local.config/certificates/tls.crt"
+        - "--tls-private-key-file=/apiserver.local.config/certificates/tls.key"
+        - "--audit-log-path=-"
+        - "--feature-gates=APIPriorityAndFairness=false"
+        - "--audit-log-maxage=0"
+        - "--audit-log-maxbackup=0"
+        {{- range .Values.apiserver.args }}
+        - {{ . | quote }}
+        {{- end }}
+        resources:
+          {{- toYaml .Values.apiserver.resources | nindent 10 }}
+        {{- if .Values.apiserver.probes }}
+        readinessProbe:
+          httpGet:
+            path: /readyz
+            port: 443
+            scheme: HTTPS
+          periodSeconds: 10
+        livenessProbe:
+          httpGet:
+            path: /livez
+            port: 443
+            scheme: HTTPS
+          initialDelaySeconds: 15
+          periodSeconds: 10
+          failureThreshold: 6
+        {{- end }}
+      volumes:
+      - name: apiserver-certs
+        secret:
+          secretName: {{ $name }}
+      {{- if $etcdClientSecret }}
+      - name: etcd-client-certs
+        secret:
+          secretName: {{ $etcdClientSecret }}
+      {{- end }}
+      {{- if .Values.storage.filepath.enabled }}
+      - name: data
+        persistentVolumeClaim:
+          claimName: {{ $name }}-apiserver-data
+      {{- end }}
+      {{- with .Values.apiserver.extraVolumes }}
+      {{- toYaml . | nindent 6 }}
+      {{- end }}
+{{- include "apiserver.podDisruptionBudget" (dict "root" . "component" "apiserver") }}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ $name }}
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+spec:
+  ports:
+  - port: 443
+    protocol: TCP
+    targetPort: 443
+  selector:
+    api: {{ $name }}
+    apiserver: "true"
+`
+
+var chartControllerTemplate = `{{- if .Values.controller.enabled }}
+{{- $name := include "apiserver.name" . -}}
+{{- $namespace := include "apiserver.namespace" . -}}
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ $name }}-controller
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    controller: "true"
+spec:
+  selector:
+    matchLabels:
+      api: {{ $name }}
+      controller: "true"
+  replicas: {{ .Values.controller.replicas }}
+  template:
+    metadata:
+      labels:
+        api: {{ $name }}
+        controller: "true"
+    spec:
+      {{- include "apiserver.imagePullSecrets" . | nindent 6 }}
+      serviceAccountName: {{ .Values.serviceAccount.controller }}
+      {{- with include "apiserver.placement" (dict "root" . "component" "controller") | trim }}
+      {{- . | nindent 6 }}
+      {{- end }}
+      containers:
+      - name: controller
+        image: {{ .Values.image }}
+        imagePullPolicy: {{ .Values.imagePullPolicy }}
+        {{- with .Values.controller.env }}
+        env:
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        {{- with .Values.controller.envFrom }}
+        envFrom:
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        {{- with .Values.controller.extraVolumeMounts }}
+        volumeMounts:
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        command:
+        - "./controller-manager"
+        args:
+        {{- if and .Values.controller.leaderElection (not (has "` + leaderElectionArg + `" .Values.controller.args)) }}
+        - "` + leaderElectionArg + `"
+        {{- end }}
+        {{- range .Values.controller.args }}
+        - {{ . | quote }}
+        {{- end }}
+        resources:
+          {{- toYaml .Values.controller.resources | nindent 10 }}
+      {{- with .Values.controller.extraVolumes }}
+      volumes:
+      {{- toYaml . | nindent 6 }}
+      {{- end }}
+{{- include "apiserver.podDisruptionBudget" (dict "root" . "component" "controller") }}
+{{- end }}
+`
+
+var chartRBACTemplate = `{{- $name := include "apiserver.name" . -}}
+{{- $namespace := include "apiserver.namespace" . -}}
+{{- $apiserver := .Values.serviceAccount.apiserver -}}
+{{- $controller := .Values.serviceAccount.controller -}}
+{{- if .Values.serviceAccount.create }}
+{{- range uniq (list $apiserver $controller) }}
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ . }}
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+---
+{{- end }}
+{{- end }}
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: {{ $name }}-apiserver-auth-reader
+  namespace: kube-system
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: extension-apiserver-authentication-reader
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $namespace }}
+    name: {{ $apiserver }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ $name }}-apiserver-auth-delegator
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:auth-delegator
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $namespace }}
+    name: {{ $apiserver }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ $name }}-apiserver
+rules:
+  - apiGroups:
+      - ""
+    resources:
+      - namespaces
+    verbs:
+      - get
+      - list
+      - watch
+  - apiGroups:
+      - admissionregistration.k8s.io
+    resources:
+      - mutatingwebhookconfigurations
+      - validatingwebhookconfigurations
+    verbs:
+      - get
+      - list
+      - watch
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ $name }}-apiserver
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{ $name }}-apiserver
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $namespace }}
+    name: {{ $apiserver }}
+{{- if .Values.controller.enabled }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ $name }}-controller
+rules:
+  {{- toYaml .Values.rbac.controllerRules | nindent 2 }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ $name }}-controller
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{ $name }}-controller
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $namespace }}
+    name: {{ $controller }}
+{{- range $ns, $rules := .Values.rbac.controllerRoles }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: {{ $name }}-controller
+  namespace: {{ $ns }}
+rules:
+  {{- toYaml $rules | nindent 2 }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: {{ $name }}-controller
+  namespace: {{ $ns }}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: {{ $name }}-controller
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $namespace }}
+    name: {{ $controller }}
+{{- end }}
+{{- if .Values.controller.leaderElection }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: {{ $name }}-controller-leader-election
+  namespace: {{ $namespace }}
+rules:
+  {{- toYaml .Values.rbac.leaderElectionRules | nindent 2 }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: {{ $name }}-controller-leader-election
+  namespace: {{ $namespace }}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: {{ $name }}-controller-leader-election
+subjects:
+  - kind: ServiceAccount
+    namespace: {{ $namespace }}
+    name: {{ $controller }}
+{{- end }}
+{{- end }}
+`
+
+// chartEtcdTemplate deploys the etcd members like etcdYaml.  The etcd certificates are provided by
+// the certificates source of the apiserver certificates, from a separate etcd CA.  Generated
+// certificates are reused on upgrades only if all three secrets exist, as they share the CA.
+var chartEtcdTemplate = fmt.Sprintf(`{{- if .Values.etcd.enabled }}
+{{- $name := include "apiserver.name" . -}}
+{{- $namespace := include "apiserver.namespace" . -}}
+{{- $source := .Values.certificates.source -}}
+{{- $certs := .Values.etcd.certificates -}}
+{{- $ca := $certs.ca -}}
+{{- $secrets := list (list "etcd-server" $certs.serverCrt $certs.serverKey) (list "etcd-peer" $certs.peerCrt $certs.peerKey) (list (printf "%%s-etcd-client" $name) $certs.clientCrt $certs.clientKey) -}}
+{{- $dnsNames := list "localhost" "etcd-svc" (printf "etcd-svc.%%s" $namespace) (printf "etcd-svc.%%s.svc" $namespace) "*.etcd" (printf "*.etcd.%%s" $namespace) (printf "*.etcd.%%s.svc" $namespace) -}}
+{{- if eq $source %[1]q }}
+{{- $existing := list }}
+{{- range $secret := list "etcd-server" "etcd-peer" (printf "%%s-etcd-client" $name) }}
+{{- $data := (lookup "v1" "Secret" $namespace $secret).data | default dict }}
+{{- if and (get $data "ca.crt") (get $data "tls.crt") (get $data "tls.key") }}
+{{- $ca = get $data "ca.crt" }}
+{{- $existing = append $existing (list $secret (get $data "tls.crt") (get $data "tls.key")) }}
+{{- end }}
+{{- end }}
+{{- if eq (len $existing) 3 }}
+{{- $secrets = $existing }}
+{{- else }}
+{{- $days := int .Values.certificates.validityDays }}
+{{- $etcdCA := genCA "etcd-certificate-authority" $days }}
+{{- $server := genSignedCert "etcd-server" (list "127.0.0.1") $dnsNames $days $etcdCA }}
+{{- $peer := genSignedCert "etcd-peer" (list "127.0.0.1") $dnsNames $days $etcdCA }}
+{{- $client := genSignedCert (printf "%%s-apiserver" $name) nil nil $days $etcdCA }}
+{{- $ca = $etcdCA.Cert | b64enc }}
+{{- $secrets = list (list "etcd-server" ($server.Cert | b64enc) ($server.Key | b64enc)) (list "etcd-peer" ($peer.Cert | b64enc) ($peer.Key | b64enc)) (list (printf "%%s-etcd-client" $name) ($client.Cert | b64enc) ($client.Key | b64enc)) }}
+{{- end }}
+{{- end }}
+{{- if eq $source %[2]q }}
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: etcd-ca
+  namespace: {{ $namespace }}
+spec:
+  isCA: true
+  commonName: etcd-certificate-authority
+  secretName: etcd-ca
+  duration: {{ mul .Values.certificates.validityDays 24 }}h
+  issuerRef:
+    name: {{ $name }}-selfsigned
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: etcd-ca
+  namespace: {{ $namespace }}
+spec:
+  ca:
+    secretName: etcd-ca
+---
+{{- range $secret := list "etcd-server" "etcd-peer" }}
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ $secret }}
+  namespace: {{ $namespace }}
+spec:
+  commonName: {{ $secret }}
+  dnsNames:
+  {{- range $dnsNames }}
+  - {{ . | quote }}
+  {{- end }}
+  ipAddresses:
+  - 127.0.0.1
+  secretName: {{ $secret }}
+  duration: {{ mul $.Values.certificates.validityDays 24 }}h
+  usages:
+  - server auth
+  - client auth
+  issuerRef:
+    name: etcd-ca
+---
+{{- end }}
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ $name }}-etcd-client
+  namespace: {{ $namespace }}
+spec:
+  commonName: {{ $name }}-apiserver
+  secretName: {{ $name }}-etcd-client
+  duration: {{ mul .Values.certificates.validityDays 24 }}h
+  usages:
+  - client auth
+  issuerRef:
+    name: etcd-ca
+---
+{{- else }}
+{{- range $secrets }}
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: {{ index . 0 }}
+  namespace: {{ $namespace }}
+  labels:
+    app: etcd
+data:
+  ca.crt: {{ $ca }}
+  tls.crt: {{ index . 1 }}
+  tls.key: {{ index . 2 }}
+---
+{{- end }}
+{{- end }}
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: etcd
+  namespace: {{ $namespace }}
+  labels:
+    app: etcd
+spec:
+  selector:
+    matchLabels:
+      app: etcd
+  serviceName: "etcd"
+  replicas: {{ .Values.etcd.replicas }}
+  podManagementPolicy: Parallel
+  template:
+    metadata:
+      labels:
+        app: etcd
+    spec:
+      terminationGracePeriodSeconds: 30
+      affinity:
+        podAntiAffinity:
+          preferredDuringSchedulingIgnoredDuringExecution:
+          - weight: 100
+            podAffinityTerm:
+              topologyKey: kubernetes.io/hostname
+              labelSelector:
+                matchLabels:
+                  app: etcd
+      containers:
+      - name: etcd
+        image: {{ .Values.etcd.image }}
+        imagePullPolicy: {{ .Values.imagePullPolicy }}
+        resources:
+          {{- toYaml .Values.etcd.resources | nindent 10 }}
+        env:
+        - name: ETCD_DATA_DIR
+          value: /etcd-data-dir
+        - name: POD_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.name
+        command:
+        - /usr/local/bin/etcd
+        - --name=$(POD_NAME)
+        - --listen-client-urls=https://0.0.0.0:2379
+        - --advertise-client-urls=https://$(POD_NAME).etcd.{{ $namespace }}.svc:2379
+        - --listen-peer-urls=https://0.0.0.0:2380
+        - --initial-advertise-peer-urls=https://$(POD_NAME).etcd.{{ $namespace }}.svc:2380
+        - --listen-metrics-urls=http://0.0.0.0:2381
+        {{- $members := list }}
+        {{- range $i := until (int .Values.etcd.replicas) }}
+        {{- $members = append $members (printf "etcd-%%d=https://etcd-%%d.etcd.%%s.svc:2380" $i $i $namespace) }}
+        {{- end }}
+        - --initial-cluster={{ join "," $members }}
+        - --initial-cluster-state=new
+        - --initial-cluster-token=etcd-{{ $namespace }}
+        - --client-cert-auth
+        - --trusted-ca-file=/etcd-certs/server/ca.crt
+        - --cert-file=/etcd-certs/server/tls.crt
+        - --key-file=/etcd-certs/server/tls.key
+        - --peer-client-cert-auth
+        - --peer-trusted-ca-file=/etcd-certs/peer/ca.crt
+        - --peer-cert-file=/etcd-certs/peer/tls.crt
+        - --peer-key-file=/etcd-certs/peer/tls.key
+        ports:
+        - name: client
+          containerPort: 2379
+        - name: peer
+          containerPort: 2380
+        - name: metrics
+          containerPort: 2381
+        volumeMounts:
+        - name: etcd-data-dir
+          mountPath: /etcd-data-dir
+        - name: etcd-server-certs
+          mountPath: /etcd-certs/server
+          readOnly: true
+        - name: etcd-peer-certs
+          mountPath: /etcd-certs/peer
+          readOnly: true
+        readinessProbe:
+          httpGet:
+            port: 2381
+            path: /health
+          failureThreshold: 1
+          initialDelaySeconds: 10
+          periodSeconds: 10
+          successThreshold: 1
+          timeoutSeconds: 2
+        livenessProbe:
+          httpGet:
+            port: 2381
+            path: /health
+          failureThreshold: 3
+          initialDelaySeconds: 10
+          periodSeconds: 10
+          successThreshold: 1
+          timeoutSeconds: 2
+      volumes:
+      - name: etcd-server-certs
+        secret:
+          secretName: etcd-server
+      - name: etcd-peer-certs
+        secret:
+          secretName: etcd-peer
+  volumeClaimTemplates:
+  - metadata:
+      name: etcd-data-dir
+    spec:
+      storageClassName: {{ .Values.etcd.storageClass }}
+      accessModes: [ "ReadWriteOnce" ]
+      resources:
+        requests:
+          storage: {{ .Values.etcd.storage }}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: etcd
+  namespace: {{ $namespace }}
+  labels:
+    app: etcd
+spec:
+  clusterIP: None
+  publishNotReadyAddresses: true
+  ports:
+  - port: 2379
+    name: client
+  - port: 2380
+    name: peer
+  selector:
+    app: etcd
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: etcd-svc
+  namespace: {{ $namespace }}
+  labels:
+    app: etcd
+spec:
+  ports:
+  - port: 2379
+    name: etcd
+    targetPort: 2379
+  selector:
+    app: etcd
+{{- end }}
+`, chartCertsHelm, chartCertsCertManager)
+
+var chartStorageTemplate = `{{- $name := include "apiserver.name" . -}}
+{{- $namespace := include "apiserver.namespace" . -}}
+{{- with .Values.storage.mysql }}
+{{- if and .enabled .createSecret }}
+apiVersion: v1
+kind: Secret
+type: Opaque
+metadata:
+  name: {{ .secret }}
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+stringData:
+  host: {{ .host | quote }}
+  port: {{ .port | quote }}
+  username: {{ .username | quote }}
+  password: {{ .password | quote }}
+  database: {{ .database | quote }}
+---
+{{- end }}
+{{- end }}
+{{- with .Values.storage.filepath }}
+{{- if .enabled }}
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: {{ $name }}-apiserver-data
+  namespace: {{ $namespace }}
+  labels:
+    api: {{ $name }}
+    apiserver: "true"
+spec:
+  storageClassName: {{ .storageClass }}
+  accessModes: [ "ReadWriteOnce" ]
+  resources:
+    requests:
+      storage: {{ .size }}
+{{- end }}
+{{- end }}
+`
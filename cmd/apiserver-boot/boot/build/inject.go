@@ -0,0 +1,291 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+var ApiserverSecret string
+var ApiserverSecretMount string
+var ApiserverEnv []string
+var ApiserverEnvFrom []string
+var ApiserverVolumes []string
+var ControllerEnvFrom []string
+var ControllerVolumes []string
+
+const (
+	volumeSecret    = "secret"
+	volumeConfigMap = "configmap"
+	volumePVC       = "pvc"
+	volumeEmptyDir  = "emptydir"
+	volumeHostPath  = "hostpath"
+)
+
+var supportedVolumes = []string{volumeSecret, volumeConfigMap, volumePVC, volumeEmptyDir, volumeHostPath}
+
+func AddInjectionFlags(cmd *cobra.Command) {
+	addInjectionFlags(cmd, "apiserver", &ApiserverSecret, &ApiserverSecretMount,
+		&ApiserverEnv, &ApiserverEnvFrom, &ApiserverVolumes)
+	addInjectionFlags(cmd, "controller", &ControllerSecret, &ControllerSecretMount,
+		&ControllerSecretEnv, &ControllerEnvFrom, &ControllerVolumes)
+}
+
+func addInjectionFlags(cmd *cobra.Command, component string, secret, secretMount *string, env, envFrom, volumes *[]string) {
+	cmd.Flags().StringVar(secret, component+"-secret", "",
+		fmt.Sprintf("name of an existing secret mounted at --%s-secret-mount, and read by the --%s-env variables without a value",
+			component, component))
+	cmd.Flags().StringVar(secretMount, component+"-secret-mount", "",
+		fmt.Sprintf("directory the --%s-secret is mounted at", component))
+	cmd.Flags().StringSliceVar(env, component+"-env", []string{},
+		fmt.Sprintf("environment variables of the %s, NAME=value, or NAME to read the NAME key of --%s-secret",
+			component, component))
+	cmd.Flags().StringSliceVar(envFrom, component+"-env-from", []string{},
+		fmt.Sprintf("secrets and configmaps the %s reads all its environment variables from, secret:<name> or configmap:<name>",
+			component))
+	cmd.Flags().StringSliceVar(volumes, component+"-volume", []string{},
+		fmt.Sprintf("volumes mounted into the %s, <type>:<source>:<mount path> with type one of %v, e.g. configmap:settings:/etc/settings",
+			component, supportedVolumes))
+}
+
+// injection holds the environment and volumes injected into the container of a Deployment, in the
+// shape of the corresponding fields of the pod spec.
+type injection struct {
+	Env          []envVar
+	EnvFrom      []envFromSource
+	Volumes      []volume
+	VolumeMounts []volumeMount
+}
+
+type envVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *envVarSource `json:"valueFrom,omitempty"`
+}
+
+type envVarSource struct {
+	SecretKeyRef *keySelector `json:"secretKeyRef,omitempty"`
+}
+
+type keySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type envFromSource struct {
+	SecretRef    *localObjectReference `json:"secretRef,omitempty"`
+	ConfigMapRef *localObjectReference `json:"configMapRef,omitempty"`
+}
+
+type localObjectReference struct {
+	Name string `json:"name"`
+}
+
+type volume struct {
+	Name                  string                `json:"name"`
+	Secret                *secretVolumeSource   `json:"secret,omitempty"`
+	ConfigMap             *localObjectReference `json:"configMap,omitempty"`
+	PersistentVolumeClaim *claimVolumeSource    `json:"persistentVolumeClaim,omitempty"`
+	EmptyDir              *struct{}             `json:"emptyDir,omitempty"`
+	HostPath              *hostPathVolumeSource `json:"hostPath,omitempty"`
+}
+
+type secretVolumeSource struct {
+	SecretName string `json:"secretName"`
+}
+
+type claimVolumeSource struct {
+	ClaimName string `json:"claimName"`
+}
+
+type hostPathVolumeSource struct {
+	Path string `json:"path"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// apiserverFlagInjection and controllerInjection return the injections of the Deployments from
+// the flags.
+func apiserverFlagInjection() injection {
+	i, err := newInjection("apiserver", ApiserverSecret, ApiserverSecretMount,
+		ApiserverEnv, ApiserverEnvFrom, ApiserverVolumes)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return i
+}
+
+// apiserverInjection returns the injection of the apiserver Deployment manifests, which also read
+// the MySQL connection of the mysql storage from the environment.  The chart reads it from the
+// storage values instead.
+func apiserverInjection() injection {
+	i := apiserverFlagInjection()
+	if secret := mysqlSecret(); len(secret) > 0 {
+		for _, env := range sets.StringKeySet(mysqlEnv).List() {
+			i.Env = append(i.Env, envVar{
+				Name:      env,
+				ValueFrom: &envVarSource{SecretKeyRef: &keySelector{Name: secret, Key: mysqlEnv[env]}},
+			})
+		}
+	}
+	return i
+}
+
+func controllerInjection() injection {
+	i, err := newInjection("controller", ControllerSecret, ControllerSecretMount,
+		ControllerSecretEnv, ControllerEnvFrom, ControllerVolumes)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return i
+}
+
+var volumeNameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// newInjection parses the injection flags of component
+func newInjection(component, secret, secretMount string, env, envFrom, volumes []string) (injection, error) {
+	i := injection{}
+	for _, e := range env {
+		name, value := e, ""
+		hasValue := strings.Contains(e, "=")
+		if hasValue {
+			p := strings.SplitN(e, "=", 2)
+			name, value = p[0], p[1]
+		}
+		if len(name) == 0 {
+			return i, errors.Errorf("--%s-env must be NAME=value or NAME was (%s)", component, e)
+		}
+		if hasValue {
+			i.Env = append(i.Env, envVar{Name: name, Value: value})
+			continue
+		}
+		if len(secret) == 0 {
+			return i, errors.Errorf("--%s-env %s without a value requires --%s-secret", component, name, component)
+		}
+		i.Env = append(i.Env, envVar{
+			Name:      name,
+			ValueFrom: &envVarSource{SecretKeyRef: &keySelector{Name: secret, Key: name}},
+		})
+	}
+
+	for _, e := range envFrom {
+		p := strings.SplitN(e, ":", 2)
+		if len(p) != 2 || len(p[1]) == 0 {
+			return i, errors.Errorf("--%s-env-from must be secret:<name> or configmap:<name> was (%s)", component, e)
+		}
+		switch p[0] {
+		case volumeSecret:
+			i.EnvFrom = append(i.EnvFrom, envFromSource{SecretRef: &localObjectReference{Name: p[1]}})
+		case volumeConfigMap:
+			i.EnvFrom = append(i.EnvFrom, envFromSource{ConfigMapRef: &localObjectReference{Name: p[1]}})
+		default:
+			return i, errors.Errorf("--%s-env-from must be secret:<name> or configmap:<name> was (%s)", component, e)
+		}
+	}
+
+	if len(secretMount) > 0 {
+		if len(secret) == 0 {
+			return i, errors.Errorf("--%s-secret-mount requires --%s-secret", component, component)
+		}
+		volumes = append([]string{volumeSecret + ":" + secret + ":" + secretMount}, volumes...)
+	}
+	names := sets.NewString()
+	for _, v := range volumes {
+		p := strings.SplitN(v, ":", 3)
+		if len(p) != 3 || len(p[1]) == 0 || !path.IsAbs(p[2]) {
+			return i, errors.Errorf("--%s-volume must be <type>:<source>:<absolute mount path> was (%s)", component, v)
+		}
+		kind, source, mountPath := p[0], p[1], p[2]
+		vol := volume{Name: kind + "-" + strings.Trim(volumeNameInvalid.ReplaceAllString(strings.ToLower(source), "-"), "-")}
+		readOnly := false
+		switch kind {
+		case volumeSecret:
+			vol.Secret = &secretVolumeSource{SecretName: source}
+			readOnly = true
+		case volumeConfigMap:
+			vol.ConfigMap = &localObjectReference{Name: source}
+			readOnly = true
+		case volumePVC:
+			vol.PersistentVolumeClaim = &claimVolumeSource{ClaimName: source}
+		case volumeEmptyDir:
+			vol.EmptyDir = &struct{}{}
+		case volumeHostPath:
+			vol.HostPath = &hostPathVolumeSource{Path: source}
+		default:
+			return i, errors.Errorf("--%s-volume type must be one of %v was (%s)", component, supportedVolumes, v)
+		}
+		if len(vol.Name) > 63 {
+			vol.Name = strings.TrimRight(vol.Name[:63], "-")
+		}
+		if names.Has(vol.Name) {
+			return i, errors.Errorf("--%s-volume %s is mounted more than once", component, v)
+		}
+		names.Insert(vol.Name)
+		i.Volumes = append(i.Volumes, vol)
+		i.VolumeMounts = append(i.VolumeMounts, volumeMount{Name: vol.Name, MountPath: mountPath, ReadOnly: readOnly})
+	}
+	return i, nil
+}
+
+// injectionYaml holds the rendered fields of an injection, each empty or starting with a new line
+// so it can follow the key of the field in the templates.
+type injectionYaml struct {
+	Env          string
+	EnvFrom      string
+	Volumes      string
+	VolumeMounts string
+}
+
+// yaml renders the container fields of i at containerIndent and the pod fields at podIndent
+func (i injection) yaml(containerIndent, podIndent int) injectionYaml {
+	y := injectionYaml{}
+	if len(i.Env) > 0 {
+		y.Env = indentedYaml(i.Env, containerIndent)
+	}
+	if len(i.EnvFrom) > 0 {
+		y.EnvFrom = indentedYaml(i.EnvFrom, containerIndent)
+	}
+	if len(i.Volumes) > 0 {
+		y.Volumes = indentedYaml(i.Volumes, podIndent)
+		y.VolumeMounts = indentedYaml(i.VolumeMounts, containerIndent)
+	}
+	return y
+}
+
+// indentedYaml marshals v to yaml, prefixing each line with a new line and indent spaces
+func indentedYaml(v interface{}, indent int) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	prefix := strings.Repeat(" ", indent)
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	return "\n" + prefix + strings.Join(lines, "\n"+prefix)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the rendered config and docs")
+
+func mustInjection(t *testing.T, component, secret, secretMount string, env, envFrom, volumes []string) injection {
+	i, err := newInjection(component, secret, secretMount, env, envFrom, volumes)
+	if err != nil {
+		t.Fatalf("newInjection: %v", err)
+	}
+	return i
+}
+
+// checkGolden renders tmpl with args and compares it to testdata/<name>.golden
+func checkGolden(t *testing.T, name, tmpl string, args interface{}) {
+	var out bytes.Buffer
+	if err := template.Must(template.New(name).Parse(tmpl)).Execute(&out, args); err != nil {
+		t.Fatalf("rendering %s: %v", name, err)
+	}
+	checkGoldenFile(t, filepath.Join("testdata", name+".golden"), out.Bytes())
+}
+
+// checkGoldenFile compares out to the golden file, which -update writes instead
+func checkGoldenFile(t *testing.T, golden string, out []byte) {
+	if *update {
+		os.MkdirAll(filepath.Dir(golden), 0700)
+		if err := ioutil.WriteFile(golden, out, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading %s, run with -update to create it: %v", golden, err)
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("%s differs, run with -update if the change is intended:\n%s", golden, out)
+	}
+}
+
+func TestApiserverInjectionYaml(t *testing.T) {
+	args := resourceConfigApiserverYamlArgs{
+		Name:             "acme",
+		Namespace:        "default",
+		Image:            "acme:latest",
+		ServiceAccount:   "acme-apiserver",
+		ClientCert:       "Y2VydA==",
+		ClientKey:        "a2V5",
+		EtcdArgs:         []string{"--etcd-servers=https://etcd-svc:2379"},
+		EtcdClientSecret: "acme-etcd-client",
+		Scheduling:       apiserverScheduling(),
+	}
+	checkGolden(t, "apiserver", resourceConfigApiserverYaml, args)
+
+	args.Inject = mustInjection(t, "apiserver", "acme-credentials", "/etc/credentials",
+		[]string{"LOG_FORMAT=json", "API_TOKEN"},
+		[]string{"configmap:acme-settings", "secret:acme-env"},
+		[]string{"configmap:acme-settings:/etc/settings", "emptydir:cache:/var/cache/acme", "pvc:acme-data:/data"},
+	).yaml(8, 6)
+	checkGolden(t, "apiserver-injected", resourceConfigApiserverYaml, args)
+}
+
+func TestControllerInjectionYaml(t *testing.T) {
+	args := resourceConfigControllerYamlArgs{
+		Name:           "acme",
+		Namespace:      "default",
+		Image:          "acme:latest",
+		ServiceAccount: "acme-controller",
+		ControllerArgs: controllerArgs(),
+		Scheduling:     controllerScheduling(),
+	}
+	checkGolden(t, "controller", resourceConfigControllerYaml, args)
+
+	args.Inject = mustInjection(t, "controller", "acme-credentials", "/etc/credentials",
+		[]string{"CLOUD_TOKEN", "CLOUD_REGION=eu-west-1"},
+		[]string{"secret:acme-env"},
+		[]string{"hostpath:/var/run/acme:/var/run/acme"},
+	).yaml(8, 6)
+	checkGolden(t, "controller-injected", resourceConfigControllerYaml, args)
+}
+
+func TestNewInjectionErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      string
+		secretMount string
+		env         []string
+		envFrom     []string
+		volumes     []string
+	}{
+		{name: "env without name", env: []string{"=value"}},
+		{name: "env from secret without secret", env: []string{"TOKEN"}},
+		{name: "env-from without name", envFrom: []string{"secret:"}},
+		{name: "env-from of unknown kind", envFrom: []string{"pvc:data"}},
+		{name: "secret mount without secret", secretMount: "/etc/credentials"},
+		{name: "volume without mount path", volumes: []string{"configmap:settings"}},
+		{name: "volume with relative mount path", volumes: []string{"configmap:settings:etc/settings"}},
+		{name: "volume of unknown kind", volumes: []string{"nfs:server:/data"}},
+		{name: "volume mounted twice", volumes: []string{"emptydir:cache:/a", "emptydir:cache:/b"}},
+		{name: "secret mounted twice", secret: "creds", secretMount: "/a", volumes: []string{"secret:creds:/b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newInjection("controller", test.secret, test.secretMount, test.env, test.envFrom, test.volumes)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
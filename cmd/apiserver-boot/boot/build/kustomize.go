@@ -0,0 +1,517 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+	"sigs.k8s.io/yaml"
+)
+
+// kustomizeImage is the image name of the base deployments, replaced with --image by the images
+// transformer of the base kustomization.
+const kustomizeImage = "apiserver-image"
+
+// kustomizeOverlays are the overlays written by build config for --format kustomize
+var kustomizeOverlays = []string{"dev", "prod"}
+
+// certificatesDir is where build config writes the certificates.  kustomize only loads files
+// below the kustomization, so they are part of the base for --format kustomize, along with the
+// certificates of each overlay below it.
+func certificatesDir() string {
+	if ConfigFormat == formatKustomize {
+		return filepath.Join(ResourceConfigDir, "base", "certificates")
+	}
+	return filepath.Join(ResourceConfigDir, "certificates")
+}
+
+// overlayDir is the kustomization of the resources an overlay moves to its namespace
+func overlayDir(overlay string) string {
+	return filepath.Join(ResourceConfigDir, "overlays", overlay, "namespaced")
+}
+
+// overlayNamespace returns the namespace set in the kustomization of an existing overlay, so
+// build config keeps it, or --namespace
+func overlayNamespace(overlay string) string {
+	data, err := ioutil.ReadFile(filepath.Join(overlayDir(overlay), "kustomization.yaml"))
+	if err != nil {
+		return Namespace
+	}
+	k := struct {
+		Namespace string `json:"namespace"`
+	}{}
+	if err := yaml.Unmarshal(data, &k); err != nil || len(k.Namespace) == 0 {
+		return Namespace
+	}
+	return k.Namespace
+}
+
+// createKustomizeCerts creates the CAs and the etcd client certificate of the base, and the
+// serving certificates of each overlay issued for its namespace.  --update keeps the certificates
+// of an overlay until its namespace changes.
+func createKustomizeCerts() {
+	if CheckConfig {
+		return
+	}
+	dir := certificatesDir()
+	createCA(dir)
+	if deployEtcd() {
+		createEtcdCA(dir)
+		if !keepCerts("etcd_client") {
+			createEtcdClientCert(dir)
+		}
+	}
+	for _, overlay := range kustomizeOverlays {
+		namespace := overlayNamespace(overlay)
+		certs := filepath.Join(overlayDir(overlay), "certificates")
+		if keepOverlayCerts(certs, namespace) {
+			continue
+		}
+		createServingCert(certs, dir, namespace)
+		if deployEtcd() {
+			createEtcdMemberCerts(certs, dir, namespace)
+			// the secretGenerator only loads files below the overlay
+			ca, err := ioutil.ReadFile(filepath.Join(dir, "etcd_ca.crt"))
+			if err != nil {
+				klog.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(certs, "etcd_ca.crt"), ca, 0644); err != nil {
+				klog.Fatal(err)
+			}
+		}
+	}
+}
+
+// keepOverlayCerts is true if --update finds the certificates of an overlay issued for namespace
+func keepOverlayCerts(dir, namespace string) bool {
+	if !UpdateConfig {
+		return false
+	}
+	certs, err := util.CertsFromFile(filepath.Join(dir, "apiserver.crt"))
+	if err != nil || certs[0].VerifyHostname(servingCertName(namespace)) != nil {
+		return false
+	}
+	if deployEtcd() {
+		certs, err := util.CertsFromFile(filepath.Join(dir, "etcd_server.crt"))
+		if err != nil || certs[0].VerifyHostname("etcd-svc."+namespace+".svc") != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// buildKustomizeConfig writes the resource config as a kustomize base and dev and prod overlays.
+// Each overlay moves the namespaced resources of the base to its namespace, patches the image,
+// replicas and resources, and generates the serving certificates secrets issued for its namespace
+// unless cert-manager issues them.  The Roles and RoleBindings in kube-system and the namespaces
+// of the rbac markers are kept in other-namespaces, and bind the service accounts of the overlay.
+func buildKustomizeConfig() {
+	base := filepath.Join(ResourceConfigDir, "base")
+
+	var created []bool
+	created = append(created, writeConfig(
+		filepath.Join(base, "apiservice.yaml"),
+		"apiservice-config-template", apiserviceYamlTemplate, newAPIServiceYamlTemplateArgs()))
+	if CertProvider == certProviderCertManager {
+		created = append(created, writeConfig(
+			filepath.Join(base, "certificates.yaml"),
+			"certificates-config-template", certManagerYamlTemplate, newCertManagerYamlTemplateArgs()))
+	}
+	created = append(created, writeConfig(
+		filepath.Join(base, "aggregated-apiserver.yaml"),
+		"apiserver-config-template", resourceConfigApiserverYaml, resourceConfigApiserverYamlArgs{
+			Name:             Name,
+			Namespace:        Namespace,
+			Image:            kustomizeImage,
+			ApiserverArgs:    ApiserverArgs,
+			ImagePullSecrets: ImagePullSecrets,
+			ServiceAccount:   apiserverServiceAccount(),
+			GeneratedSecret:  true,
+			EtcdArgs:         etcdApiserverArgs(),
+			EtcdClientSecret: etcdClientSecret(),
+			FilepathClaim:    filepathClaim(),
+			FilepathMount:    filepathMountPath(),
+			Inject:           apiserverInjection().yaml(8, 6),
+			Scheduling:       apiserverScheduling(),
+		}))
+	created = append(created, writeConfig(
+		filepath.Join(base, "controller-manager.yaml"),
+		"controller-config-template", resourceConfigControllerYaml, resourceConfigControllerYamlArgs{
+			Name:             Name,
+			Namespace:        Namespace,
+			Image:            kustomizeImage,
+			ControllerArgs:   controllerArgs(),
+			ImagePullSecrets: ImagePullSecrets,
+			ServiceAccount:   controllerServiceAccount(),
+			Inject:           controllerInjection().yaml(8, 6),
+			Scheduling:       controllerScheduling(),
+		}))
+	rbac := newResourceConfigRBACYamlArgs()
+	rbac.OtherNamespaces = false
+	created = append(created, writeConfig(
+		filepath.Join(base, "rbac.yaml"), "rbac-config-template", resourceConfigRBACYaml, rbac))
+	if needsStorageYaml() {
+		created = append(created, writeConfig(
+			filepath.Join(base, "storage.yaml"),
+			"storage-config-template", storageYaml, newStorageYamlArgs()))
+	}
+	if deployEtcd() {
+		created = append(created, writeConfig(
+			filepath.Join(base, "etcd.yaml"),
+			"etcd-config-template", etcdYaml, newEtcdYamlArgs(true)))
+	}
+
+	a := kustomizeTemplateArgs{
+		Name:        Name,
+		Namespace:   Namespace,
+		Image:       kustomizeImage,
+		CertManager: CertProvider == certProviderCertManager,
+		Etcd:        deployEtcd(),
+		Storage:     needsStorageYaml(),
+	}
+	if a.Etcd {
+		a.EtcdClientSecret = etcdClientSecret()
+		a.EtcdSecrets = []etcdSecretArgs{
+			{Name: etcdServerSecret, Cert: "etcd_server"},
+			{Name: etcdPeerSecret, Cert: "etcd_peer"},
+		}
+	}
+	for _, ns := range getControllerRBAC().Namespaces() {
+		if ns != Namespace {
+			a.OtherNamespaces = append(a.OtherNamespaces, ns)
+		}
+	}
+	a.NewName, a.NewTag, a.Digest = splitImage(Image)
+	created = append(created, writeConfig(
+		filepath.Join(base, "kustomization.yaml"),
+		"kustomization-base-template", kustomizationBaseTemplate, a))
+
+	other := filepath.Join(ResourceConfigDir, "other-namespaces")
+	rbac = newResourceConfigRBACYamlArgs()
+	rbac.Namespaced = false
+	created = append(created, writeConfig(
+		filepath.Join(other, "rbac.yaml"), "rbac-config-template", resourceConfigRBACYaml, rbac))
+	created = append(created, writeConfig(
+		filepath.Join(other, "kustomization.yaml"),
+		"kustomization-other-namespaces-template", kustomizationOtherNamespacesTemplate, a))
+
+	for _, overlay := range kustomizeOverlays {
+		o := a
+		o.Namespace = overlayNamespace(overlay)
+		created = append(created, writeConfig(
+			filepath.Join(ResourceConfigDir, "overlays", overlay, "kustomization.yaml"),
+			"kustomization-overlay-template", kustomizationOverlayTemplate, o))
+		created = append(created, writeConfig(
+			filepath.Join(overlayDir(overlay), "kustomization.yaml"),
+			"kustomization-namespaced-template", kustomizationNamespacedTemplate, o))
+		for _, p := range overlayPatches(overlay) {
+			created = append(created, writeConfig(
+				filepath.Join(overlayDir(overlay), p.Container+"-patch.yaml"),
+				"kustomization-patch-template", deploymentPatchTemplate, p))
+		}
+	}
+
+	for _, c := range created {
+		if !c {
+			klog.Warningf("Resource config already exists, existing files were kept.")
+			break
+		}
+	}
+}
+
+// deploymentPatchArgs are the replicas and resources an overlay sets for a deployment
+type deploymentPatchArgs struct {
+	Deployment string
+	Container  string
+	Replicas   int
+
+	CPURequest    string
+	MemoryRequest string
+	CPULimit      string
+	MemoryLimit   string
+}
+
+// overlayPatches returns the patches of the apiserver and controller deployments of an overlay.
+// The prod apiserver scales out unless it stores resources on a ReadWriteOnce volume, and the
+// controller replicas wait for the leader election unless it is disabled.
+func overlayPatches(overlay string) []deploymentPatchArgs {
+	if overlay == "dev" {
+		return []deploymentPatchArgs{
+			{Name + "-apiserver", "apiserver", 1, "100m", "64Mi", "500m", "256Mi"},
+			{Name + "-controller", "controller", 1, "100m", "64Mi", "500m", "256Mi"},
+		}
+	}
+	apiserverReplicas := 3
+	if usesStorage(storageFilepath) {
+		apiserverReplicas = 1
+	}
+	controllerReplicas := 1
+	if LeaderElection {
+		controllerReplicas = 2
+	}
+	return []deploymentPatchArgs{
+		{Name + "-apiserver", "apiserver", apiserverReplicas, "500m", "256Mi", "1", "512Mi"},
+		{Name + "-controller", "controller", controllerReplicas, "200m", "256Mi", "500m", "512Mi"},
+	}
+}
+
+// splitImage splits an image reference into the name and the tag or digest, as used by the
+// kustomize images transformer.
+func splitImage(image string) (name, tag, digest string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], "", image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:], ""
+	}
+	return image, "", ""
+}
+
+type kustomizeTemplateArgs struct {
+	Name string
+	// Namespace of the base, or of an overlay
+	Namespace string
+	// Image is the placeholder image of the base deployments
+	Image   string
+	NewName string
+	NewTag  string
+	Digest  string
+	// CertManager is true if cert-manager issues the certificates secrets
+	CertManager bool
+	// Etcd is true if the base deploys etcd
+	Etcd bool
+	// Storage is true if the base has the storage.yaml of the storage backends
+	Storage bool
+	// EtcdClientSecret is generated by the base, the EtcdSecrets of the etcd members by each
+	// overlay.  The Cert is the file name below certificates/.
+	EtcdClientSecret string
+	EtcdSecrets      []etcdSecretArgs
+	// OtherNamespaces are the namespaces of the controller Roles besides the base namespace
+	OtherNamespaces []string
+}
+
+var kustomizationBaseTemplate = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+# The namespaced resources of the apiserver and the cluster scoped ones.  The overlays move the
+# namespaced resources to their namespace and generate the serving certificates secrets, apply an
+# overlay instead of the base.
+resources:
+- apiservice.yaml
+- aggregated-apiserver.yaml
+- controller-manager.yaml
+- rbac.yaml
+{{- if .Storage }}
+- storage.yaml
+{{- end }}
+{{- if .Etcd }}
+- etcd.yaml
+{{- end }}
+{{- if .CertManager }}
+- certificates.yaml
+{{- end }}
+
+images:
+- name: {{ .Image }}
+  newName: {{ .NewName }}
+{{- if .NewTag }}
+  newTag: "{{ .NewTag }}"
+{{- end }}
+{{- if .Digest }}
+  digest: {{ .Digest }}
+{{- end }}
+{{- if and .Etcd (not .CertManager) }}
+
+secretGenerator:
+- name: {{ .EtcdClientSecret }}
+  namespace: {{ .Namespace }}
+  type: kubernetes.io/tls
+  files:
+  - ca.crt=certificates/etcd_ca.crt
+  - tls.crt=certificates/etcd_client.crt
+  - tls.key=certificates/etcd_client.key
+  options:
+    labels:
+      app: etcd
+{{- end }}
+`
+
+var kustomizationOtherNamespacesTemplate = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+# The Roles and RoleBindings of the apiserver in kube-system and in the namespaces of the rbac
+# markers other than {{ .Namespace }}.  They keep their namespace, the overlays bind the service
+# accounts in their namespace.
+resources:
+- rbac.yaml
+`
+
+var kustomizationOverlayTemplate = `{{ $config := . -}}
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+# The namespace of the overlay is set in namespaced/kustomization.yaml.  The resources in other
+# namespaces are not moved.
+resources:
+- namespaced
+- ../../other-namespaces
+
+# The RoleBindings in the other namespaces bind the service accounts in the namespace of the overlay
+replacements:
+- source:
+    kind: Service
+    name: {{ .Name }}
+    fieldPath: metadata.namespace
+  targets:
+  - select:
+      kind: RoleBinding
+      name: {{ .Name }}-apiserver-auth-reader
+      namespace: kube-system
+    fieldPaths:
+    - subjects.0.namespace
+{{- range .OtherNamespaces }}
+  - select:
+      kind: RoleBinding
+      name: {{ $config.Name }}-controller
+      namespace: {{ . }}
+    fieldPaths:
+    - subjects.0.namespace
+{{- end }}
+`
+
+var kustomizationNamespacedTemplate = `{{ $config := . -}}
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+# The namespace of the overlay.
+{{- if .CertManager }}  cert-manager issues the certificates for the services in it.
+{{- else }}  The certificates below certificates/ are issued for the services in
+# it, run apiserver-boot build config --update after changing it to issue them again.
+{{- end }}
+namespace: {{ .Namespace }}
+
+resources:
+- ../../../base
+
+# Override the image of the base, e.g.
+# images:
+# - name: {{ .Image }}
+#   newName: {{ .NewName }}
+#   newTag: latest
+
+patches:
+- path: apiserver-patch.yaml
+  target:
+    kind: Deployment
+    name: {{ .Name }}-apiserver
+- path: controller-patch.yaml
+  target:
+    kind: Deployment
+    name: {{ .Name }}-controller
+{{- if not .CertManager }}
+
+secretGenerator:
+- name: {{ .Name }}
+  type: kubernetes.io/tls
+  files:
+  - tls.crt=certificates/apiserver.crt
+  - tls.key=certificates/apiserver.key
+  options:
+    labels:
+      api: {{ .Name }}
+      apiserver: "true"
+{{- range .EtcdSecrets }}
+- name: {{ .Name }}
+  type: kubernetes.io/tls
+  files:
+  - ca.crt=certificates/etcd_ca.crt
+  - tls.crt=certificates/{{ .Cert }}.crt
+  - tls.key=certificates/{{ .Cert }}.key
+  options:
+    labels:
+      app: etcd
+{{- end }}
+{{- else }}
+
+# The certificates are issued for the services in the namespace of the overlay
+replacements:
+- source:
+    kind: Service
+    name: {{ .Name }}
+    fieldPath: metadata.namespace
+  targets:
+  - select:
+      kind: Certificate
+      name: {{ .Name }}
+    fieldPaths:
+    - spec.commonName
+    - spec.dnsNames.1
+    options:
+      delimiter: "."
+      index: 1
+  - select:
+      kind: APIService
+    fieldPaths:
+    - metadata.annotations.[cert-manager.io/inject-ca-from]
+    options:
+      delimiter: "/"
+      index: 0
+{{- range .EtcdSecrets }}
+  - select:
+      kind: Certificate
+      name: {{ .Name }}
+    fieldPaths:
+    - spec.dnsNames.2
+    - spec.dnsNames.3
+    options:
+      delimiter: "."
+      index: 1
+  - select:
+      kind: Certificate
+      name: {{ .Name }}
+    fieldPaths:
+    - spec.dnsNames.5
+    - spec.dnsNames.6
+    options:
+      delimiter: "."
+      index: 2
+{{- end }}
+{{- end }}
+`
+
+var deploymentPatchTemplate = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Deployment }}
+spec:
+  replicas: {{ .Replicas }}
+  template:
+    spec:
+      containers:
+      - name: {{ .Container }}
+        resources:
+          requests:
+            cpu: "{{ .CPURequest }}"
+            memory: {{ .MemoryRequest }}
+          limits:
+            cpu: "{{ .CPULimit }}"
+            memory: {{ .MemoryLimit }}
+`
@@ -0,0 +1,58 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverlayNamespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kustomize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(r, n string) { ResourceConfigDir, Namespace = r, n }(ResourceConfigDir, Namespace)
+	ResourceConfigDir = dir
+	Namespace = "acme-system"
+
+	tests := []struct {
+		name          string
+		kustomization string
+		want          string
+	}{
+		{name: "missing", want: "acme-system"},
+		{name: "set", kustomization: "namespace: acme-dev\nresources:\n- ../../../base\n", want: "acme-dev"},
+		{name: "unset", kustomization: "resources:\n- ../../../base\n", want: "acme-system"},
+		{name: "invalid", kustomization: "<<<<<<< current\nnamespace: acme-dev\n", want: "acme-system"},
+	}
+	for _, test := range tests {
+		if len(test.kustomization) > 0 {
+			path := filepath.Join(overlayDir(test.name), "kustomization.yaml")
+			os.MkdirAll(filepath.Dir(path), 0700)
+			if err := ioutil.WriteFile(path, []byte(test.kustomization), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := overlayNamespace(test.name); got != test.want {
+			t.Errorf("%s: overlayNamespace() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
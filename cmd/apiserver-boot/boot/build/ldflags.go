@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionPackage holds the version variables reported by the /version endpoint of the apiserver
+const versionPackage = "k8s.io/component-base/version"
+
+var buildVersion string
+
+// versionLDFlags returns the -ldflags setting the version of the binaries from the git checkout.
+// The build date is the buildTime rather than the clock so builds of the same commit are
+// reproducible.
+func versionLDFlags() string {
+	commit := gitOutput("rev-parse", "HEAD")
+	treeState := ""
+	if len(commit) > 0 {
+		treeState = "clean"
+		if len(gitOutput("status", "--porcelain")) > 0 {
+			treeState = "dirty"
+		}
+	}
+
+	v := buildVersion
+	if len(v) == 0 {
+		v = gitOutput("describe", "--tags", "--match", "v*", "--dirty")
+	}
+	if len(v) == 0 && len(commit) > 0 {
+		v = "v0.0.0-master+" + commit[:7]
+	}
+
+	vars := []string{
+		"gitCommit=" + commit,
+		"gitTreeState=" + treeState,
+		"buildDate=" + buildTime().Format("2006-01-02T15:04:05Z"),
+	}
+	// gitMajor and gitMinor are left unset: the apiserver compares them with the Kubernetes
+	// releases of the APILifecycle methods, so the version of the project would hide or raise the
+	// deprecation warnings of its versions.
+	if len(v) > 0 {
+		vars = append(vars, "gitVersion="+v)
+	}
+
+	var flags []string
+	for _, v := range vars {
+		flags = append(flags, fmt.Sprintf("-X '%s.%s'", versionPackage, v))
+	}
+	return strings.Join(flags, " ")
+}
+
+// buildTime is the time the build is attributed to, $SOURCE_DATE_EPOCH or the time of the
+// commit being built.
+func buildTime() time.Time {
+	t := time.Unix(0, 0)
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); len(epoch) > 0 {
+		if s, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			t = time.Unix(s, 0)
+		}
+	} else if ct := gitOutput("show", "-s", "--format=%ct", "HEAD"); len(ct) > 0 {
+		if s, err := strconv.ParseInt(ct, 10, 64); err == nil {
+			t = time.Unix(s, 0)
+		}
+	}
+	return t.UTC()
+}
+
+// gitOutput runs git and returns its trimmed output, or an empty string if it fails, e.g. when
+// not building from a git checkout.
+func gitOutput(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apiserver/pkg/endpoints/deprecation"
+)
+
+// deprecatedKind is a kind of a version deprecated in 1.29 and removed in 1.31, like the
+// lifecycle methods generated by create version --deprecates
+type deprecatedKind struct{}
+
+func (deprecatedKind) GetObjectKind() schema.ObjectKind   { return schema.EmptyObjectKind }
+func (d deprecatedKind) DeepCopyObject() runtime.Object   { return d }
+func (deprecatedKind) APILifecycleIntroduced() (int, int) { return 1, 27 }
+func (deprecatedKind) APILifecycleDeprecated() (int, int) { return 1, 29 }
+func (deprecatedKind) APILifecycleRemoved() (int, int)    { return 1, 31 }
+
+var ldflagVar = regexp.MustCompile(`-X '` + regexp.QuoteMeta(versionPackage) + `\.(\w+)=([^']*)'`)
+
+// stampedVersion returns the version info the apiserver reports when built with ldflags
+func stampedVersion(ldflags string) version.Info {
+	info := version.Info{}
+	for _, m := range ldflagVar.FindAllStringSubmatch(ldflags, -1) {
+		switch m[1] {
+		case "gitMajor":
+			info.Major = m[2]
+		case "gitMinor":
+			info.Minor = m[2]
+		case "gitVersion":
+			info.GitVersion = m[2]
+		}
+	}
+	return info
+}
+
+func TestVersionLDFlagsKeepDeprecationWarnings(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "ldflags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{"", "v0.3.0", "v1.2.0", "v1.40.0"} {
+		t.Run("tag "+tag, func(t *testing.T) {
+			os.RemoveAll(".git")
+			for _, args := range [][]string{
+				{"init", "-q"},
+				{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+			} {
+				if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+					t.Fatalf("git %v: %v\n%s", args, err, out)
+				}
+			}
+			if len(tag) > 0 {
+				if out, err := exec.Command("git", "tag", tag).CombinedOutput(); err != nil {
+					t.Fatalf("git tag: %v\n%s", err, out)
+				}
+			}
+
+			info := stampedVersion(versionLDFlags())
+			if len(tag) > 0 && info.GitVersion != tag {
+				t.Errorf("gitVersion %q want %q", info.GitVersion, tag)
+			}
+			if len(info.Major) > 0 || len(info.Minor) > 0 {
+				t.Errorf("the version of the project is stamped as the Kubernetes release %s.%s", info.Major, info.Minor)
+			}
+			major, minor, _ := deprecation.MajorMinor(info)
+			if !deprecation.IsDeprecated(deprecatedKind{}, major, minor) {
+				t.Errorf("a version deprecated in 1.29 is not deprecated in an apiserver built at %s", info.GitVersion)
+			}
+		})
+	}
+}
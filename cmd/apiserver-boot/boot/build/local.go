@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"net"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+var Local bool
+var LocalIP string
+var LocalPort = 9443
+
+// kindNetwork is the docker network the nodes of kind clusters are attached to
+const kindNetwork = "kind"
+
+func AddLocalFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&Local, "local", false,
+		"write the config aggregating an apiserver started with run local into the cluster of the current "+
+			"kubeconfig context to <output>/local, instead of the config deploying the apiserver")
+	cmd.Flags().StringVar(&LocalIP, "local-ip", "",
+		"address the cluster reaches this host at for --local, detected from the current kubeconfig context by default")
+	cmd.Flags().IntVar(&LocalPort, "local-port", LocalPort,
+		"secure port of the apiserver started with run local for --local")
+}
+
+// buildLocalConfig writes the APIServices of the project and an ExternalName Service pointing
+// at this host, trusting the local CA the serving certificate of run local is signed by.
+func buildLocalConfig() {
+	initVersionedApis()
+	if len(LocalIP) == 0 {
+		ip, err := detectLocalIP()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		LocalIP = ip
+		klog.Infof("Detected the cluster reaches this host at %s", LocalIP)
+	}
+
+	created := writeConfig(
+		filepath.Join(ResourceConfigDir, "local", "apiservice.yaml"),
+		"local-config-template", localConfigTemplate, localConfigTemplateArgs{
+			Versions:  Versions,
+			Name:      Name,
+			Namespace: Namespace,
+			CACert:    getBase64(caBundleFiles(certificatesDir())...),
+			LocalIp:   LocalIP,
+			Port:      LocalPort,
+		})
+	if !created {
+		klog.Warningf("Local config already exists.")
+	}
+}
+
+// detectLocalIP returns the address the cluster of the current kubeconfig context reaches this
+// host at.  The apiserver of kind clusters is forwarded from localhost, their nodes reach the
+// host at the gateway of the kind network.  Other clusters reach the host at the address of the
+// interface routing to their apiserver.
+func detectLocalIP() (string, error) {
+	out, err := exec.Command("kubectl", "config", "view", "--minify",
+		"-o", "jsonpath={.clusters[0].cluster.server}").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "could not read the apiserver of the current kubeconfig context, set --local-ip")
+	}
+	server, err := url.Parse(strings.TrimSpace(string(out)))
+	if err != nil || len(server.Hostname()) == 0 {
+		return "", errors.Errorf("could not parse the apiserver of the current kubeconfig context (%s), set --local-ip",
+			strings.TrimSpace(string(out)))
+	}
+
+	ips, err := net.LookupIP(server.Hostname())
+	if err != nil || len(ips) == 0 {
+		return "", errors.Errorf("could not resolve the apiserver %s, set --local-ip", server.Host)
+	}
+	if ips[0].IsLoopback() {
+		out, err := exec.Command("docker", "network", "inspect", kindNetwork,
+			"-f", "{{ range .IPAM.Config }}{{ .Gateway }} {{ end }}").Output()
+		if err != nil {
+			return "", errors.Errorf("the apiserver %s is forwarded from this host and there is no %s docker "+
+				"network, set --local-ip to the address the nodes reach this host at", server.Host, kindNetwork)
+		}
+		for _, gateway := range strings.Fields(string(out)) {
+			if ip := net.ParseIP(gateway); ip != nil && ip.To4() != nil {
+				return gateway, nil
+			}
+		}
+		return "", errors.Errorf("the %s docker network has no IPv4 gateway, set --local-ip", kindNetwork)
+	}
+
+	port := server.Port()
+	if len(port) == 0 {
+		port = "443"
+	}
+	// dialing udp sends no packets, it only picks the interface routing to the apiserver
+	conn, err := net.Dial("udp", net.JoinHostPort(ips[0].String(), port))
+	if err != nil {
+		return "", errors.Wrapf(err, "could not find the route to the apiserver %s, set --local-ip", server.Host)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+type localConfigTemplateArgs struct {
+	Versions  []APIVersion
+	Name      string
+	Namespace string
+	CACert    string
+	LocalIp   string
+	// Port is the secure port of the apiserver started with run local
+	Port int
+}
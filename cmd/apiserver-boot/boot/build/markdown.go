@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"regexp"
+	"strings"
+)
+
+var markdownLink = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+var markdownStrong = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+
+// markdownHTML renders the markdown of the static includes to html.  It supports the subset of
+// markdown the includes are written in: headings, paragraphs, lists, fenced code blocks, inline
+// code, strong emphasis and links.  Like in markdown, html outside of code is kept as is, and a
+// block starting with an html line is passed through up to the next blank line.
+func markdownHTML(src string) htmltemplate.HTML {
+	out := &strings.Builder{}
+	var paragraph []string
+	inList, inCode, inHTML := false, false, false
+	flush := func() {
+		if len(paragraph) > 0 {
+			fmt.Fprintf(out, "<p>%s</p>\n", markdownInline(strings.Join(paragraph, "\n")))
+			paragraph = nil
+		}
+		if inList {
+			out.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			if inCode {
+				out.WriteString("</code></pre>\n")
+			} else {
+				out.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+		case inCode:
+			out.WriteString(html.EscapeString(line) + "\n")
+		case len(trimmed) == 0:
+			flush()
+			inHTML = false
+		case inHTML:
+			out.WriteString(line + "\n")
+		case strings.HasPrefix(trimmed, "<") && len(paragraph) == 0:
+			flush()
+			out.WriteString(line + "\n")
+			inHTML = true
+		case strings.HasPrefix(trimmed, "#"):
+			flush()
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			text := markdownInline(strings.TrimSpace(trimmed[level:]))
+			if level > 6 {
+				level = 6
+			}
+			fmt.Fprintf(out, "<h%d>%s</h%d>\n", level, text, level)
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			if len(paragraph) > 0 {
+				flush()
+			}
+			if !inList {
+				out.WriteString("<ul>\n")
+				inList = true
+			}
+			fmt.Fprintf(out, "<li>%s</li>\n", markdownInline(trimmed[2:]))
+		default:
+			if inList {
+				flush()
+			}
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	if inCode {
+		out.WriteString("</code></pre>\n")
+	}
+	return htmltemplate.HTML(out.String())
+}
+
+// markdownInline renders the inline code, strong emphasis and links of a line of text
+func markdownInline(text string) string {
+	out := &strings.Builder{}
+	for i, part := range strings.Split(text, "`") {
+		if i%2 == 1 {
+			fmt.Fprintf(out, "<code>%s</code>", html.EscapeString(part))
+			continue
+		}
+		part = markdownLink.ReplaceAllString(part, `<a href="$2">$1</a>`)
+		out.WriteString(markdownStrong.ReplaceAllString(part, "<strong>$1</strong>"))
+	}
+	return out.String()
+}
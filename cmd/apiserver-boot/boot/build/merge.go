@@ -0,0 +1,198 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"strings"
+)
+
+const (
+	conflictCurrent   = "<<<<<<< current\n"
+	conflictSeparator = "=======\n"
+	conflictGenerated = ">>>>>>> generated\n"
+)
+
+// merge3 merges the changes from base to current, the file as edited by the user, with the
+// changes from base to generated, the newly rendered file, line by line.  Regions changed on both
+// sides differently are kept from both between conflict markers, and counted in conflicts.
+//
+// Lines are compared by their key, the line itself if key is nil.  Lines of current and generated
+// with equal keys but different content are taken from generated if the key changes the line, so
+// values hidden by key, e.g. redacted certificates, always come from the newly rendered file.
+func merge3(base, current, generated string, key func(string) string) (merged string, conflicts int) {
+	if key == nil {
+		key = func(line string) string { return line }
+	}
+	c, g := splitLines(current), splitLines(generated)
+	b, ck, gk := keyLines(splitLines(base), key), keyLines(c, key), keyLines(g, key)
+	inCurrent, inGenerated := lcs(b, ck), lcs(b, gk)
+
+	out := &strings.Builder{}
+	i, j, k := 0, 0, 0
+	for {
+		// find the next base line unchanged on both sides
+		x := i
+		for x < len(b) && (inCurrent[x] < j || inGenerated[x] < k) {
+			x++
+		}
+		cEnd, gEnd := len(c), len(g)
+		if x < len(b) {
+			cEnd, gEnd = inCurrent[x], inGenerated[x]
+		}
+		conflicts += mergeChunk(out, b[i:x], ck[j:cEnd], gk[k:gEnd], c[j:cEnd], g[k:gEnd], key)
+		if x == len(b) {
+			break
+		}
+		out.WriteString(pickLine(c[cEnd], g[gEnd], key))
+		i, j, k = x+1, cEnd+1, gEnd+1
+	}
+	return out.String(), conflicts
+}
+
+// mergeChunk writes the merge of a region between unchanged lines and returns 1 if it conflicts.
+// The regions are compared by the keys of their lines, and written from the lines.
+func mergeChunk(out *strings.Builder, base, currentKeys, generatedKeys, current, generated []string,
+	key func(string) string) int {
+	switch {
+	case equalLines(currentKeys, base):
+		writeLines(out, generated)
+	case equalLines(generatedKeys, base):
+		writeLines(out, current)
+	case equalLines(currentKeys, generatedKeys):
+		for i := range current {
+			out.WriteString(pickLine(current[i], generated[i], key))
+		}
+	default:
+		out.WriteString(conflictCurrent)
+		writeLines(out, terminated(current))
+		out.WriteString(conflictSeparator)
+		writeLines(out, terminated(generated))
+		out.WriteString(conflictGenerated)
+		return 1
+	}
+	return 0
+}
+
+// pickLine returns the line of generated if its key hides a value of it, and current otherwise
+func pickLine(current, generated string, key func(string) string) string {
+	if key(generated) != generated {
+		return generated
+	}
+	return current
+}
+
+func keyLines(lines []string, key func(string) string) []string {
+	keys := make([]string, len(lines))
+	for i, l := range lines {
+		keys[i] = key(l)
+	}
+	return keys
+}
+
+// commonLines returns the longest common subsequence of the lines of a and b, the base of a merge
+// without a last generated copy
+func commonLines(a, b string) string {
+	al, bl := splitLines(a), splitLines(b)
+	inB := lcs(al, bl)
+	out := &strings.Builder{}
+	for i, l := range al {
+		if inB[i] >= 0 {
+			out.WriteString(l)
+		}
+	}
+	return out.String()
+}
+
+// lcs matches the lines of a to the lines of b in a longest common subsequence, returning the
+// index in b of each line of a, or -1 if it is not matched
+func lcs(a, b []string) []int {
+	n, m := len(a), len(b)
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int, n+1)
+	for i := range lengths {
+		lengths[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	match := make([]int, n)
+	i, j := 0, 0
+	for i < n {
+		switch {
+		case j < m && a[i] == b[j]:
+			match[i] = j
+			i++
+			j++
+		case j < m && lengths[i][j+1] > lengths[i+1][j]:
+			j++
+		default:
+			match[i] = -1
+			i++
+		}
+	}
+	return match
+}
+
+// splitLines splits s after each new line, so joining the lines returns s
+func splitLines(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+	}
+}
+
+// terminated returns lines with a new line after the last line, so conflict markers start on
+// their own line
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	t := append([]string{}, lines...)
+	t[len(t)-1] += "\n"
+	return t
+}
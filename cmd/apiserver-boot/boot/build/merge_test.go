@@ -0,0 +1,157 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	base := "kind: Deployment\nreplicas: 1\nimage: a\nargs:\n- --v=1\n"
+	tests := []struct {
+		name      string
+		current   string
+		generated string
+		merged    string
+		conflicts int
+	}{
+		{
+			name:      "unchanged",
+			current:   base,
+			generated: base,
+			merged:    base,
+		},
+		{
+			name:      "generated change",
+			current:   base,
+			generated: "kind: Deployment\nreplicas: 1\nimage: b\nargs:\n- --v=1\n",
+			merged:    "kind: Deployment\nreplicas: 1\nimage: b\nargs:\n- --v=1\n",
+		},
+		{
+			name:      "user edit kept",
+			current:   "kind: Deployment\nreplicas: 3\nimage: a\nargs:\n- --v=1\n",
+			generated: base,
+			merged:    "kind: Deployment\nreplicas: 3\nimage: a\nargs:\n- --v=1\n",
+		},
+		{
+			name:      "user edit and generated change",
+			current:   "kind: Deployment\nreplicas: 3\nimage: a\nargs:\n- --v=1\n",
+			generated: "kind: Deployment\nreplicas: 1\nimage: a\nargs:\n- --v=1\n- --new\n",
+			merged:    "kind: Deployment\nreplicas: 3\nimage: a\nargs:\n- --v=1\n- --new\n",
+		},
+		{
+			name:      "same change on both sides",
+			current:   "kind: Deployment\nreplicas: 1\nimage: b\nargs:\n- --v=1\n",
+			generated: "kind: Deployment\nreplicas: 1\nimage: b\nargs:\n- --v=1\n",
+			merged:    "kind: Deployment\nreplicas: 1\nimage: b\nargs:\n- --v=1\n",
+		},
+		{
+			name:      "conflict",
+			current:   "kind: Deployment\nreplicas: 1\nimage: mine\nargs:\n- --v=1\n",
+			generated: "kind: Deployment\nreplicas: 1\nimage: b\nargs:\n- --v=1\n",
+			merged: "kind: Deployment\nreplicas: 1\n" +
+				conflictCurrent + "image: mine\n" + conflictSeparator + "image: b\n" + conflictGenerated +
+				"args:\n- --v=1\n",
+			conflicts: 1,
+		},
+		{
+			name:      "user deletion kept",
+			current:   "kind: Deployment\nreplicas: 1\nimage: a\n",
+			generated: "kind: Deployment\nreplicas: 2\nimage: a\nargs:\n- --v=1\n",
+			merged:    "kind: Deployment\nreplicas: 2\nimage: a\n",
+		},
+		{
+			name:      "no trailing new line",
+			current:   "kind: Deployment\nreplicas: 1\nimage: a\nargs:\n- --v=2",
+			generated: "kind: Deployment\nreplicas: 1\nimage: a\nargs:\n- --v=3\n",
+			merged: "kind: Deployment\nreplicas: 1\nimage: a\nargs:\n" +
+				conflictCurrent + "- --v=2\n" + conflictSeparator + "- --v=3\n" + conflictGenerated,
+			conflicts: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts := merge3(base, test.current, test.generated, nil)
+			if merged != test.merged {
+				t.Errorf("merged\n%s\nwant\n%s", merged, test.merged)
+			}
+			if conflicts != test.conflicts {
+				t.Errorf("conflicts %d want %d", conflicts, test.conflicts)
+			}
+		})
+	}
+}
+
+func TestCommonLines(t *testing.T) {
+	common := commonLines("a\nb\nc\nd\n", "a\nc\nx\nd\n")
+	if common != "a\nc\nd\n" {
+		t.Errorf("common lines %q want %q", common, "a\nc\nd\n")
+	}
+}
+
+func TestMerge3RotatedCertificates(t *testing.T) {
+	apiservice := func(caBundle, edit, extra string) string {
+		return edit + "kind: APIService\nspec:\n  caBundle: \"" + caBundle + "\"\n  version: v1\n" + extra
+	}
+	tests := []struct {
+		name      string
+		base      string
+		current   string
+		generated string
+		merged    string
+	}{
+		{
+			name:      "transitional caBundle of certs rotate",
+			base:      redactSecrets(apiservice("OLD", "", "")),
+			current:   apiservice("NEW+OLD", "# edited\n", ""),
+			generated: apiservice("NEW+OLD", "", "  versionPriority: 15\n"),
+			merged:    apiservice("NEW+OLD", "# edited\n", "  versionPriority: 15\n"),
+		},
+		{
+			name:      "finalized rotation",
+			base:      redactSecrets(apiservice("NEW+OLD", "", "")),
+			current:   apiservice("NEW+OLD", "# edited\n", ""),
+			generated: apiservice("NEW", "", ""),
+			merged:    apiservice("NEW", "# edited\n", ""),
+		},
+		{
+			name:      "unredacted last generated copy",
+			base:      apiservice("OLD", "", ""),
+			current:   apiservice("NEW+OLD", "", ""),
+			generated: apiservice("NEW+OLD", "", ""),
+			merged:    apiservice("NEW+OLD", "", ""),
+		},
+		{
+			name:      "certificate edited in place",
+			base:      redactSecrets(apiservice("OLD", "", "")),
+			current:   apiservice("NEW+OLD", "", ""),
+			generated: apiservice("OLD", "", ""),
+			merged:    apiservice("OLD", "", ""),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts := merge3(test.base, test.current, test.generated, redactLine)
+			if merged != test.merged {
+				t.Errorf("merged\n%s\nwant\n%s", merged, test.merged)
+			}
+			if conflicts != 0 {
+				t.Errorf("conflicts %d want 0", conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+const (
+	outputOCI = "oci"
+	outputTar = "tar"
+
+	// scratchImage is the empty base image, the image only contains the binaries
+	scratchImage = "scratch"
+)
+
+var imageLabels map[string]string
+
+// ociImage is an image assembled for one platform
+type ociImage struct {
+	platform ociPlatform
+	config   blob
+	manifest blob
+	layers   []blob
+}
+
+// buildImageWithoutDaemon assembles the image from the base image and the binaries built in dir
+// and writes it as an OCI image layout or docker load tarball, without a container engine.
+func buildImageWithoutDaemon(dir, output string) {
+	kind, path := output, ""
+	if i := strings.Index(output, ":"); i >= 0 {
+		kind, path = output[:i], output[i+1:]
+	}
+
+	var images []ociImage
+	for _, s := range containerPlatforms {
+		p, err := parsePlatform(s)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		img, err := assembleImage(p, filepath.Join(dir, p.dir()))
+		if err != nil {
+			klog.Fatal(err)
+		}
+		images = append(images, img)
+	}
+
+	switch kind {
+	case outputOCI:
+		if len(path) == 0 {
+			klog.Fatalf("--output oci requires a directory, e.g. oci:image")
+		}
+		if err := writeOCILayout(path, images); err != nil {
+			klog.Fatal(err)
+		}
+	case outputTar:
+		if len(path) == 0 {
+			path = "image.tar"
+		}
+		if len(images) != 1 {
+			klog.Fatalf("--output tar supports a single platform, use --output oci for multiple platforms")
+		}
+		if err := writeDockerTarball(path, images[0]); err != nil {
+			klog.Fatal(err)
+		}
+	default:
+		klog.Fatalf("--output must be oci:<dir> or tar[:<file>] was (%s)", output)
+	}
+	klog.Infof("Wrote image %s to %s", Image, path)
+}
+
+func assembleImage(platform ociPlatform, binDir string) (ociImage, error) {
+	img := ociImage{platform: platform}
+	created := buildTime()
+
+	cfg := map[string]interface{}{
+		"architecture": platform.Architecture,
+		"os":           platform.OS,
+	}
+	if len(platform.Variant) > 0 {
+		cfg["variant"] = platform.Variant
+	}
+	if BaseImage != scratchImage {
+		klog.Infof("Pulling base image %s for %s", BaseImage, platform)
+		base, err := pullBaseImage(BaseImage, platform)
+		if err != nil {
+			return img, err
+		}
+		if err := json.Unmarshal(base.config, &cfg); err != nil {
+			return img, errors.Wrapf(err, "failed parsing the config of %s", BaseImage)
+		}
+		for _, l := range base.layers {
+			if l.desc.MediaType == "application/vnd.docker.image.rootfs.diff.tar.gzip" {
+				l.desc.MediaType = mediaTypeOCILayerGzip
+			}
+			img.layers = append(img.layers, l)
+		}
+	}
+
+	layer, diffID, err := binariesLayer(binDir, created)
+	if err != nil {
+		return img, err
+	}
+	img.layers = append(img.layers, layer)
+
+	// the image config only depends on the base image, the binaries and buildTime
+	c, _ := cfg["config"].(map[string]interface{})
+	if c == nil {
+		c = map[string]interface{}{}
+	}
+	c["User"] = "65532:65532"
+	c["WorkingDir"] = "/"
+	entrypoint := "/controller-manager"
+	if buildApiserver() {
+		entrypoint = "/apiserver"
+	}
+	c["Entrypoint"] = []string{entrypoint}
+	delete(c, "Cmd")
+	labels, _ := c["Labels"].(map[string]interface{})
+	if labels == nil {
+		labels = map[string]interface{}{}
+	}
+	if commit := gitOutput("rev-parse", "HEAD"); len(commit) > 0 {
+		labels["org.opencontainers.image.revision"] = commit
+	}
+	for k, v := range imageLabels {
+		labels[k] = v
+	}
+	c["Labels"] = labels
+	cfg["config"] = c
+	cfg["created"] = created.Format(time.RFC3339)
+
+	rootfs, _ := cfg["rootfs"].(map[string]interface{})
+	if rootfs == nil {
+		rootfs = map[string]interface{}{"type": "layers"}
+	}
+	diffIDs, _ := rootfs["diff_ids"].([]interface{})
+	rootfs["diff_ids"] = append(diffIDs, diffID)
+	cfg["rootfs"] = rootfs
+	history, _ := cfg["history"].([]interface{})
+	cfg["history"] = append(history, map[string]interface{}{
+		"created":    created.Format(time.RFC3339),
+		"created_by": "apiserver-boot build container",
+	})
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return img, err
+	}
+	img.config = newBlob(mediaTypeOCIConfig, data)
+
+	m := manifest{SchemaVersion: 2, MediaType: mediaTypeOCIManifest, Config: &img.config.desc}
+	for _, l := range img.layers {
+		m.Layers = append(m.Layers, l.desc)
+	}
+	if data, err = json.Marshal(m); err != nil {
+		return img, err
+	}
+	img.manifest = newBlob(mediaTypeOCIManifest, data)
+	img.manifest.desc.Platform = &img.platform
+	return img, nil
+}
+
+// binariesLayer returns the gzip-compressed layer adding the binaries of binDir to the root of the
+// image, and the digest of the uncompressed layer.  Timestamps and owners are fixed so the layer
+// is reproducible.
+func binariesLayer(binDir string, created time.Time) (blob, string, error) {
+	var names []string
+	if buildApiserver() {
+		names = append(names, "apiserver")
+	}
+	if buildController() {
+		names = append(names, "controller-manager")
+	}
+	sort.Strings(names)
+
+	layer := &bytes.Buffer{}
+	tw := tar.NewWriter(layer)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(binDir, name))
+		if err != nil {
+			return blob{}, "", err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0755,
+			Size:     int64(len(data)),
+			ModTime:  created,
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return blob{}, "", err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return blob{}, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return blob{}, "", err
+	}
+
+	compressed := &bytes.Buffer{}
+	zw, err := gzip.NewWriterLevel(compressed, gzip.BestCompression)
+	if err != nil {
+		return blob{}, "", err
+	}
+	if _, err := zw.Write(layer.Bytes()); err != nil {
+		return blob{}, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return blob{}, "", err
+	}
+	return newBlob(mediaTypeOCILayerGzip, compressed.Bytes()), sha256Digest(layer.Bytes()), nil
+}
+
+func newBlob(mediaType string, data []byte) blob {
+	return blob{
+		desc: descriptor{MediaType: mediaType, Digest: sha256Digest(data), Size: int64(len(data))},
+		data: data,
+	}
+}
+
+// writeOCILayout writes the images to an OCI image layout directory.  Multiple platforms are
+// referenced by an image index.
+func writeOCILayout(dir string, images []ociImage) error {
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return err
+	}
+	write := func(b blob) error {
+		return ioutil.WriteFile(filepath.Join(blobs, strings.TrimPrefix(b.desc.Digest, "sha256:")), b.data, 0644)
+	}
+
+	var manifests []descriptor
+	for _, img := range images {
+		for _, b := range append([]blob{img.config, img.manifest}, img.layers...) {
+			if err := write(b); err != nil {
+				return err
+			}
+		}
+		manifests = append(manifests, img.manifest.desc)
+	}
+
+	top := manifests[0]
+	if len(manifests) > 1 {
+		data, err := json.Marshal(manifest{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: manifests})
+		if err != nil {
+			return err
+		}
+		index := newBlob(mediaTypeOCIIndex, data)
+		if err := write(index); err != nil {
+			return err
+		}
+		top = index.desc
+	}
+	top.Annotations = map[string]string{"org.opencontainers.image.ref.name": Image}
+
+	data, err := json.Marshal(manifest{SchemaVersion: 2, Manifests: []descriptor{top}})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+}
+
+// writeDockerTarball writes the image in the format read by docker load.
+func writeDockerTarball(path string, img ociImage) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	tw := tar.NewWriter(f)
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  buildTime(),
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	configName := strings.TrimPrefix(img.config.desc.Digest, "sha256:") + ".json"
+	if err := add(configName, img.config.data); err != nil {
+		return err
+	}
+	var layers []string
+	for _, l := range img.layers {
+		zr, err := gzip.NewReader(bytes.NewReader(l.data))
+		if err != nil {
+			return errors.Wrapf(err, "failed decompressing layer %s", l.desc.Digest)
+		}
+		data, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return errors.Wrapf(err, "failed decompressing layer %s", l.desc.Digest)
+		}
+		name := strings.TrimPrefix(sha256Digest(data), "sha256:") + "/layer.tar"
+		if err := add(name, data); err != nil {
+			return err
+		}
+		layers = append(layers, name)
+	}
+
+	tag := Image
+	if i := strings.LastIndex(tag, ":"); i <= strings.LastIndex(tag, "/") {
+		tag += ":latest"
+	}
+	data, err := json.Marshal([]map[string]interface{}{{
+		"Config":   configName,
+		"RepoTags": []string{tag},
+		"Layers":   layers,
+	}})
+	if err != nil {
+		return err
+	}
+	if err := add("manifest.json", data); err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		want     ociPlatform
+		dir      string
+		env      []string
+		err      bool
+	}{
+		{platform: "linux/amd64", want: ociPlatform{OS: "linux", Architecture: "amd64"}, dir: "linux_amd64"},
+		{platform: "linux/arm/v7", want: ociPlatform{OS: "linux", Architecture: "arm", Variant: "v7"},
+			dir: "linux_armv7", env: []string{"GOARM=7"}},
+		{platform: "linux/arm", want: ociPlatform{OS: "linux", Architecture: "arm"},
+			dir: "linux_armv7", env: []string{"GOARM=7"}},
+		{platform: "linux/arm/v6", want: ociPlatform{OS: "linux", Architecture: "arm", Variant: "v6"},
+			dir: "linux_armv6", env: []string{"GOARM=6"}},
+		{platform: "linux/arm64/v8", want: ociPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			dir: "linux_arm64"},
+		{platform: "linux/amd64/v3", want: ociPlatform{OS: "linux", Architecture: "amd64", Variant: "v3"},
+			dir: "linux_amd64v3", env: []string{"GOAMD64=v3"}},
+		{platform: "linux/arm/v9", err: true},
+		{platform: "linux", err: true},
+		{platform: "linux/", err: true},
+		{platform: "linux/arm/v7/x", err: true},
+	}
+	for _, test := range tests {
+		p, err := parsePlatform(test.platform)
+		var env []string
+		if err == nil {
+			env, err = p.goEnv()
+		}
+		switch {
+		case test.err && err == nil:
+			t.Errorf("parsePlatform(%q) succeeded", test.platform)
+		case !test.err && err != nil:
+			t.Errorf("parsePlatform(%q): %v", test.platform, err)
+		case !test.err && (p != test.want || p.dir() != test.dir || !reflect.DeepEqual(env, test.env)):
+			t.Errorf("parsePlatform(%q) = %+v dir %s env %v want %+v dir %s env %v",
+				test.platform, p, p.dir(), env, test.want, test.dir, test.env)
+		}
+	}
+}
+
+func TestSelectPlatform(t *testing.T) {
+	var manifests []descriptor
+	for _, p := range []ociPlatform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v6"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	} {
+		p := p
+		manifests = append(manifests, descriptor{Digest: "sha256:" + p.dir(), Platform: &p})
+	}
+	tests := []struct {
+		platform string
+		digest   string
+	}{
+		{platform: "linux/amd64", digest: "sha256:linux_amd64"},
+		{platform: "linux/arm/v6", digest: "sha256:linux_armv6"},
+		{platform: "linux/arm/v7", digest: "sha256:linux_armv7"},
+		{platform: "linux/arm", digest: "sha256:linux_armv7"},
+		{platform: "linux/arm64", digest: "sha256:linux_arm64"},
+		{platform: "linux/arm/v5"},
+		{platform: "linux/s390x"},
+	}
+	for _, test := range tests {
+		p, err := parsePlatform(test.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := selectPlatform(manifests, p)
+		switch {
+		case len(test.digest) == 0 && err == nil:
+			t.Errorf("selectPlatform(%s) = %s, want no manifest", test.platform, d.Digest)
+		case len(test.digest) > 0 && err != nil:
+			t.Errorf("selectPlatform(%s): %v", test.platform, err)
+		case d.Digest != test.digest:
+			t.Errorf("selectPlatform(%s) = %s want %s", test.platform, d.Digest, test.digest)
+		}
+	}
+}
+
+func TestAssembleImageIsReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"apiserver", "controller-manager"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/true "+name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(image, base string, targets []string) {
+		Image, BaseImage, BuildTargets = image, base, targets
+	}(Image, BaseImage, BuildTargets)
+	Image, BaseImage, BuildTargets = "example.com/acme:v1", scratchImage, []string{apiserverTarget, controllerTarget}
+	os.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	platform := ociPlatform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	var images []ociImage
+	var tarballs [][]byte
+	for i := 0; i < 2; i++ {
+		img, err := assembleImage(platform, dir)
+		if err != nil {
+			t.Fatalf("assembleImage: %v", err)
+		}
+		images = append(images, img)
+		path := filepath.Join(dir, "image.tar")
+		if err := writeDockerTarball(path, img); err != nil {
+			t.Fatalf("writeDockerTarball: %v", err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tarballs = append(tarballs, data)
+	}
+
+	a, b := images[0], images[1]
+	if len(a.layers) != 1 || len(b.layers) != 1 || a.layers[0].desc.Digest != b.layers[0].desc.Digest {
+		t.Errorf("the layers differ between builds")
+	}
+	if a.config.desc.Digest != b.config.desc.Digest {
+		t.Errorf("config %s differs from %s:\n%s\n%s", a.config.desc.Digest, b.config.desc.Digest,
+			a.config.data, b.config.data)
+	}
+	if a.manifest.desc.Digest != b.manifest.desc.Digest {
+		t.Errorf("manifest %s differs from %s", a.manifest.desc.Digest, b.manifest.desc.Digest)
+	}
+	if sha256Digest(tarballs[0]) != sha256Digest(tarballs[1]) {
+		t.Errorf("the docker load tarballs differ between builds")
+	}
+
+	cfg := map[string]interface{}{}
+	if err := json.Unmarshal(a.config.data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg["os"] != "linux" || cfg["architecture"] != "arm" || cfg["variant"] != "v7" {
+		t.Errorf("config platform %v/%v/%v want linux/arm/v7", cfg["os"], cfg["architecture"], cfg["variant"])
+	}
+	if p := a.manifest.desc.Platform; p == nil || *p != platform {
+		t.Errorf("manifest platform %+v want %+v", p, platform)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"os"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var GroupPriorityMinimum map[string]int
+
+const (
+	defaultGroupPriorityMinimum = 2000
+	versionPriorityStep         = 10
+)
+
+// APIVersion is a served API group version and its priorities in the APIService
+type APIVersion struct {
+	schema.GroupVersion
+	GroupPriorityMinimum int
+	VersionPriority      int
+
+	// groupName is the name of the group directory under pkg/apis
+	groupName  string
+	deprecated bool
+}
+
+// setAPIPriorities computes the priorities of the APIServices.  The versions of a group are
+// ranked like kubernetes versions (GA > beta > alpha, higher numbers first) with deprecated
+// versions last, so discovery prefers the most mature version.  groupPriorityMinimum defaults
+// to 2000 and may be set per group in the PROJECT file or with --group-priority-minimum.
+func setAPIPriorities() {
+	known := map[string]bool{}
+	byGroup := map[string][]int{}
+	for i, api := range Versions {
+		known[api.groupName] = true
+		known[api.Group] = true
+		byGroup[api.Group] = append(byGroup[api.Group], i)
+	}
+
+	minimums := map[string]int{}
+	if _, err := os.Stat(util.ProjectFile); err == nil {
+		p, err := util.LoadProject(util.ProjectFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		for g, m := range p.GroupPriorityMinimum {
+			// the PROJECT file may outlive a group removed from pkg/apis
+			if !known[g] {
+				klog.Warningf("Ignoring the groupPriorityMinimum of API group %s of the %s file, "+
+					"it is not under pkg/apis", g, util.ProjectFile)
+				continue
+			}
+			minimums[g] = m
+		}
+	}
+	for g, m := range GroupPriorityMinimum {
+		if !known[g] {
+			klog.Fatalf("--group-priority-minimum set for unknown API group %s", g)
+		}
+		minimums[g] = m
+	}
+	for g, m := range minimums {
+		if m <= 0 {
+			klog.Fatalf("groupPriorityMinimum of API group %s must be positive was (%d)", g, m)
+		}
+	}
+
+	for _, indexes := range byGroup {
+		sort.SliceStable(indexes, func(i, j int) bool {
+			a, b := Versions[indexes[i]], Versions[indexes[j]]
+			if a.deprecated != b.deprecated {
+				return b.deprecated
+			}
+			return version.CompareKubeAwareVersionStrings(a.Version, b.Version) > 0
+		})
+		for rank, i := range indexes {
+			api := &Versions[i]
+			api.VersionPriority = versionPriorityStep * (len(indexes) - rank)
+			api.GroupPriorityMinimum = defaultGroupPriorityMinimum
+			if m, ok := minimums[api.groupName]; ok {
+				api.GroupPriorityMinimum = m
+			}
+			if m, ok := minimums[api.Group]; ok {
+				api.GroupPriorityMinimum = m
+			}
+		}
+	}
+}
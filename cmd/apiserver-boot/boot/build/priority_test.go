@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+// priorities are the GroupPriorityMinimum and VersionPriority of a group version
+type priorities [2]int
+
+func TestSetAPIPriorities(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		project  string
+		flags    map[string]int
+		want     map[string]priorities
+	}{
+		{
+			name:     "GA > beta > alpha",
+			versions: []string{"insect/v1alpha1", "insect/v1beta1", "insect/v1", "insect/v2beta1", "insect/v1beta2"},
+			want: map[string]priorities{
+				"insect/v1":       {2000, 50},
+				"insect/v2beta1":  {2000, 40},
+				"insect/v1beta2":  {2000, 30},
+				"insect/v1beta1":  {2000, 20},
+				"insect/v1alpha1": {2000, 10},
+			},
+		},
+		{
+			name:     "deprecated versions last",
+			versions: []string{"insect/v1-deprecated", "insect/v1beta1", "insect/v1alpha1-deprecated", "bird/v1"},
+			want: map[string]priorities{
+				"insect/v1beta1":  {2000, 30},
+				"insect/v1":       {2000, 20},
+				"insect/v1alpha1": {2000, 10},
+				"bird/v1":         {2000, 10},
+			},
+		},
+		{
+			name:     "flag over PROJECT file",
+			versions: []string{"insect/v1", "bird/v1", "fish/v1"},
+			project:  "groupPriorityMinimum:\n  insect: 1000\n  bird.example.com: 1500\n",
+			flags:    map[string]int{"insect": 3000},
+			want: map[string]priorities{
+				"insect/v1": {3000, 10},
+				"bird/v1":   {1500, 10},
+				"fish/v1":   {2000, 10},
+			},
+		},
+		{
+			name:     "removed group of the PROJECT file",
+			versions: []string{"insect/v1"},
+			project:  "groupPriorityMinimum:\n  fish: 500\n  insect: 1000\n",
+			want: map[string]priorities{
+				"insect/v1": {1000, 10},
+			},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "priority")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func(v []APIVersion, m map[string]int) {
+		Versions, GroupPriorityMinimum = v, m
+	}(Versions, GroupPriorityMinimum)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(util.ProjectFile)
+			if len(test.project) > 0 {
+				if err := ioutil.WriteFile(util.ProjectFile, []byte(test.project), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			GroupPriorityMinimum = test.flags
+			Versions = nil
+			for _, v := range test.versions {
+				gv := strings.SplitN(strings.TrimSuffix(v, "-deprecated"), "/", 2)
+				Versions = append(Versions, APIVersion{
+					GroupVersion: schema.GroupVersion{Group: gv[0] + ".example.com", Version: gv[1]},
+					groupName:    gv[0],
+					deprecated:   strings.HasSuffix(v, "-deprecated"),
+				})
+			}
+
+			setAPIPriorities()
+			got := map[string]priorities{}
+			for _, api := range Versions {
+				got[api.groupName+"/"+api.Version] = priorities{api.GroupPriorityMinimum, api.VersionPriority}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("priorities %v want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+// rbacMarker is the marker the controllers declare the permissions they need with, e.g.
+// // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+const rbacMarker = "+kubebuilder:rbac:"
+
+// rbacRule is a PolicyRule of a generated Role or ClusterRole
+type rbacRule struct {
+	APIGroups       []string `json:"apiGroups,omitempty"`
+	Resources       []string `json:"resources,omitempty"`
+	ResourceNames   []string `json:"resourceNames,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+	Verbs           []string `json:"verbs"`
+}
+
+// key identifies the rules whose verbs are merged
+func (r rbacRule) key() string {
+	return strings.Join([]string{
+		strings.Join(r.APIGroups, "&"),
+		strings.Join(r.Resources, "&"),
+		strings.Join(r.ResourceNames, "&"),
+		strings.Join(r.NonResourceURLs, "&"),
+	}, " ")
+}
+
+// controllerRBAC holds the rules of the controller ClusterRole, and of the Roles of the markers
+// limited to a namespace.
+type controllerRBAC struct {
+	ClusterRules    []rbacRule
+	NamespacedRules map[string][]rbacRule
+}
+
+// Namespaces returns the namespaces of the Roles in a stable order
+func (c controllerRBAC) Namespaces() []string {
+	var namespaces []string
+	for ns := range c.NamespacedRules {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// rbacSkipDirs are not searched for markers
+var rbacSkipDirs = sets.NewString("vendor", "bin", "docs", "testdata", "node_modules")
+
+// parseRBACMarkers collects the +kubebuilder:rbac markers of the go files below root.
+func parseRBACMarkers(root string) (controllerRBAC, error) {
+	rules := map[string]map[string]rbacRule{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (rbacSkipDirs.Has(info.Name()) || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".go" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for line := 1; s.Scan(); line++ {
+			text := strings.TrimSpace(s.Text())
+			if !strings.HasPrefix(text, "//") {
+				continue
+			}
+			text = strings.TrimSpace(strings.TrimPrefix(text, "//"))
+			if !strings.HasPrefix(text, rbacMarker) {
+				continue
+			}
+			namespace, rule, err := parseRBACMarker(strings.TrimPrefix(text, rbacMarker))
+			if err != nil {
+				return errors.Wrapf(err, "%s:%d", path, line)
+			}
+			if rules[namespace] == nil {
+				rules[namespace] = map[string]rbacRule{}
+			}
+			if existing, ok := rules[namespace][rule.key()]; ok {
+				rule.Verbs = sets.NewString(existing.Verbs...).Insert(rule.Verbs...).List()
+			}
+			rules[namespace][rule.key()] = rule
+		}
+		return s.Err()
+	})
+	if err != nil {
+		return controllerRBAC{}, err
+	}
+
+	sorted := func(m map[string]rbacRule) []rbacRule {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var l []rbacRule
+		for _, k := range keys {
+			l = append(l, m[k])
+		}
+		return l
+	}
+	c := controllerRBAC{NamespacedRules: map[string][]rbacRule{}}
+	for ns, m := range rules {
+		if len(ns) == 0 {
+			c.ClusterRules = sorted(m)
+		} else {
+			c.NamespacedRules[ns] = sorted(m)
+		}
+	}
+	return c, nil
+}
+
+// parseRBACMarker parses the comma separated key=value arguments of a marker.  Values are
+// semicolon separated lists, or {} lists separated by commas or semicolons.  The core group is
+// core or "".
+func parseRBACMarker(args string) (string, rbacRule, error) {
+	rule := rbacRule{}
+	namespace := ""
+	for _, arg := range splitMarkerArgs(args) {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return "", rule, fmt.Errorf("invalid rbac marker argument %q", arg)
+		}
+		value := strings.TrimSpace(kv[1])
+		sep := ";"
+		if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+			value, sep = value[1:len(value)-1], ";,"
+		}
+		if len(value) == 0 {
+			return "", rule, fmt.Errorf("rbac marker argument %q has no value", arg)
+		}
+		var values []string
+		for _, v := range strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(sep, r) }) {
+			values = append(values, strings.Trim(strings.TrimSpace(v), `"`))
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "groups":
+			for i, g := range values {
+				if g == "core" {
+					values[i] = ""
+				}
+			}
+			rule.APIGroups = values
+		case "resources":
+			rule.Resources = values
+		case "resourceNames":
+			rule.ResourceNames = values
+		case "urls":
+			rule.NonResourceURLs = values
+		case "verbs":
+			rule.Verbs = values
+		case "namespace":
+			namespace = values[0]
+		default:
+			return "", rule, fmt.Errorf("unknown rbac marker argument %q", kv[0])
+		}
+	}
+	if len(rule.Verbs) == 0 {
+		return "", rule, fmt.Errorf("rbac marker must specify verbs")
+	}
+	if len(rule.NonResourceURLs) > 0 && len(namespace) > 0 {
+		return "", rule, fmt.Errorf("rbac marker with urls can not specify a namespace")
+	}
+	if len(rule.NonResourceURLs) == 0 && len(rule.Resources) == 0 {
+		return "", rule, fmt.Errorf("rbac marker must specify resources or urls")
+	}
+	sort.Strings(rule.APIGroups)
+	sort.Strings(rule.Resources)
+	sort.Strings(rule.ResourceNames)
+	sort.Strings(rule.NonResourceURLs)
+	rule.Verbs = sets.NewString(rule.Verbs...).List()
+	return namespace, rule, nil
+}
+
+// splitMarkerArgs splits the arguments at the commas outside of quotes and {} lists.
+func splitMarkerArgs(args string) []string {
+	var parts []string
+	depth, quoted, start := 0, false, 0
+	for i, c := range args {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '{' && !quoted:
+			depth++
+		case c == '}' && !quoted:
+			depth--
+		case c == ',' && !quoted && depth == 0:
+			parts = append(parts, args[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, args[start:])
+}
+
+// getControllerRBAC returns the rules of the controller.  Projects without markers fall back to
+// full access to their own API groups.
+func getControllerRBAC() controllerRBAC {
+	c, err := parseRBACMarkers(".")
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if len(c.ClusterRules) == 0 && len(c.NamespacedRules) == 0 {
+		klog.Warningf("No %s markers found, granting the controller full access to the API groups "+
+			"of the project", rbacMarker)
+		groups := sets.NewString()
+		for _, v := range Versions {
+			groups.Insert(v.Group)
+		}
+		c.ClusterRules = []rbacRule{{APIGroups: groups.List(), Resources: []string{"*"}, Verbs: []string{"*"}}}
+	}
+	return c
+}
+
+// rulesYaml renders rules as the yaml list of a Role indented by indent spaces.
+func rulesYaml(rules []rbacRule, indent int) string {
+	if len(rules) == 0 {
+		return " []"
+	}
+	return indentedYaml(rules, indent)
+}
+
+// apiserverServiceAccount and controllerServiceAccount are the service accounts of the
+// deployments, --service-account is used for both if set.
+func apiserverServiceAccount() string {
+	if len(ServiceAccount) > 0 {
+		return ServiceAccount
+	}
+	return Name + "-apiserver"
+}
+
+func controllerServiceAccount() string {
+	if len(ServiceAccount) > 0 {
+		return ServiceAccount
+	}
+	return Name + "-controller"
+}
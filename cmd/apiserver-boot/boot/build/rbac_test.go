@@ -0,0 +1,195 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSplitMarkerArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{
+			args: "groups=apps,resources=deployments,verbs=get;list",
+			want: []string{"groups=apps", "resources=deployments", "verbs=get;list"},
+		},
+		{
+			args: "groups={apps,batch},resources=jobs,verbs=get",
+			want: []string{"groups={apps,batch}", "resources=jobs", "verbs=get"},
+		},
+		{
+			args: `resourceNames="a,b",resources=configmaps,verbs=get`,
+			want: []string{`resourceNames="a,b"`, "resources=configmaps", "verbs=get"},
+		},
+		{
+			args: "verbs=get",
+			want: []string{"verbs=get"},
+		},
+	}
+	for _, test := range tests {
+		if got := splitMarkerArgs(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitMarkerArgs(%q) = %q want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestParseRBACMarker(t *testing.T) {
+	tests := []struct {
+		args      string
+		namespace string
+		rule      rbacRule
+		err       bool
+	}{
+		{
+			args: "groups=apps,resources=deployments;replicasets,verbs=watch;get;list;get",
+			rule: rbacRule{APIGroups: []string{"apps"}, Resources: []string{"deployments", "replicasets"},
+				Verbs: []string{"get", "list", "watch"}},
+		},
+		{
+			args: "groups={apps;batch},resources={jobs,deployments},verbs={get,list}",
+			rule: rbacRule{APIGroups: []string{"apps", "batch"}, Resources: []string{"deployments", "jobs"},
+				Verbs: []string{"get", "list"}},
+		},
+		{
+			args: `groups=core,resources=configmaps,resourceNames="settings",verbs=get`,
+			rule: rbacRule{APIGroups: []string{""}, Resources: []string{"configmaps"},
+				ResourceNames: []string{"settings"}, Verbs: []string{"get"}},
+		},
+		{
+			args: `groups="",resources=secrets,verbs=get`,
+			rule: rbacRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+		},
+		{
+			args:      "groups=core,resources=events,verbs=create,namespace=acme-system",
+			namespace: "acme-system",
+			rule:      rbacRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create"}},
+		},
+		{
+			args: "urls=/metrics;/healthz,verbs=get",
+			rule: rbacRule{NonResourceURLs: []string{"/healthz", "/metrics"}, Verbs: []string{"get"}},
+		},
+		{args: "groups=apps,resources=deployments", err: true},
+		{args: "groups=apps,verbs=get", err: true},
+		{args: "groups=apps,resources,verbs=get", err: true},
+		{args: "groups=apps,resources=deployments,verbs=get,color=blue", err: true},
+		{args: "urls=,verbs=get", err: true},
+		{args: "urls=/metrics,verbs=get,namespace=acme-system", err: true},
+		{args: "groups=core,resources=events,verbs=create,namespace=", err: true},
+	}
+	for _, test := range tests {
+		namespace, rule, err := parseRBACMarker(test.args)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("parseRBACMarker(%q) succeeded", test.args)
+		case !test.err && err != nil:
+			t.Errorf("parseRBACMarker(%q): %v", test.args, err)
+		case !test.err && (namespace != test.namespace || !reflect.DeepEqual(rule, test.rule)):
+			t.Errorf("parseRBACMarker(%q) = %q %+v want %q %+v", test.args, namespace, rule, test.namespace, test.rule)
+		}
+	}
+}
+
+// rbacProject writes the go files to a new project directory and changes to it
+func rbacProject(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetControllerRBAC(t *testing.T) {
+	defer rbacProject(t, map[string]string{
+		"controllers/deepone/controller.go": `package deepone
+
+// +kubebuilder:rbac:groups=innsmouth.example.com,resources=deepones,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create,namespace=acme-system
+type Reconciler struct{}
+`,
+		"controllers/festival/controller.go": `package festival
+
+// +kubebuilder:rbac:groups=innsmouth.example.com,resources=deepones,verbs=watch;list
+// +kubebuilder:rbac:groups=core,resources=events,verbs=patch,namespace=acme-system
+	//+kubebuilder:rbac:urls=/metrics,verbs=get
+type Reconciler struct{}
+`,
+		// markers of vendored and hidden directories are not the project's
+		"vendor/example.com/lib/controller.go": "// +kubebuilder:rbac:groups=*,resources=*,verbs=*\n",
+		".cache/controller.go":                 "// +kubebuilder:rbac:groups=*,resources=*,verbs=*\n",
+		"README.md":                            "// +kubebuilder:rbac:groups=*,resources=*,verbs=*\n",
+	})()
+
+	want := controllerRBAC{
+		ClusterRules: []rbacRule{
+			{NonResourceURLs: []string{"/metrics"}, Verbs: []string{"get"}},
+			{APIGroups: []string{"innsmouth.example.com"}, Resources: []string{"deepones"},
+				Verbs: []string{"get", "list", "watch"}},
+		},
+		NamespacedRules: map[string][]rbacRule{
+			"acme-system": {{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}}},
+		},
+	}
+	if got := getControllerRBAC(); !reflect.DeepEqual(got, want) {
+		t.Errorf("getControllerRBAC() = %+v want %+v", got, want)
+	}
+}
+
+func TestGetControllerRBACWithoutMarkers(t *testing.T) {
+	defer rbacProject(t, map[string]string{
+		"controllers/deepone/controller.go": "package deepone\n\n// Reconciler reconciles DeepOnes\ntype Reconciler struct{}\n",
+	})()
+	defer func(v []APIVersion) { Versions = v }(Versions)
+	Versions = []APIVersion{
+		{GroupVersion: schema.GroupVersion{Group: "kingsport.example.com", Version: "v1"}},
+		{GroupVersion: schema.GroupVersion{Group: "innsmouth.example.com", Version: "v1"}},
+		{GroupVersion: schema.GroupVersion{Group: "innsmouth.example.com", Version: "v1beta1"}},
+	}
+
+	want := controllerRBAC{
+		ClusterRules: []rbacRule{{APIGroups: []string{"innsmouth.example.com", "kingsport.example.com"},
+			Resources: []string{"*"}, Verbs: []string{"*"}}},
+		NamespacedRules: map[string][]rbacRule{},
+	}
+	if got := getControllerRBAC(); !reflect.DeepEqual(got, want) {
+		t.Errorf("getControllerRBAC() = %+v want %+v", got, want)
+	}
+}
@@ -0,0 +1,345 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig          = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayerGzip       = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// descriptor references a blob of an image
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// parsePlatform parses an <os>/<arch>[/<variant>] platform, e.g. linux/arm/v7
+func parsePlatform(s string) (ociPlatform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return ociPlatform{}, fmt.Errorf("--platforms must be a list of <os>/<arch>[/<variant>] was (%s)", s)
+	}
+	p := ociPlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p ociPlatform) String() string {
+	if len(p.Variant) > 0 {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// dir is the directory of the binaries of the platform, <os>_<arch><variant> like the
+// ${TARGETOS}_${TARGETARCH}${TARGETVARIANT} of the Dockerfile
+func (p ociPlatform) dir() string {
+	return p.OS + "_" + p.Architecture + p.targetVariant()
+}
+
+// normalizedVariant defaults the variant of arm and arm64 like the container runtimes do
+func (p ociPlatform) normalizedVariant() string {
+	switch {
+	case len(p.Variant) > 0:
+		return p.Variant
+	case p.Architecture == "arm64":
+		return "v8"
+	case p.Architecture == "arm":
+		return "v7"
+	}
+	return ""
+}
+
+// targetVariant is the TARGETVARIANT buildkit sets for the platform, e.g. v7 for linux/arm and
+// none for linux/arm64/v8
+func (p ociPlatform) targetVariant() string {
+	if p.Architecture == "arm64" && p.normalizedVariant() == "v8" {
+		return ""
+	}
+	return p.normalizedVariant()
+}
+
+// goEnv returns the environment selecting the variant of the platform for go build
+func (p ociPlatform) goEnv() ([]string, error) {
+	v := p.normalizedVariant()
+	switch {
+	case p.Architecture == "arm" && (v == "v5" || v == "v6" || v == "v7"):
+		return []string{"GOARM=" + strings.TrimPrefix(v, "v")}, nil
+	case p.Architecture == "amd64" && (v == "v1" || v == "v2" || v == "v3" || v == "v4"):
+		return []string{"GOAMD64=" + v}, nil
+	case len(p.Variant) == 0 || p.Architecture == "arm64" && v == "v8":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported variant %s of platform %s", p.Variant, p)
+}
+
+// manifest is an OCI image manifest or docker v2 schema 2 manifest, or an index / manifest list
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        *descriptor  `json:"config,omitempty"`
+	Layers        []descriptor `json:"layers,omitempty"`
+	Manifests     []descriptor `json:"manifests,omitempty"`
+}
+
+// baseImage is the config and layers of a pulled base image for one platform
+type baseImage struct {
+	config []byte
+	layers []blob
+}
+
+type blob struct {
+	desc descriptor
+	data []byte
+}
+
+// imageReference is a parsed registry/repository[:tag|@digest] image reference
+type imageReference struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+var digestMatch = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+func parseImageReference(image string) (imageReference, error) {
+	r := imageReference{registry: "registry-1.docker.io", reference: "latest"}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.reference = name[:i], name[i+1:]
+		if !digestMatch.MatchString(r.reference) {
+			return r, fmt.Errorf("invalid digest in image reference %s", image)
+		}
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.reference = name[:i], name[i+1:]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.registry, name = parts[0], parts[1]
+		if r.registry == "docker.io" {
+			r.registry = "registry-1.docker.io"
+		}
+	}
+	if r.registry == "registry-1.docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if len(name) == 0 {
+		return r, fmt.Errorf("invalid image reference %s", image)
+	}
+	r.repository = name
+	return r, nil
+}
+
+// registryClient pulls images anonymously with the docker registry HTTP API V2
+type registryClient struct {
+	ref   imageReference
+	token string
+}
+
+// pullBaseImage fetches the config and layers of image for the platform.
+func pullBaseImage(image string, platform ociPlatform) (*baseImage, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+	c := &registryClient{ref: ref}
+	m, err := c.manifest(ref.reference)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Manifests) > 0 {
+		d, err := selectPlatform(m.Manifests, platform)
+		if err != nil {
+			return nil, errors.Wrapf(err, "base image %s", image)
+		}
+		if m, err = c.manifest(d.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if m.Config == nil {
+		return nil, fmt.Errorf("base image %s has an unsupported manifest", image)
+	}
+	b := &baseImage{}
+	if b.config, err = c.blob(*m.Config); err != nil {
+		return nil, err
+	}
+	for _, l := range m.Layers {
+		data, err := c.blob(l)
+		if err != nil {
+			return nil, err
+		}
+		b.layers = append(b.layers, blob{desc: l, data: data})
+	}
+	return b, nil
+}
+
+// selectPlatform returns the manifest of the platform from a manifest list.  The variants are
+// compared with their defaults, e.g. linux/arm64 matches linux/arm64/v8.
+func selectPlatform(manifests []descriptor, platform ociPlatform) (descriptor, error) {
+	for _, d := range manifests {
+		if d.Platform != nil && d.Platform.OS == platform.OS && d.Platform.Architecture == platform.Architecture &&
+			d.Platform.normalizedVariant() == platform.normalizedVariant() {
+			return d, nil
+		}
+	}
+	return descriptor{}, fmt.Errorf("no manifest for platform %s", platform)
+}
+
+func (c *registryClient) manifest(reference string) (*manifest, error) {
+	data, err := c.get("manifests/"+reference, strings.Join([]string{
+		mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest,
+	}, ","))
+	if err != nil {
+		return nil, err
+	}
+	if digestMatch.MatchString(reference) {
+		if err := verifyDigest(reference, data); err != nil {
+			return nil, err
+		}
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "failed parsing manifest %s of %s", reference, c.ref.repository)
+	}
+	return m, nil
+}
+
+func (c *registryClient) blob(d descriptor) ([]byte, error) {
+	data, err := c.get("blobs/"+d.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	return data, verifyDigest(d.Digest, data)
+}
+
+func (c *registryClient) get(path, accept string) ([]byte, error) {
+	u := fmt.Sprintf("https://%s/v2/%s/%s", c.ref.registry, c.ref.repository, path)
+	resp, err := c.do(u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && len(c.token) == 0 {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(u, accept); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed fetching %s: %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *registryClient) do(u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed fetching %s", u)
+	}
+	return resp, nil
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authenticate gets an anonymous pull token from the realm of the Bearer challenge.
+func (c *registryClient) authenticate(challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("registry %s requires unsupported authentication %q", c.ref.registry, challenge)
+	}
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	q := url.Values{}
+	if s, ok := params["service"]; ok {
+		q.Set("service", s)
+	}
+	q.Set("scope", fmt.Sprintf("repository:%s:pull", c.ref.repository))
+	resp, err := http.Get(params["realm"] + "?" + q.Encode())
+	if err != nil {
+		return errors.Wrapf(err, "failed authenticating to %s", c.ref.registry)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed authenticating to %s: %s", c.ref.registry, resp.Status)
+	}
+	t := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return errors.Wrapf(err, "failed authenticating to %s", c.ref.registry)
+	}
+	c.token = t.Token
+	if len(c.token) == 0 {
+		c.token = t.AccessToken
+	}
+	return nil
+}
+
+func verifyDigest(digest string, data []byte) error {
+	if d := sha256Digest(data); d != digest {
+		return fmt.Errorf("digest mismatch, expected %s got %s", digest, d)
+	}
+	return nil
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
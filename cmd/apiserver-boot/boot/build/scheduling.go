@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+// leaderElectionArg enables the leader election of the controller-manager of the project
+const leaderElectionArg = "--enable-leader-election"
+
+var ApiserverReplicas = 2
+var ControllerReplicas = 2
+var ApiserverRequests = map[string]string{"cpu": "100m", "memory": "128Mi"}
+var ApiserverLimits = map[string]string{"cpu": "1", "memory": "512Mi"}
+var ControllerRequests = map[string]string{"cpu": "100m", "memory": "64Mi"}
+var ControllerLimits = map[string]string{"cpu": "500m", "memory": "256Mi"}
+var Probes = true
+var DisruptionBudget = true
+var TopologySpreadKeys = []string{"kubernetes.io/hostname", "topology.kubernetes.io/zone"}
+var PriorityClass string
+var LeaderElection = true
+
+var supportedResources = []string{"cpu", "memory", "ephemeral-storage"}
+
+func AddSchedulingFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&ApiserverReplicas, "apiserver-replicas", ApiserverReplicas,
+		"replicas of the apiserver Deployment, 1 with the filepath storage")
+	cmd.Flags().IntVar(&ControllerReplicas, "controller-replicas", ControllerReplicas,
+		"replicas of the controller Deployment, more than 1 requires --controller-leader-election")
+	cmd.Flags().StringToStringVar(&ApiserverRequests, "apiserver-requests", ApiserverRequests,
+		fmt.Sprintf("resource requests of the apiserver container, any of %v", supportedResources))
+	cmd.Flags().StringToStringVar(&ApiserverLimits, "apiserver-limits", ApiserverLimits,
+		fmt.Sprintf("resource limits of the apiserver container, any of %v", supportedResources))
+	cmd.Flags().StringToStringVar(&ControllerRequests, "controller-requests", ControllerRequests,
+		fmt.Sprintf("resource requests of the controller container, any of %v", supportedResources))
+	cmd.Flags().StringToStringVar(&ControllerLimits, "controller-limits", ControllerLimits,
+		fmt.Sprintf("resource limits of the controller container, any of %v", supportedResources))
+	cmd.Flags().BoolVar(&Probes, "probes", Probes,
+		"probe the apiserver container for liveness at /livez and readiness at /readyz")
+	cmd.Flags().BoolVar(&DisruptionBudget, "pod-disruption-budget", DisruptionBudget,
+		"create PodDisruptionBudgets allowing one pod of each Deployment to be evicted at a time")
+	cmd.Flags().StringSliceVar(&TopologySpreadKeys, "topology-spread-keys", TopologySpreadKeys,
+		"node labels the pods of each Deployment are spread across, empty to not spread the pods")
+	cmd.Flags().StringVar(&PriorityClass, "priority-class", "",
+		"name of an existing PriorityClass of the apiserver and controller pods")
+	cmd.Flags().BoolVar(&LeaderElection, "controller-leader-election", LeaderElection,
+		fmt.Sprintf("run the controller with %s, so only one of its replicas is active", leaderElectionArg))
+}
+
+func validateSchedulingFlags(cmd *cobra.Command) {
+	if usesStorage(storageFilepath) && ApiserverReplicas > 1 {
+		if cmd.Flags().Changed("apiserver-replicas") {
+			klog.Fatalf("--apiserver-replicas must be 1 with the filepath storage, its volume is ReadWriteOnce was (%d)",
+				ApiserverReplicas)
+		}
+		ApiserverReplicas = 1
+	}
+	if ApiserverReplicas < 1 {
+		klog.Fatalf("--apiserver-replicas must be at least 1 was (%d)", ApiserverReplicas)
+	}
+	if ControllerReplicas < 1 {
+		klog.Fatalf("--controller-replicas must be at least 1 was (%d)", ControllerReplicas)
+	}
+	if ControllerReplicas > 1 && !LeaderElection && !cmd.Flags().Changed("controller-replicas") {
+		ControllerReplicas = 1
+	}
+	if ControllerReplicas > 1 && !LeaderElection {
+		klog.Fatalf("--controller-replicas %d requires --controller-leader-election, or the replicas "+
+			"reconcile the same objects concurrently", ControllerReplicas)
+	}
+	validateResources("apiserver", ApiserverRequests, ApiserverLimits)
+	validateResources("controller", ControllerRequests, ControllerLimits)
+}
+
+// validateResources checks the requests and limits of component are quantities, and that no
+// request exceeds its limit
+func validateResources(component string, requests, limits map[string]string) {
+	quantities := map[string]map[string]resource.Quantity{}
+	for flag, resources := range map[string]map[string]string{"requests": requests, "limits": limits} {
+		quantities[flag] = map[string]resource.Quantity{}
+		for r, q := range resources {
+			if !sets.NewString(supportedResources...).Has(r) {
+				klog.Fatalf("--%s-%s must set any of %v was (%s)", component, flag, supportedResources, r)
+			}
+			quantity, err := resource.ParseQuantity(q)
+			if err != nil {
+				klog.Fatalf("--%s-%s %s must be a quantity was (%s)", component, flag, r, q)
+			}
+			quantities[flag][r] = quantity
+		}
+	}
+	for r, request := range quantities["requests"] {
+		if limit, ok := quantities["limits"][r]; ok && request.Cmp(limit) > 0 {
+			klog.Fatalf("--%s-requests %s must not exceed --%s-limits %s was (%s > %s)",
+				component, r, component, r, request.String(), limit.String())
+		}
+	}
+}
+
+// schedulingArgs holds the replicas, resources and placement of a Deployment
+type schedulingArgs struct {
+	Replicas int
+	Requests map[string]string
+	Limits   map[string]string
+	// Probes is true if the container is probed at /livez and /readyz
+	Probes           bool
+	DisruptionBudget bool
+	SpreadKeys       []string
+	PriorityClass    string
+}
+
+func apiserverScheduling() schedulingArgs {
+	return schedulingArgs{
+		Replicas:         ApiserverReplicas,
+		Requests:         ApiserverRequests,
+		Limits:           ApiserverLimits,
+		Probes:           Probes,
+		DisruptionBudget: DisruptionBudget,
+		SpreadKeys:       TopologySpreadKeys,
+		PriorityClass:    PriorityClass,
+	}
+}
+
+// controllerScheduling returns the scheduling of the controller, which serves no health
+// endpoints to probe
+func controllerScheduling() schedulingArgs {
+	return schedulingArgs{
+		Replicas:         ControllerReplicas,
+		Requests:         ControllerRequests,
+		Limits:           ControllerLimits,
+		DisruptionBudget: DisruptionBudget,
+		SpreadKeys:       TopologySpreadKeys,
+		PriorityClass:    PriorityClass,
+	}
+}
+
+// controllerArgs returns the --controller-args, with the leader election enabled unless it is
+// already set
+func controllerArgs() []string {
+	if !LeaderElection {
+		return ControllerArgs
+	}
+	for _, arg := range ControllerArgs {
+		if strings.HasPrefix(arg, leaderElectionArg) {
+			return ControllerArgs
+		}
+	}
+	return append([]string{leaderElectionArg}, ControllerArgs...)
+}
+
+// leaderElectionRules are the rules of the controller Role in its namespace, for the locks of
+// the leader election and the events recorded for it
+var leaderElectionRules = []rbacRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events"},
+		Verbs:     []string{"create", "patch"},
+	},
+}
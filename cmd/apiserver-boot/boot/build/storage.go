@@ -0,0 +1,286 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+const (
+	// storageEtcd stores the resources registered with WithResource in etcd
+	storageEtcd = "etcd"
+	// storageMySQL stores resources in MySQL through the kine storage provider
+	storageMySQL = "mysql"
+	// storageFilepath stores resources as files through the filepath storage provider
+	storageFilepath = "filepath"
+)
+
+var supportedStorage = []string{storageEtcd, storageMySQL, storageFilepath}
+
+var Storage []string
+var MySQLHost string
+var MySQLPort = 3306
+var MySQLUsername string
+var MySQLPassword string
+var MySQLDatabase string
+var MySQLSecret string
+var FilepathDir string
+var FilepathSize = "1Gi"
+
+// withoutEtcd is true if the apiserver is built WithoutEtcd, and so takes no etcd flags
+var withoutEtcd bool
+
+// mysqlEnv maps the environment the MySQL storage provider of the apiserver is configured with to
+// the keys of the MySQL secret
+var mysqlEnv = map[string]string{
+	"MYSQL_HOST":     "host",
+	"MYSQL_PORT":     "port",
+	"MYSQL_USERNAME": "username",
+	"MYSQL_PASSWORD": "password",
+	"MYSQL_DATABASE": "database",
+}
+
+// apiserverMainDir holds the sources of the apiserver the storage backends are detected from
+var apiserverMainDir = filepath.Join("cmd", "apiserver")
+
+var (
+	etcdResourceMatch     = regexp.MustCompile(`\bWithResource\(`)
+	mysqlProviderMatch    = regexp.MustCompile(`\bNewMysqlStorageProvider\(`)
+	filepathProviderMatch = regexp.MustCompile(`\bNewJSONFilepathStorageProvider\([^,]+,\s*"([^"]*)"`)
+	withoutEtcdMatch      = regexp.MustCompile(`\bWithoutEtcd\(\)`)
+)
+
+func AddStorageFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&Storage, "storage", []string{},
+		fmt.Sprintf("storage backends of the resources, any of %v, detected from %s by default",
+			supportedStorage, apiserverMainDir))
+	cmd.Flags().StringVar(&MySQLHost, "mysql-host", "", "host of the MySQL database for the mysql storage")
+	cmd.Flags().IntVar(&MySQLPort, "mysql-port", MySQLPort, "port of the MySQL database for the mysql storage")
+	cmd.Flags().StringVar(&MySQLUsername, "mysql-username", "", "user of the MySQL database for the mysql storage")
+	cmd.Flags().StringVar(&MySQLPassword, "mysql-password", "", "password of the MySQL user for the mysql storage")
+	cmd.Flags().StringVar(&MySQLDatabase, "mysql-database", "", "name of the MySQL database for the mysql storage")
+	cmd.Flags().StringVar(&MySQLSecret, "mysql-secret", "",
+		"name of an existing secret with the host, port, username, password and database keys for the mysql storage, "+
+			"instead of generating one from the --mysql flags")
+	cmd.Flags().StringVar(&FilepathDir, "filepath-dir", "",
+		"directory of the filepath storage in the apiserver container, detected from the storage provider by default")
+	cmd.Flags().StringVar(&FilepathSize, "filepath-size", FilepathSize,
+		"size of the volume claimed for the filepath storage")
+}
+
+func validateStorageFlags() {
+	detected, dir := detectStorage()
+	if len(Storage) == 0 {
+		Storage = detected
+		klog.Infof("Detected storage backends %v from %s", Storage, apiserverMainDir)
+	}
+	for _, s := range Storage {
+		if !sets.NewString(supportedStorage...).Has(s) {
+			klog.Fatalf("--storage must be any of %v was (%s)", supportedStorage, s)
+		}
+	}
+	if !usesEtcd() {
+		if externalEtcd() {
+			klog.Fatalf("--etcd-servers requires the etcd storage, was (%v)", Storage)
+		}
+		if !withoutEtcd {
+			klog.Warningf("no resource is stored in etcd, but the apiserver requires --etcd-servers unless it is "+
+				"built WithoutEtcd(), add it to the builder in %s", apiserverMainDir)
+		}
+	}
+	if usesStorage(storageMySQL) && len(MySQLSecret) == 0 {
+		if len(MySQLHost) == 0 {
+			klog.Fatalf("the mysql storage requires --mysql-host or --mysql-secret")
+		}
+		if len(MySQLPassword) == 0 {
+			klog.Warningf("no --mysql-password, set the password of the %s secret before applying the config",
+				mysqlSecret())
+		}
+	}
+	if usesStorage(storageFilepath) {
+		if len(FilepathDir) == 0 {
+			FilepathDir = dir
+		}
+		if _, err := resource.ParseQuantity(FilepathSize); err != nil {
+			klog.Fatalf("--filepath-size must be a quantity such as 1Gi was (%s)", FilepathSize)
+		}
+	}
+}
+
+// detectStorage returns the storage backends used by the apiserver builder, and the directory of
+// the filepath storage.  The resources registered WithResource are stored in etcd, the other
+// backends are found by their storage providers.
+func detectStorage() ([]string, string) {
+	files, _ := filepath.Glob(filepath.Join(apiserverMainDir, "*.go"))
+	var src []byte
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		src = append(src, data...)
+	}
+	if len(src) == 0 {
+		return []string{storageEtcd}, "data"
+	}
+
+	withoutEtcd = withoutEtcdMatch.Match(src)
+	var backends []string
+	if etcdResourceMatch.Match(src) {
+		backends = append(backends, storageEtcd)
+	}
+	if mysqlProviderMatch.Match(src) {
+		backends = append(backends, storageMySQL)
+	}
+	dir := "data"
+	if m := filepathProviderMatch.FindSubmatch(src); m != nil {
+		backends = append(backends, storageFilepath)
+		dir = string(m[1])
+	}
+	if len(backends) == 0 {
+		backends = []string{storageEtcd}
+	}
+	return backends, dir
+}
+
+func usesStorage(s string) bool {
+	return sets.NewString(Storage...).Has(s)
+}
+
+// usesEtcd is true if resources are stored in etcd
+func usesEtcd() bool {
+	return usesStorage(storageEtcd)
+}
+
+// deployEtcd is true if build config deploys the etcd StatefulSet
+func deployEtcd() bool {
+	return usesEtcd() && !externalEtcd()
+}
+
+// mysqlSecret returns the secret holding the MySQL connection, empty without the mysql storage
+func mysqlSecret() string {
+	switch {
+	case !usesStorage(storageMySQL):
+		return ""
+	case len(MySQLSecret) > 0:
+		return MySQLSecret
+	}
+	return Name + "-mysql"
+}
+
+// filepathClaim returns the volume claim of the filepath storage, empty without the filepath storage
+func filepathClaim() string {
+	if !usesStorage(storageFilepath) {
+		return ""
+	}
+	return Name + "-apiserver-data"
+}
+
+// filepathMountPath returns where the filepath storage is mounted.  Relative directories are
+// below the working directory / of the apiserver container.
+func filepathMountPath() string {
+	return path.Join("/", strings.TrimPrefix(filepath.ToSlash(FilepathDir), "./"))
+}
+
+type storageYamlArgs struct {
+	Name         string
+	Namespace    string
+	StorageClass string
+	// MySQLSecret is the secret to create, empty if not generated
+	MySQLSecret   string
+	MySQLHost     string
+	MySQLPort     int
+	MySQLUsername string
+	MySQLPassword string
+	MySQLDatabase string
+	FilepathClaim string
+	FilepathSize  string
+}
+
+func newStorageYamlArgs() storageYamlArgs {
+	a := storageYamlArgs{
+		Name:          Name,
+		Namespace:     Namespace,
+		StorageClass:  StorageClass,
+		MySQLHost:     MySQLHost,
+		MySQLPort:     MySQLPort,
+		MySQLUsername: MySQLUsername,
+		MySQLPassword: MySQLPassword,
+		MySQLDatabase: MySQLDatabase,
+		FilepathClaim: filepathClaim(),
+		FilepathSize:  FilepathSize,
+	}
+	if len(MySQLSecret) == 0 {
+		a.MySQLSecret = mysqlSecret()
+	}
+	return a
+}
+
+// needsStorageYaml is true if the storage backends need resources besides the apiserver
+func needsStorageYaml() bool {
+	a := newStorageYamlArgs()
+	return len(a.MySQLSecret) > 0 || len(a.FilepathClaim) > 0
+}
+
+// storageYaml holds the MySQL connection the apiserver reads its MYSQL_ environment from, and
+// the volume claim of the filepath storage.
+var storageYaml = `{{- if .MySQLSecret }}
+---
+apiVersion: v1
+kind: Secret
+type: Opaque
+metadata:
+  name: {{ .MySQLSecret }}
+  namespace: {{ .Namespace }}
+  labels:
+    api: {{ .Name }}
+    apiserver: "true"
+stringData:
+  host: "{{ .MySQLHost }}"
+  port: "{{ .MySQLPort }}"
+  username: "{{ .MySQLUsername }}"
+  password: "{{ .MySQLPassword }}"
+  database: "{{ .MySQLDatabase }}"
+{{- end }}
+{{- if .FilepathClaim }}
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: {{ .FilepathClaim }}
+  namespace: {{ .Namespace }}
+  labels:
+    api: {{ .Name }}
+    apiserver: "true"
+spec:
+  storageClassName: {{ .StorageClass }}
+  accessModes: [ "ReadWriteOnce" ]
+  resources:
+    requests:
+      storage: {{ .FilepathSize }}
+{{- end }}
+`
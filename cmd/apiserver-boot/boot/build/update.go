@@ -0,0 +1,219 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var UpdateConfig bool
+var CheckConfig bool
+
+// generatedDir holds the last generated copy of each file of the config, below its path relative
+// to the project.  It is the base the edits of the config are merged with by --update.  The
+// certificates, keys and passwords are redacted in the copies, so they can be committed.
+var generatedDir = filepath.Join(".apiserver-boot", "generated")
+
+// secretField matches the lines of the config holding certificates, keys and passwords
+var secretField = regexp.MustCompile(`^([ \t]*"?(?:ca|caBundle|caCrt|ca\.crt|tls\.crt|tls\.key|tlsCrt|tlsKey|` +
+	`serverCrt|serverKey|peerCrt|peerKey|clientCrt|clientKey|password)"?:[ \t]*)(\S.*?)([ \t]*\n?)$`)
+
+// redacted replaces the values of the secretFields in the last generated copies
+const redacted = `"<redacted>"`
+
+// outdated and conflicted collect the files reported by --check and --update
+var outdated []string
+var conflicted []string
+
+func AddUpdateFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&UpdateConfig, "update", false,
+		"re-render the existing config and merge it with the edits made since it was generated, "+
+			"the conflicting edits are marked in the files")
+	cmd.Flags().BoolVar(&CheckConfig, "check", false,
+		"fail if the config is out of date with the project, e.g. with the API groups under pkg/apis, "+
+			"without writing it")
+}
+
+func validateUpdateFlags() {
+	if UpdateConfig && CheckConfig {
+		klog.Fatalf("--update conflicts with --check")
+	}
+}
+
+// writeConfig writes a file of the config rendered from the template.  Existing files are kept,
+// unless --update merges them with the newly rendered file, or --check compares them.  It returns
+// false if an existing file was kept.
+func writeConfig(path, templateName, templateValue string, data interface{}) bool {
+	generated := util.Render(templateName, templateValue, data)
+	switch {
+	case CheckConfig:
+		checkConfigFile(path, redactSecrets(generated))
+		return true
+	case UpdateConfig:
+		updateConfigFile(path, generated)
+		return true
+	}
+	if !util.WriteIfNotFound(path, templateName, "{{ . }}", generated) {
+		return false
+	}
+	storeGenerated(path, generated)
+	return true
+}
+
+// checkConfigFile records path as outdated if the redacted generated differs from the last
+// generated copy, or from the file if there is no copy.  Certificates, keys and passwords are not
+// compared, they change with apiserver-boot certs rotate rather than with the project.
+func checkConfigFile(path, generated string) {
+	base, ok := readGenerated(path)
+	if !ok {
+		current, err := ioutil.ReadFile(path)
+		if err != nil {
+			outdated = append(outdated, path)
+			return
+		}
+		base = string(current)
+	}
+	if redactSecrets(base) != generated {
+		outdated = append(outdated, path)
+	}
+}
+
+// updateConfigFile merges the changes from the last generated copy of path to generated into the
+// file.  Without a copy only the lines common to the file and generated are taken as unchanged.
+func updateConfigFile(path, generated string) {
+	current, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		util.WriteIfNotFound(path, path, "{{ . }}", generated)
+		storeGenerated(path, generated)
+		klog.Infof("Created %s", path)
+		return
+	}
+	if err != nil {
+		klog.Fatalf("Could not read %s: %v", path, err)
+	}
+	if strings.Contains(string(current), conflictCurrent) {
+		klog.Errorf("%s still has conflicting edits of the last --update, keeping it", path)
+		conflicted = append(conflicted, path)
+		return
+	}
+	base, ok := readGenerated(path)
+	if !ok {
+		klog.Warningf("No last generated copy of %s in %s, keeping the lines it adds to the generated file",
+			path, generatedDir)
+		base = commonLines(redactSecrets(string(current)), redactSecrets(generated))
+	}
+	merged, conflicts := merge3(base, string(current), generated, redactLine)
+	if merged != string(current) {
+		if err := ioutil.WriteFile(path, []byte(merged), 0644); err != nil {
+			klog.Fatalf("Could not write %s: %v", path, err)
+		}
+		klog.Infof("Updated %s", path)
+	}
+	if conflicts > 0 {
+		klog.Errorf("%s has %d conflicting edits, marked between %q and %q", path, conflicts,
+			strings.TrimSpace(conflictCurrent), strings.TrimSpace(conflictGenerated))
+		conflicted = append(conflicted, path)
+	}
+	storeGenerated(path, generated)
+}
+
+// generatedPath returns the path of the last generated copy of path, empty if path is outside of
+// the project
+func generatedPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		klog.Fatalf("Cannot get working directory %v", err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.Join(generatedDir, rel)
+}
+
+func readGenerated(path string) (string, bool) {
+	p := generatedPath(path)
+	if len(p) == 0 {
+		return "", false
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// storeGenerated writes the last generated copy of path, with its secrets redacted
+func storeGenerated(path, generated string) {
+	p := generatedPath(path)
+	if len(p) == 0 {
+		return
+	}
+	os.MkdirAll(filepath.Dir(p), 0700)
+	if err := ioutil.WriteFile(p, []byte(redactSecrets(generated)), 0600); err != nil {
+		klog.Fatalf("Could not write %s: %v", p, err)
+	}
+}
+
+// redactSecrets replaces the values of the certificates, keys and passwords of a file of the
+// config.  Empty values and helm template expressions are kept.
+func redactSecrets(s string) string {
+	out := &strings.Builder{}
+	for _, line := range splitLines(s) {
+		out.WriteString(redactLine(line))
+	}
+	return out.String()
+}
+
+func redactLine(line string) string {
+	m := secretField.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+	value := m[2]
+	if value == `""` || value == `''` || strings.Contains(value, "{{") ||
+		strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+		return line
+	}
+	return m[1] + redacted + m[3]
+}
+
+// reportConfig fails if --check found outdated files or --update left conflicts
+func reportConfig() {
+	switch {
+	case CheckConfig && len(outdated) > 0:
+		klog.Fatalf("The config is out of date, run apiserver-boot build config --update:\n\t%s",
+			strings.Join(outdated, "\n\t"))
+	case CheckConfig:
+		klog.Infof("The config is up to date.")
+	case UpdateConfig && len(conflicted) > 0:
+		klog.Fatalf("Resolve the conflicting edits of:\n\t%s", strings.Join(conflicted, "\n\t"))
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "  tls.key: LS0tLS1CRUdJTg==\n", want: "  tls.key: \"<redacted>\"\n"},
+		{line: "  tls.crt: LS0tLS1CRUdJTg==", want: "  tls.crt: \"<redacted>\""},
+		{line: "  caBundle: \"LS0tLS1CRUdJTg==\"\n", want: "  caBundle: \"<redacted>\"\n"},
+		{line: "  ca.crt: LS0t\n", want: "  ca.crt: \"<redacted>\"\n"},
+		{line: "    clientKey: \"LS0t\"\n", want: "    clientKey: \"<redacted>\"\n"},
+		{line: "    ca: \"LS0t\"\n", want: "    ca: \"<redacted>\"\n"},
+		{line: "  password: \"hunter2\"\n", want: "  password: \"<redacted>\"\n"},
+		// empty values and helm templates hold no secrets
+		{line: "  password: \"\"\n", want: "  password: \"\"\n"},
+		{line: "  tls.crt: {{ $cert.Cert | b64enc }}\n", want: "  tls.crt: {{ $cert.Cert | b64enc }}\n"},
+		{line: "  caBundle:\n", want: "  caBundle:\n"},
+		{line: "  image: acme:latest\n", want: "  image: acme:latest\n"},
+		{line: "  cacheSize: 100\n", want: "  cacheSize: 100\n"},
+		{line: "  privateKeySecretRef: tls.key\n", want: "  privateKeySecretRef: tls.key\n"},
+	}
+	for _, test := range tests {
+		if got := redactSecrets(test.line); got != test.want {
+			t.Errorf("redactSecrets(%q) = %q want %q", test.line, got, test.want)
+		}
+	}
+}
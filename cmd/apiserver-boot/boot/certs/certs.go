@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+const (
+	caName      = "apiserver_ca"
+	servingName = "apiserver"
+	// previousCAName is the CA replaced by a rotation until the rotation is finalized
+	previousCAName = "apiserver_ca_previous"
+	// localCluster is the cluster of the kubeconfig written by run local
+	localCluster = "apiserver"
+	// localUser is the user and context of the kubeconfig written by run local
+	localUser = "apiserver"
+)
+
+var certDir string
+
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Inspect, rotate and issue the apiserver certificates",
+	Long:  `Inspect and rotate the certificates generated by apiserver-boot build config, and issue client certificates`,
+	Example: `# Report the expiry and names of the certificates
+apiserver-boot certs status
+
+# Rotate the CA and serving certificate
+apiserver-boot certs rotate
+
+# Issue a client certificate for alice to test authorization with run local
+apiserver-boot certs issue-user --user alice --group devs`,
+	Run: RunCerts,
+}
+
+func AddCerts(cmd *cobra.Command) {
+	certsCmd.PersistentFlags().StringVar(&certDir, "cert-dir", "",
+		"directory of the certificates, defaults to config/certificates or config/base/certificates")
+	cmd.AddCommand(certsCmd)
+	AddCertsStatus(certsCmd)
+	AddCertsRotate(certsCmd)
+	AddCertsIssueUser(certsCmd)
+}
+
+func RunCerts(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
+
+// getCertDir returns --cert-dir, or where build config wrote the certificates
+func getCertDir() string {
+	if len(certDir) > 0 {
+		return certDir
+	}
+	return util.CertificatesDir()
+}
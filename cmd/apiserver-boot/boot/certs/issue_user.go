@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var user string
+var groups []string
+var kubeconfig string
+var server string
+var userValidity time.Duration
+var useContext bool
+
+var issueUserCmd = &cobra.Command{
+	Use:   "issue-user",
+	Short: "Issue a client certificate for a user and add it to the kubeconfig",
+	Long: `Issue a client certificate for a user and its groups signed by the local CA, and add a user
+and context of the same name to the kubeconfig written by apiserver-boot run local.  Requires
+the apiserver to run with mTLS, i.e. apiserver-boot run local --disable-mtls=false.`,
+	Example: `# Issue a certificate for alice in the devs group and use it
+apiserver-boot certs issue-user --user alice --group devs
+kubectl --kubeconfig kubeconfig --context alice get <type>
+
+# Issue a certificate for bob and make it the current context
+apiserver-boot certs issue-user --user bob --group devs --group qa --use-context`,
+	Run: RunCertsIssueUser,
+}
+
+func AddCertsIssueUser(cmd *cobra.Command) {
+	issueUserCmd.Flags().StringVar(&user, "user", "", "name of the user, the common name of the certificate")
+	issueUserCmd.Flags().StringSliceVar(&groups, "group", []string{},
+		"groups of the user, the organizations of the certificate")
+	issueUserCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kubeconfig", "path to the kubeconfig to add the user to")
+	issueUserCmd.Flags().StringVar(&server, "server", "https://localhost:9443",
+		"address of the apiserver, if the kubeconfig has no apiserver cluster yet")
+	issueUserCmd.Flags().DurationVar(&userValidity, "validity", time.Hour*24*365, "how long the certificate is valid")
+	issueUserCmd.Flags().BoolVar(&useContext, "use-context", false, "if true, make the user the current context")
+	cmd.AddCommand(issueUserCmd)
+}
+
+var userNameMatch = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@-]*$`)
+
+func RunCertsIssueUser(cmd *cobra.Command, args []string) {
+	if len(user) == 0 {
+		klog.Fatalf("must specify --user")
+	}
+	if !userNameMatch.MatchString(user) {
+		klog.Fatalf("--user must match %s was (%s)", userNameMatch, user)
+	}
+	if user == localUser {
+		klog.Fatalf("--user %s is reserved for the user of apiserver-boot run local", user)
+	}
+	if userValidity <= 0 {
+		klog.Fatalf("--validity must be positive was (%s)", userValidity)
+	}
+	dir, err := filepath.Abs(getCertDir())
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	caCert, caKey, err := util.TryLoadCertAndKeyFromDisk(dir, caName)
+	if err != nil {
+		klog.Fatalf("failed loading the CA, run apiserver-boot build config first: %v", err)
+	}
+	algorithm, size := util.KeyAlgorithmOf(caCert.PublicKey)
+	cert, key, err := util.NewCertAndKey(caCert, caKey, util.Config{
+		CommonName:   user,
+		Organization: groups,
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyAlgorithm: algorithm,
+		KeySize:      size,
+		Validity:     userValidity,
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+	name := "user-" + user
+	if err := util.WriteCertAndKey(dir, name, cert, key); err != nil {
+		klog.Fatal(err)
+	}
+	klog.Infof("Wrote %s", filepath.Join(dir, name+".crt"))
+
+	err = util.UpdateKubeconfig(kubeconfig, func(config *clientcmdv1.Config) {
+		cluster, ok := util.GetKubeconfigCluster(config, localCluster)
+		if !ok {
+			cluster.Server = server
+		}
+		if cluster.InsecureSkipTLSVerify {
+			klog.Warningf("the kubeconfig skips TLS verification, the apiserver does not authenticate " +
+				"client certificates unless started with apiserver-boot run local --disable-mtls=false")
+		} else {
+			cluster.CertificateAuthority = filepath.Join(dir, caName+".crt")
+		}
+		util.SetKubeconfigCluster(config, localCluster, cluster)
+		util.SetKubeconfigUser(config, user, clientcmdv1.AuthInfo{
+			ClientCertificate: filepath.Join(dir, name+".crt"),
+			ClientKey:         filepath.Join(dir, name+".key"),
+		})
+		util.SetKubeconfigContext(config, user, clientcmdv1.Context{Cluster: localCluster, AuthInfo: user}, useContext)
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+	klog.Infof("Added user and context %s to %s, try kubectl --kubeconfig %s --context %s api-resources",
+		user, kubeconfig, kubeconfig, user)
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var finalize bool
+var dryRun bool
+var validity time.Duration
+var manifestDirs []string
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the CA and serving certificate of the apiserver",
+	Long: `Rotate the CA and serving certificate of the apiserver in two steps.
+
+The first step creates a new CA and a serving certificate signed by it, and updates the manifests
+with the new serving certificate and a transitional caBundle trusting both the previous and the new
+CA.  Once the manifests are applied and the apiserver serves the new certificate, the second step
+(--finalize) updates the caBundle to trust only the new CA.
+
+The certificates are updated in place in the yaml manifests and the helm values.yaml files below
+--manifests.  Only the caBundle, caCrt, tls.crt and tlsCrt fields holding a certificate of the
+rotated CA or the rotated serving certificate are replaced, along with the tls.key and tlsKey
+fields next to a replaced certificate.  The fields holding other certificates, e.g. the caBundle
+of a webhook or the etcd certificates, are kept.  The serving certificates the kustomize overlays
+generate their secrets from are rotated in config/overlays/<overlay>/namespaced/certificates.`,
+	Example: `# List the manifests a rotation updates
+apiserver-boot certs rotate --dry-run
+
+# Create the new certificates with the transitional caBundle, and apply them
+apiserver-boot certs rotate
+kubectl apply -f config/
+
+# Once the apiserver is rolled out, trust only the new CA
+apiserver-boot certs rotate --finalize
+kubectl apply -f config/`,
+	Run: RunCertsRotate,
+}
+
+func AddCertsRotate(cmd *cobra.Command) {
+	rotateCmd.Flags().BoolVar(&finalize, "finalize", false,
+		"finish a rotation by removing the previous CA from the caBundle")
+	rotateCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"list the manifests the rotation updates without changing the certificates or manifests")
+	rotateCmd.Flags().DurationVar(&validity, "validity", time.Hour*24*365, "how long the new certificates are valid")
+	rotateCmd.Flags().StringSliceVar(&manifestDirs, "manifests", []string{"config", "charts"},
+		"directories of the manifests and helm charts to update")
+	cmd.AddCommand(rotateCmd)
+}
+
+func RunCertsRotate(cmd *cobra.Command, args []string) {
+	dir := getCertDir()
+	_, err := os.Stat(filepath.Join(dir, previousCAName+".crt"))
+	inProgress := err == nil
+
+	if finalize {
+		if !inProgress {
+			klog.Fatalf("no CA rotation in progress in %s", dir)
+		}
+		caData := readFile(filepath.Join(dir, caName+".crt"))
+		cas, err := util.ParseCertsPEM(append(caData, readFile(filepath.Join(dir, previousCAName+".crt"))...))
+		if err != nil {
+			klog.Fatal(err)
+		}
+		// the manifests may still trust only the previous CA, or the transitional caBundle
+		if !updateManifests(rotation{cas: cas, caBundle: caData}) {
+			return
+		}
+		if err := os.Remove(filepath.Join(dir, previousCAName+".crt")); err != nil {
+			klog.Fatal(err)
+		}
+		klog.Infof("Finalized the rotation, apply the updated manifests to trust only the new CA")
+		return
+	}
+
+	if inProgress {
+		klog.Fatalf("a CA rotation is in progress, run apiserver-boot certs rotate --finalize first")
+	}
+	if validity <= 0 {
+		klog.Fatalf("--validity must be positive was (%s)", validity)
+	}
+	// expired certificates may be rotated as well
+	oldCA, err := loadCert(dir, caName)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	// the kustomize base only has the CA, each overlay has a serving certificate for its namespace
+	servingDirs := util.OverlayCertificatesDirs(dir)
+	if _, err := os.Stat(filepath.Join(dir, servingName+".crt")); err == nil || len(servingDirs) == 0 {
+		servingDirs = append([]string{dir}, servingDirs...)
+	}
+	oldCAData := readFile(filepath.Join(dir, caName+".crt"))
+
+	// the new certificates keep the names and key algorithms of the current ones
+	algorithm, size := util.KeyAlgorithmOf(oldCA.PublicKey)
+	caCert, caKey, err := util.NewCACertAndKey(util.Config{
+		CommonName:   oldCA.Subject.CommonName,
+		Organization: oldCA.Subject.Organization,
+		KeyAlgorithm: algorithm,
+		KeySize:      size,
+		Validity:     validity,
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+	r := rotation{
+		cas:      []*x509.Certificate{oldCA},
+		caBundle: append(util.EncodeCertPEM(caCert), oldCAData...),
+	}
+	issued := map[string]*x509.Certificate{}
+	keys := map[string]crypto.Signer{}
+	for _, d := range servingDirs {
+		serving, err := loadCert(d, servingName)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		algorithm, size = util.KeyAlgorithmOf(serving.PublicKey)
+		cert, key, err := util.NewCertAndKey(caCert, caKey, util.Config{
+			CommonName:   serving.Subject.CommonName,
+			Organization: serving.Subject.Organization,
+			AltNames:     util.AltNames{DNSNames: serving.DNSNames, IPs: serving.IPAddresses},
+			Usages:       serving.ExtKeyUsage,
+			KeyAlgorithm: algorithm,
+			KeySize:      size,
+			Validity:     validity,
+		})
+		if err != nil {
+			klog.Fatal(err)
+		}
+		issued[d], keys[d] = cert, key
+		// the manifests embed the serving certificate of dir, the overlays generate their secrets
+		if d == dir {
+			keyData, err := util.EncodePrivateKeyPEM(key)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			r.serving, r.cert, r.key = serving, util.EncodeCertPEM(cert), keyData
+		}
+	}
+	if dryRun {
+		for _, d := range servingDirs {
+			klog.Infof("Would update %s", filepath.Join(d, servingName+".crt"))
+		}
+		updateManifests(r)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, previousCAName+".crt"), oldCAData, 0644); err != nil {
+		klog.Fatal(err)
+	}
+	if err := util.WriteCertAndKey(dir, caName, caCert, caKey); err != nil {
+		klog.Fatal(err)
+	}
+	for _, d := range servingDirs {
+		if err := util.WriteCertAndKey(d, servingName, issued[d], keys[d]); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	updateManifests(r)
+	klog.Infof("Rotated the certificates in %s.  Apply the updated manifests, and run "+
+		"apiserver-boot certs rotate --finalize once the apiserver serves the new certificate", dir)
+}
+
+func loadCert(dir, name string) (*x509.Certificate, error) {
+	certs, err := util.CertsFromFile(filepath.Join(dir, name+".crt"))
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+func readFile(path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return data
+}
+
+// The fields holding the certificates in the manifests and helm values written by build config,
+// the third group is their base64 encoded value.  Empty values are not in use and are kept.
+var (
+	caBundleFields = []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^([ \t]*caBundle:[ \t]*)("?)([A-Za-z0-9+/=]+)"?[ \t]*$`),
+		regexp.MustCompile(`(?m)^([ \t]*caCrt:[ \t]*)("?)([A-Za-z0-9+/=]+)"?[ \t]*$`),
+	}
+	certFields = []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^([ \t]*tls\.crt:[ \t]*)("?)([A-Za-z0-9+/=]+)"?[ \t]*$`),
+		regexp.MustCompile(`(?m)^([ \t]*tlsCrt:[ \t]*)("?)([A-Za-z0-9+/=]+)"?[ \t]*$`),
+	}
+	keyFields = []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^([ \t]*tls\.key:[ \t]*)("?)([A-Za-z0-9+/=]+)"?[ \t]*$`),
+		regexp.MustCompile(`(?m)^([ \t]*tlsKey:[ \t]*)("?)([A-Za-z0-9+/=]+)"?[ \t]*$`),
+	}
+)
+
+// rotation is a step of a rotation of the certificates in the manifests
+type rotation struct {
+	// cas are the CAs of the rotation, the caBundles trusting any of them are replaced by caBundle
+	cas []*x509.Certificate
+	// serving is the serving certificate replaced by cert, and its key by key.  The earlier serving
+	// certificates issued by the cas are replaced as well.  It is nil when finalizing a rotation,
+	// which keeps the serving certificate.
+	serving *x509.Certificate
+
+	caBundle, cert, key []byte
+}
+
+// isCA returns true if the certificate is one of the CAs of the rotation
+func (r rotation) isCA(cert *x509.Certificate) bool {
+	for _, ca := range r.cas {
+		if cert.Equal(ca) {
+			return true
+		}
+	}
+	return false
+}
+
+// isServing returns true if the certificate is a serving certificate of the rotation
+func (r rotation) isServing(cert *x509.Certificate) bool {
+	if cert.Equal(r.serving) {
+		return true
+	}
+	for _, ca := range r.cas {
+		if cert.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsCert returns true if the base64 encoded PEM value holds a matching certificate
+func holdsCert(value []byte, match func(*x509.Certificate) bool) bool {
+	data, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return false
+	}
+	certs, err := util.ParseCertsPEM(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range certs {
+		if match(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceFields replaces the values of the fields holding a matching certificate, or of all the
+// fields if match is nil
+func replaceFields(doc []byte, fields []*regexp.Regexp, value []byte, match func(*x509.Certificate) bool) ([]byte, bool) {
+	// the values keep their quoting
+	encoded := []byte("${1}${2}" + base64.StdEncoding.EncodeToString(value) + "${2}")
+	replaced := false
+	for _, f := range fields {
+		doc = f.ReplaceAllFunc(doc, func(m []byte) []byte {
+			i := f.FindSubmatchIndex(m)
+			if match != nil && !holdsCert(m[i[6]:i[7]], match) {
+				return m
+			}
+			replaced = true
+			return f.Expand(nil, encoded, m, i)
+		})
+	}
+	return doc, replaced
+}
+
+// updateManifest replaces the certificates of the rotation in the yaml documents of a manifest or
+// helm values file.  The keys are replaced in the documents whose serving certificate is replaced.
+func updateManifest(data []byte, r rotation) []byte {
+	var out []byte
+	for _, doc := range bytes.SplitAfter(data, []byte("\n---")) {
+		doc, _ = replaceFields(doc, caBundleFields, r.caBundle, r.isCA)
+		if r.serving != nil {
+			var replaced bool
+			if doc, replaced = replaceFields(doc, certFields, r.cert, r.isServing); replaced {
+				doc, _ = replaceFields(doc, keyFields, r.key, nil)
+			}
+		}
+		out = append(out, doc...)
+	}
+	return out
+}
+
+// updateManifests replaces the certificates of the rotation in the yaml files below the manifest
+// directories.  The files are listed before any of them is written, and only listed with
+// --dry-run.  It returns false with --dry-run.
+func updateManifests(r rotation) bool {
+	type update struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}
+	var updates []update
+	for _, root := range manifestDirs {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// helm renders the chart templates, only the values hold certificates
+			if info.IsDir() && info.Name() == "templates" {
+				return filepath.SkipDir
+			}
+			if ext := filepath.Ext(path); info.IsDir() || (ext != ".yaml" && ext != ".yml") {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if out := updateManifest(data, r); !bytes.Equal(out, data) {
+				updates = append(updates, update{path: path, data: out, mode: info.Mode()})
+			}
+			return nil
+		})
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+	if len(updates) == 0 {
+		klog.Warningf("No manifests with the certificates found in %v", manifestDirs)
+	}
+	for _, u := range updates {
+		if dryRun {
+			klog.Infof("Would update %s", u.path)
+		} else {
+			klog.Infof("Updating %s", u.path)
+		}
+	}
+	if dryRun {
+		return false
+	}
+	for _, u := range updates {
+		if err := ioutil.WriteFile(u.path, u.data, u.mode); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	return true
+}
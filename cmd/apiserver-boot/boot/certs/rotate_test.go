@@ -0,0 +1,439 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+// testCA creates a CA and a serving certificate for name signed by it
+func testCA(t *testing.T, name string) (*x509.Certificate, crypto.Signer, *x509.Certificate, crypto.Signer) {
+	caCert, caKey, err := util.NewCACertAndKey(util.Config{CommonName: name + "-ca", KeyAlgorithm: util.KeyAlgorithmECDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := util.NewCertAndKey(caCert, caKey, util.Config{
+		CommonName:   name,
+		AltNames:     util.AltNames{DNSNames: []string{name + ".acme-system.svc"}},
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyAlgorithm: util.KeyAlgorithmECDSA,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caCert, caKey, cert, key
+}
+
+func encodeKey(t *testing.T, key crypto.Signer) []byte {
+	data, err := util.EncodePrivateKeyPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// The manifests written by build config for the yaml, kustomize and helm formats, with the
+// documents of the user next to them.  The placeholders are replaced by the certificates.
+var (
+	apiserviceManifest = `apiVersion: apiregistration.k8s.io/v1
+kind: APIService
+metadata:
+  name: v1.innsmouth.example.com
+spec:
+  group: innsmouth.example.com
+  service:
+    name: deepone
+    namespace: acme-system
+  version: v1
+  caBundle: "{{ caBundle }}"
+---
+# a user document labeled as etcd
+apiVersion: apiregistration.k8s.io/v1
+kind: APIService
+metadata:
+  name: v1beta1.innsmouth.example.com
+  labels:
+    app: etcd
+spec:
+  group: innsmouth.example.com
+  version: v1beta1
+  caBundle: "{{ caBundle }}"
+---
+# the webhook of the user trusts another CA
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: deepone-policy
+webhooks:
+- name: policy.innsmouth.example.com
+  clientConfig:
+    caBundle: "{{ webhookCABundle }}"
+`
+	apiserverManifest = `apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: deepone
+  namespace: acme-system
+data:
+  tls.crt: {{ cert }}
+  tls.key: {{ key }}
+---
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: deepone-etcd-client
+  namespace: acme-system
+  labels:
+    app: etcd
+data:
+  ca.crt: {{ etcdCA }}
+  tls.crt: {{ etcdCert }}
+  tls.key: {{ etcdKey }}
+---
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: unused
+data:
+  tls.crt: ""
+  tls.key: ""
+`
+	kustomizationManifest = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+secretGenerator:
+- name: deepone
+  namespace: acme-system
+  type: kubernetes.io/tls
+  files:
+  - tls.crt=certificates/apiserver.crt
+  - tls.key=certificates/apiserver.key
+`
+	helmValues = `etcd:
+  certificates:
+    ca: "{{ etcdCA }}"
+    clientCrt: "{{ etcdCert }}"
+    clientKey: "{{ etcdKey }}"
+
+certificates:
+  source: "static"
+  static:
+    caCrt: "{{ caBundle }}"
+    tlsCrt: "{{ cert }}"
+    tlsKey: "{{ key }}"
+`
+	helmTemplate = `apiVersion: apiregistration.k8s.io/v1
+kind: APIService
+spec:
+  caBundle: {{ .Values.certificates.static.caCrt }}
+`
+)
+
+// rotationCerts are the certificates filling the placeholders of the manifests
+type rotationCerts struct {
+	caBundle, cert, key []byte
+}
+
+type otherCerts struct {
+	webhookCABundle, etcdCA, etcdCert, etcdKey []byte
+}
+
+func fillManifest(manifest string, c rotationCerts, o otherCerts) string {
+	return strings.NewReplacer(
+		"{{ caBundle }}", b64(c.caBundle),
+		"{{ cert }}", b64(c.cert),
+		"{{ key }}", b64(c.key),
+		"{{ webhookCABundle }}", b64(o.webhookCABundle),
+		"{{ etcdCA }}", b64(o.etcdCA),
+		"{{ etcdCert }}", b64(o.etcdCert),
+		"{{ etcdKey }}", b64(o.etcdKey),
+	).Replace(manifest)
+}
+
+func TestUpdateManifest(t *testing.T) {
+	oldCA, oldCAKey, oldCert, oldKey := testCA(t, "deepone")
+	earlierCert, earlierKey, err := util.NewCertAndKey(oldCA, oldCAKey, util.Config{
+		CommonName:   "deepone",
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyAlgorithm: util.KeyAlgorithmECDSA,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCA, _, newCert, newKey := testCA(t, "deepone")
+	webhookCA, _, _, _ := testCA(t, "webhook")
+	etcdCA, _, etcdCert, etcdKey := testCA(t, "etcd")
+	other := otherCerts{
+		webhookCABundle: util.EncodeCertPEM(webhookCA),
+		etcdCA:          util.EncodeCertPEM(etcdCA),
+		etcdCert:        util.EncodeCertPEM(etcdCert),
+		etcdKey:         encodeKey(t, etcdKey),
+	}
+
+	before := rotationCerts{
+		caBundle: util.EncodeCertPEM(oldCA),
+		cert:     util.EncodeCertPEM(oldCert),
+		key:      encodeKey(t, oldKey),
+	}
+	transitional := rotationCerts{
+		caBundle: append(util.EncodeCertPEM(newCA), util.EncodeCertPEM(oldCA)...),
+		cert:     util.EncodeCertPEM(newCert),
+		key:      encodeKey(t, newKey),
+	}
+	finalized := rotationCerts{
+		caBundle: util.EncodeCertPEM(newCA),
+		cert:     transitional.cert,
+		key:      transitional.key,
+	}
+	start := rotation{
+		cas:      []*x509.Certificate{oldCA},
+		serving:  oldCert,
+		caBundle: transitional.caBundle,
+		cert:     transitional.cert,
+		key:      transitional.key,
+	}
+	finish := rotation{cas: []*x509.Certificate{newCA, oldCA}, caBundle: finalized.caBundle}
+
+	tests := []struct {
+		name     string
+		manifest string
+		rotation rotation
+		in, want rotationCerts
+	}{
+		{name: "yaml apiservices", manifest: apiserviceManifest, rotation: start, in: before, want: transitional},
+		{name: "yaml apiserver secret", manifest: apiserverManifest, rotation: start, in: before, want: transitional},
+		{name: "kustomization", manifest: kustomizationManifest, rotation: start, in: before, want: before},
+		{name: "helm values", manifest: helmValues, rotation: start, in: before, want: transitional},
+		{name: "helm template", manifest: helmTemplate, rotation: start, in: before, want: before},
+		{name: "earlier serving certificate", manifest: apiserverManifest, rotation: start,
+			in:   rotationCerts{caBundle: before.caBundle, cert: util.EncodeCertPEM(earlierCert), key: encodeKey(t, earlierKey)},
+			want: transitional},
+		{name: "finalize apiservices", manifest: apiserviceManifest, rotation: finish, in: transitional, want: finalized},
+		{name: "finalize apiserver secret", manifest: apiserverManifest, rotation: finish, in: transitional, want: finalized},
+		{name: "finalize helm values", manifest: helmValues, rotation: finish, in: transitional, want: finalized},
+		{name: "finalize without the transitional caBundle", manifest: helmValues, rotation: finish,
+			in:   rotationCerts{caBundle: before.caBundle, cert: finalized.cert, key: finalized.key},
+			want: finalized},
+		{name: "certificates of another apiserver", manifest: apiserverManifest + "---\n" + helmValues, rotation: start,
+			in:   rotationCerts{caBundle: other.webhookCABundle, cert: other.etcdCert, key: other.etcdKey},
+			want: rotationCerts{caBundle: other.webhookCABundle, cert: other.etcdCert, key: other.etcdKey}},
+	}
+	for _, test := range tests {
+		in := fillManifest(test.manifest, test.in, other)
+		want := fillManifest(test.manifest, test.want, other)
+		if got := string(updateManifest([]byte(in), test.rotation)); got != want {
+			t.Errorf("%s: updateManifest() =\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c string, m []string, f, d bool, v time.Duration) {
+		certDir, manifestDirs, finalize, dryRun, validity = c, m, f, d, v
+	}(certDir, manifestDirs, finalize, dryRun, validity)
+	certDir = filepath.Join(dir, "config", "base", "certificates")
+	manifestDirs = []string{filepath.Join(dir, "config"), filepath.Join(dir, "charts")}
+	validity = time.Hour
+
+	caCert, caKey, cert, key := testCA(t, "deepone")
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteCertAndKey(certDir, caName, caCert, caKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteCertAndKey(certDir, servingName, cert, key); err != nil {
+		t.Fatal(err)
+	}
+	before := rotationCerts{caBundle: util.EncodeCertPEM(caCert), cert: util.EncodeCertPEM(cert), key: encodeKey(t, key)}
+	files := map[string]string{
+		filepath.Join("config", "base", "apiservice.yaml"):    fillManifest(apiserviceManifest, before, otherCerts{}),
+		filepath.Join("config", "base", "kustomization.yaml"): kustomizationManifest,
+		filepath.Join("charts", "deepone", "values.yaml"):     fillManifest(helmValues, before, otherCerts{}),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	unchanged := func(step string) {
+		for name, content := range files {
+			if read(name) != content {
+				t.Errorf("%s changed %s", step, name)
+			}
+		}
+	}
+
+	dryRun = true
+	RunCertsRotate(nil, nil)
+	unchanged("rotate --dry-run")
+	if _, err := os.Stat(filepath.Join(certDir, previousCAName+".crt")); !os.IsNotExist(err) {
+		t.Fatalf("rotate --dry-run started a rotation")
+	}
+	if read(filepath.Join("config", "base", "certificates", caName+".crt")) != string(before.caBundle) {
+		t.Fatalf("rotate --dry-run changed the CA")
+	}
+
+	dryRun = false
+	RunCertsRotate(nil, nil)
+	transitional := rotationCerts{
+		caBundle: append([]byte(read(filepath.Join("config", "base", "certificates", caName+".crt"))), before.caBundle...),
+		cert:     []byte(read(filepath.Join("config", "base", "certificates", servingName+".crt"))),
+		key:      []byte(read(filepath.Join("config", "base", "certificates", servingName+".key"))),
+	}
+	if string(transitional.cert) == string(before.cert) {
+		t.Fatalf("rotate kept the serving certificate")
+	}
+	for name, manifest := range map[string]string{
+		filepath.Join("config", "base", "apiservice.yaml"): apiserviceManifest,
+		filepath.Join("charts", "deepone", "values.yaml"):  helmValues,
+	} {
+		if got, want := read(name), fillManifest(manifest, transitional, otherCerts{}); got != want {
+			t.Errorf("rotate wrote %s:\n%s\nwant\n%s", name, got, want)
+		}
+	}
+	if read(filepath.Join("config", "base", "kustomization.yaml")) != kustomizationManifest {
+		t.Errorf("rotate changed the kustomization.yaml")
+	}
+
+	finalize = true
+	RunCertsRotate(nil, nil)
+	finalized := rotationCerts{
+		caBundle: transitional.caBundle[:len(transitional.caBundle)-len(before.caBundle)],
+		cert:     transitional.cert,
+		key:      transitional.key,
+	}
+	for name, manifest := range map[string]string{
+		filepath.Join("config", "base", "apiservice.yaml"): apiserviceManifest,
+		filepath.Join("charts", "deepone", "values.yaml"):  helmValues,
+	} {
+		if got, want := read(name), fillManifest(manifest, finalized, otherCerts{}); got != want {
+			t.Errorf("rotate --finalize wrote %s:\n%s\nwant\n%s", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(certDir, previousCAName+".crt")); !os.IsNotExist(err) {
+		t.Errorf("rotate --finalize kept the previous CA")
+	}
+}
+
+func TestRotateKustomizeOverlays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c string, m []string, f, d bool, v time.Duration) {
+		certDir, manifestDirs, finalize, dryRun, validity = c, m, f, d, v
+	}(certDir, manifestDirs, finalize, dryRun, validity)
+	certDir = filepath.Join(dir, "config", "base", "certificates")
+	manifestDirs = []string{filepath.Join(dir, "config")}
+	validity = time.Hour
+
+	// the base only has the CA, the overlays have serving certificates for their namespaces
+	caCert, caKey, _, _ := testCA(t, "deepone")
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteCertAndKey(certDir, caName, caCert, caKey); err != nil {
+		t.Fatal(err)
+	}
+	overlays := map[string]string{"dev": "deepone.dev.svc", "prod": "deepone.prod.svc"}
+	before := map[string][]byte{}
+	for overlay, name := range overlays {
+		cert, key, err := util.NewCertAndKey(caCert, caKey, util.Config{
+			CommonName:   name,
+			AltNames:     util.AltNames{DNSNames: []string{name}},
+			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			KeyAlgorithm: util.KeyAlgorithmECDSA,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := filepath.Join(dir, "config", "overlays", overlay, "namespaced", "certificates")
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := util.WriteCertAndKey(d, servingName, cert, key); err != nil {
+			t.Fatal(err)
+		}
+		before[overlay] = util.EncodeCertPEM(cert)
+	}
+	manifest := filepath.Join(dir, "config", "base", "apiservice.yaml")
+	if err := ioutil.WriteFile(manifest, []byte("caBundle: "+b64(util.EncodeCertPEM(caCert))+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	RunCertsRotate(nil, nil)
+	ca, err := loadCert(certDir, caName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Equal(caCert) {
+		t.Fatalf("rotate kept the CA")
+	}
+	for overlay, name := range overlays {
+		d := filepath.Join(dir, "config", "overlays", overlay, "namespaced", "certificates")
+		cert, err := loadCert(d, servingName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(util.EncodeCertPEM(cert)) == string(before[overlay]) {
+			t.Errorf("rotate kept the serving certificate of %s", overlay)
+			continue
+		}
+		if err := cert.CheckSignatureFrom(ca); err != nil {
+			t.Errorf("the serving certificate of %s is not signed by the new CA: %v", overlay, err)
+		}
+		if err := cert.VerifyHostname(name); err != nil {
+			t.Errorf("the serving certificate of %s lost its name: %v", overlay, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(certDir, servingName+".crt")); !os.IsNotExist(err) {
+		t.Errorf("rotate wrote a serving certificate to the base")
+	}
+}
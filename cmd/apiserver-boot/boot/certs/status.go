@@ -0,0 +1,161 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var warnWithin time.Duration
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the expiry and names of the certificates",
+	Long: `Report the subject, issuer, key, validity and subject alternative names of each certificate in
+the certificates directory, followed by those of the kustomize overlays.  Fails if any certificate
+has expired.`,
+	Example: `# Report the certificates generated by build config
+apiserver-boot certs status
+
+# Also flag the certificates expiring within 90 days
+apiserver-boot certs status --warn-within 2160h`,
+	Run: RunCertsStatus,
+}
+
+func AddCertsStatus(cmd *cobra.Command) {
+	statusCmd.Flags().DurationVar(&warnWithin, "warn-within", 30*24*time.Hour,
+		"flag the certificates expiring within this duration")
+	cmd.AddCommand(statusCmd)
+}
+
+func RunCertsStatus(cmd *cobra.Command, args []string) {
+	dir := getCertDir()
+	var files []string
+	// the certificates of the kustomize overlays follow the base
+	for _, d := range append([]string{dir}, util.OverlayCertificatesDirs(dir)...) {
+		f, err := filepath.Glob(filepath.Join(d, "*.crt"))
+		if err != nil {
+			klog.Fatal(err)
+		}
+		sort.Strings(f)
+		files = append(files, f...)
+	}
+	if len(files) == 0 {
+		klog.Fatalf("no certificates found in %s", dir)
+	}
+
+	var ca []*x509.Certificate
+	if c, err := util.CertsFromFile(filepath.Join(dir, caName+".crt")); err == nil {
+		ca = c
+	}
+	if c, err := util.CertsFromFile(filepath.Join(dir, previousCAName+".crt")); err == nil {
+		ca = append(ca, c...)
+	}
+
+	now := time.Now()
+	expired := false
+	for _, f := range files {
+		certs, err := util.CertsFromFile(f)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		for _, c := range certs {
+			state := "valid"
+			switch {
+			case now.After(c.NotAfter):
+				state = "EXPIRED"
+				expired = true
+			case now.Before(c.NotBefore):
+				state = "NOT YET VALID"
+			case now.Add(warnWithin).After(c.NotAfter):
+				state = "EXPIRES SOON"
+			}
+			algorithm, size := util.KeyAlgorithmOf(c.PublicKey)
+			key := algorithm
+			if size > 0 {
+				key = fmt.Sprintf("%s %d", algorithm, size)
+			}
+
+			name := filepath.Base(f)
+			if filepath.Dir(f) != filepath.Clean(dir) {
+				name = f
+			}
+			fmt.Printf("%s\n", name)
+			fmt.Printf("  status:    %s\n", state)
+			fmt.Printf("  subject:   %s\n", c.Subject.String())
+			fmt.Printf("  issuer:    %s%s\n", c.Issuer.String(), signedBy(c, ca))
+			fmt.Printf("  key:       %s\n", key)
+			fmt.Printf("  ca:        %t\n", c.IsCA)
+			fmt.Printf("  valid:     %s to %s (%s)\n",
+				c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339), remaining(c, now))
+			if sans := subjectAltNames(c); len(sans) > 0 {
+				fmt.Printf("  SANs:      %s\n", strings.Join(sans, ", "))
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, previousCAName+".crt")); err == nil {
+		fmt.Printf("\nA CA rotation is in progress, run apiserver-boot certs rotate --finalize once the " +
+			"apiserver serves the new certificate\n")
+	}
+	if expired {
+		os.Exit(1)
+	}
+}
+
+// signedBy notes whether c is signed by one of the CAs
+func signedBy(c *x509.Certificate, ca []*x509.Certificate) string {
+	if len(ca) == 0 || (c.IsCA && c.CheckSignatureFrom(c) == nil) {
+		return ""
+	}
+	for _, a := range ca {
+		if c.CheckSignatureFrom(a) == nil {
+			return ""
+		}
+	}
+	return " (not signed by the CA)"
+}
+
+func remaining(c *x509.Certificate, now time.Time) string {
+	d := c.NotAfter.Sub(now)
+	if d < 0 {
+		return fmt.Sprintf("expired %d days ago", int(-d.Hours()/24))
+	}
+	return fmt.Sprintf("%d days left", int(d.Hours()/24))
+}
+
+func subjectAltNames(c *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package create
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var deprecatesVersion string
+var deprecatedIn string
+var removedIn string
+
+// removalReleases is the number of minor releases a deprecated version is served for unless
+// --removed-in is set, following the Kubernetes deprecation policy for beta APIs.
+const removalReleases = 3
+
+func validateDeprecationFlags() {
+	if len(deprecatesVersion) == 0 {
+		if len(removedIn) > 0 || len(deprecatedIn) > 0 {
+			klog.Fatalf("--removed-in and --deprecated-in require --deprecates")
+		}
+		return
+	}
+	if deprecatesVersion == versionName {
+		klog.Fatalf("--deprecates must name another version of the group than --version")
+	}
+	if _, err := os.Stat(filepath.Join("pkg", "apis", groupName, deprecatesVersion, "doc.go")); err != nil {
+		klog.Fatalf("API group version %s/%s to deprecate doesn't exist", groupName, deprecatesVersion)
+	}
+	deprecated, removed, err := deprecationReleases(deprecatedIn, removedIn)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	deprecatedIn, removedIn = deprecated.String(), removed.String()
+}
+
+// deprecationReleases returns the releases of the --deprecated-in and --removed-in flags.  The
+// version is deprecated in the release before its removal, or removed removalReleases minor
+// releases after its deprecation, unless told otherwise.
+func deprecationReleases(deprecatedIn, removedIn string) (util.Release, util.Release, error) {
+	var deprecated, removed util.Release
+	var err error
+	if len(deprecatedIn) == 0 && len(removedIn) == 0 {
+		return deprecated, removed, fmt.Errorf("Must specify --removed-in or --deprecated-in with --deprecates")
+	}
+	if len(deprecatedIn) > 0 {
+		if deprecated, err = util.ParseRelease(deprecatedIn); err != nil {
+			return deprecated, removed, fmt.Errorf("--deprecated-in: %v", err)
+		}
+	}
+	if len(removedIn) == 0 {
+		removed = util.Release{Major: deprecated.Major, Minor: deprecated.Minor + removalReleases}
+		return deprecated, removed, nil
+	}
+	if removed, err = util.ParseRelease(removedIn); err != nil {
+		return deprecated, removed, fmt.Errorf("--removed-in: %v", err)
+	}
+	if len(deprecatedIn) == 0 {
+		if removed.Minor == 0 {
+			return deprecated, removed, fmt.Errorf("Must specify --deprecated-in with --removed-in %s, the last "+
+				"release of %d.x before it is not known", removed, removed.Major-1)
+		}
+		deprecated = util.Release{Major: removed.Major, Minor: removed.Minor - 1}
+	}
+	if removed.Before(deprecated) {
+		return deprecated, removed, fmt.Errorf("--removed-in %s must not be before --deprecated-in %s", removed, deprecated)
+	}
+	return deprecated, removed, nil
+}
+
+// deprecateVersion records the deprecation in the doc.go of the superseded version and
+// implements the APILifecycle interfaces on its kinds, which makes the apiserver add a
+// deprecation warning header to every request for them.
+func deprecateVersion(boilerplate string) {
+	deprecated, _ := util.ParseRelease(deprecatedIn)
+	removed, _ := util.ParseRelease(removedIn)
+
+	docFile := filepath.Join("pkg", "apis", groupName, deprecatesVersion, "doc.go")
+	content, err := ioutil.ReadFile(docFile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if strings.Contains(string(content), util.DeprecatedInTag) {
+		klog.Fatalf("API group version %s/%s is already deprecated", groupName, deprecatesVersion)
+	}
+	tags := fmt.Sprintf("// %s=%s\n// %s=%s\n// %s=%s\n",
+		util.DeprecatedInTag, deprecated,
+		util.RemovedInTag, removed,
+		util.ReplacementTag, versionName)
+	result := strings.Replace(string(content), "\npackage ", "\n"+tags+"package ", 1)
+	if err := ioutil.WriteFile(docFile, []byte(result), 0644); err != nil {
+		klog.Fatalf("failed updating %s: %v", docFile, err)
+	}
+
+	writeLifecycle(boilerplate, deprecatesVersion)
+	klog.Infof("Deprecated %s/%s in %s, it will be removed in %s", groupName, deprecatesVersion, deprecated, removed)
+}
+
+// writeLifecycle implements the APILifecycle interfaces on the kinds of a deprecated version of
+// the group from the tags of its doc.go.  create resource calls it too, so the kinds added to the
+// version after its deprecation carry the deprecation as well.
+func writeLifecycle(boilerplate, version string) {
+	l, deprecated, err := util.GetVersionLifecycle(groupName, version)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if !deprecated {
+		return
+	}
+	kinds, err := util.GetKinds(groupName, version)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if len(kinds) == 0 {
+		return
+	}
+	path := filepath.Join("pkg", "apis", groupName, version, "lifecycle.go")
+	util.Overwrite(path, "lifecycle-template", lifecycleTemplate, lifecycleTemplateArgs{
+		BoilerPlate:        boilerplate,
+		Domain:             groupDomain,
+		Group:              groupName,
+		Version:            version,
+		ReplacementVersion: l.Replacement,
+		Kinds:              kinds,
+		DeprecatedIn:       l.DeprecatedIn,
+		RemovedIn:          l.RemovedIn,
+	})
+}
+
+type lifecycleTemplateArgs struct {
+	BoilerPlate        string
+	Domain             string
+	Group              string
+	Version            string
+	ReplacementVersion string
+	Kinds              []string
+	DeprecatedIn       util.Release
+	RemovedIn          util.Release
+}
+
+var lifecycleTemplate = `
+{{.BoilerPlate}}
+
+package {{.Version}}
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+{{ range $kind := .Kinds }}
+// APILifecycleDeprecated returns the release {{ $kind }} was deprecated in.  The apiserver adds a
+// deprecation warning header to the responses for deprecated resources.
+func (in *{{ $kind }}) APILifecycleDeprecated() (major, minor int) {
+	return {{ $.DeprecatedIn.Major }}, {{ $.DeprecatedIn.Minor }}
+}
+
+// APILifecycleRemoved returns the release {{ $kind }} is removed in.
+func (in *{{ $kind }}) APILifecycleRemoved() (major, minor int) {
+	return {{ $.RemovedIn.Major }}, {{ $.RemovedIn.Minor }}
+}
+
+// APILifecycleReplacement returns the kind to use instead of {{ $kind }}.
+func (in *{{ $kind }}) APILifecycleReplacement() schema.GroupVersionKind {
+	return schema.GroupVersionKind{
+		Group:   "{{ $.Group }}.{{ $.Domain }}",
+		Version: "{{ $.ReplacementVersion }}",
+		Kind:    "{{ $kind }}",
+	}
+}
+{{ end -}}
+`
@@ -0,0 +1,56 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package create
+
+import (
+	"testing"
+)
+
+func TestDeprecationReleases(t *testing.T) {
+	tests := []struct {
+		deprecatedIn string
+		removedIn    string
+		// deprecated and removed are the releases of the flags
+		deprecated string
+		removed    string
+		err        bool
+	}{
+		{deprecatedIn: "1.27", removedIn: "1.30", deprecated: "1.27", removed: "1.30"},
+		{deprecatedIn: "1.27", deprecated: "1.27", removed: "1.30"},
+		{deprecatedIn: "v1.29", deprecated: "1.29", removed: "1.32"},
+		{removedIn: "1.30", deprecated: "1.29", removed: "1.30"},
+		{deprecatedIn: "1.30", removedIn: "1.30", deprecated: "1.30", removed: "1.30"},
+		{deprecatedIn: "1.30", removedIn: "2.0", deprecated: "1.30", removed: "2.0"},
+		{removedIn: "2.0", err: true},
+		{err: true},
+		{deprecatedIn: "1.30", removedIn: "1.29", err: true},
+		{deprecatedIn: "1.x", err: true},
+		{removedIn: "130", err: true},
+	}
+	for _, test := range tests {
+		deprecated, removed, err := deprecationReleases(test.deprecatedIn, test.removedIn)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("deprecationReleases(%q, %q) succeeded", test.deprecatedIn, test.removedIn)
+		case !test.err && err != nil:
+			t.Errorf("deprecationReleases(%q, %q): %v", test.deprecatedIn, test.removedIn, err)
+		case !test.err && (deprecated.String() != test.deprecated || removed.String() != test.removed):
+			t.Errorf("deprecationReleases(%q, %q) = %s, %s want %s, %s", test.deprecatedIn, test.removedIn,
+				deprecated, removed, test.deprecated, test.removed)
+		}
+	}
+}
@@ -17,11 +17,13 @@ limitations under the License.
 package create
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 )
@@ -34,10 +36,13 @@ var createGroupCmd = &cobra.Command{
 }
 
 var groupName string
+var groupDomain string
+var internalVersion bool
 var ignoreGroupExists bool = false
 
 func AddCreateGroup(cmd *cobra.Command) {
 	createGroupCmd.Flags().StringVar(&groupName, "group", "", "name of the API group to create")
+	registerGroupFlags(createGroupCmd)
 
 	cmd.AddCommand(createGroupCmd)
 	createGroupCmd.AddCommand(createVersionCmd)
@@ -56,10 +61,46 @@ func RunCreateGroup(cmd *cobra.Command, args []string) {
 	if strings.ToLower(groupName) != groupName {
 		klog.Fatalf("--group must be lowercase was (%s)", groupName)
 	}
+	resolveGroupDomain()
 
 	createGroup(util.GetCopyright(copyright))
 }
 
+func registerGroupFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&groupDomain, "domain", "",
+		"if set, the API group lives under this domain instead of the project domain from pkg/apis/doc.go")
+	cmd.Flags().BoolVar(&internalVersion, "internal-version", false,
+		"if set, a new API group gets an internal (hub) version and an install package, every version converts through the internal version")
+}
+
+// hasInternalVersion returns true if the API group was created with --internal-version.
+func hasInternalVersion() bool {
+	_, err := os.Stat(filepath.Join("pkg", "apis", groupName, "install", "install.go"))
+	return err == nil
+}
+
+// resolveGroupDomain sets the domain the API group lives under.  An existing group keeps the
+// domain recorded in its doc.go, --domain only applies to new groups.
+func resolveGroupDomain() {
+	if len(groupDomain) > 0 {
+		if errs := utilvalidation.IsDNS1123Subdomain(groupDomain); len(errs) > 0 {
+			klog.Fatalf("--domain %q has bad format: %s", groupDomain, strings.Join(errs, ","))
+		}
+	}
+	if _, err := os.Stat(filepath.Join("pkg", "apis", groupName, "doc.go")); err == nil {
+		existing := util.GetGroupDomain(groupName)
+		if len(groupDomain) > 0 && groupDomain != existing {
+			klog.Fatalf("API group %s already exists with domain %s, can't change it to %s",
+				groupName, existing, groupDomain)
+		}
+		groupDomain = existing
+		return
+	}
+	if len(groupDomain) == 0 {
+		groupDomain = util.Domain
+	}
+}
+
 func createGroup(boilerplate string) {
 	dir, err := os.Getwd()
 	if err != nil {
@@ -68,7 +109,7 @@ func createGroup(boilerplate string) {
 
 	a := groupTemplateArgs{
 		boilerplate,
-		util.Domain,
+		groupDomain,
 		groupName,
 	}
 
@@ -78,6 +119,54 @@ func createGroup(boilerplate string) {
 	if !created && !ignoreGroupExists {
 		klog.Fatalf("API group %s already exists.", groupName)
 	}
+
+	if internalVersion {
+		if !created && !hasInternalVersion() {
+			klog.Fatalf("API group %s already exists without an internal version, "+
+				"--internal-version can only be used when creating a group.", groupName)
+		}
+		if created {
+			createInternalVersion(boilerplate)
+		}
+	}
+}
+
+// createInternalVersion writes the register.go of the internal version and the install package
+// which adds the internal and all versioned types to a scheme.
+func createInternalVersion(boilerplate string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	a := installTemplateArgs{
+		boilerplate,
+		groupDomain,
+		groupName,
+		util.GetRepo(),
+	}
+
+	path := filepath.Join(dir, "pkg", "apis", groupName, "register.go")
+	util.WriteIfNotFound(path, "internal-register-template", internalRegisterTemplate, a)
+
+	path = filepath.Join(dir, "pkg", "apis", groupName, "install", "install.go")
+	util.WriteIfNotFound(path, "install-template", installTemplate, a)
+
+	// register the install function with the apiserver
+	const (
+		scaffoldImports  = "// +kubebuilder:scaffold:resource-imports"
+		scaffoldRegister = "// +kubebuilder:scaffold:resource-register"
+	)
+	mainFile := filepath.Join("cmd", "apiserver", "main.go")
+	newImport := fmt.Sprintf(`%sinstall "%s/pkg/apis/%s/install"`, groupName, util.GetRepo(), groupName)
+	if err := appendMixin(mainFile, scaffoldImports, newImport); err != nil {
+		klog.Fatal(err)
+	}
+	newRegister := fmt.Sprintf("WithAdditionalSchemeInstallers(%sinstall.Install).", groupName)
+	if err := appendMixin(mainFile, scaffoldRegister, newRegister); err != nil {
+		klog.Fatal(err)
+	}
+	format(mainFile)
 }
 
 type groupTemplateArgs struct {
@@ -98,8 +187,64 @@ package {{.Name}}
 
 `
 
-var installTemplate = `
+type installTemplateArgs struct {
+	BoilerPlate string
+	Domain      string
+	Name        string
+	Repo        string
+}
+
+var internalRegisterTemplate = `
 {{.BoilerPlate}}
 
 package {{.Name}}
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the group name used in this package
+const GroupName = "{{.Name}}.{{.Domain}}"
+
+// SchemeGroupVersion is the internal group version used to register these objects
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: runtime.APIVersionInternal}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		// +kubebuilder:scaffold:internal-types
+	)
+	return nil
+}
+`
+
+var installTemplate = `
+{{.BoilerPlate}}
+
+package install
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"{{.Repo}}/pkg/apis/{{.Name}}"
+	// +kubebuilder:scaffold:install-imports
+)
+
+// Install registers the internal and versioned types of the API group into the scheme,
+// including the conversions and defaulters generated for the versioned types.
+func Install(scheme *runtime.Scheme) error {
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		{{.Name}}.AddToScheme,
+		// +kubebuilder:scaffold:install-versions
+	} {
+		if err := addToScheme(scheme); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 `
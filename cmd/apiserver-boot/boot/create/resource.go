@@ -55,6 +55,7 @@ func AddCreateResource(cmd *cobra.Command) {
 
 	createResourceCmd.Flags().StringVar(&shortName, "short-name", "", "if set, add a short name for the resource. It must be all lowercase.")
 	createResourceCmd.Flags().BoolVar(&nonNamespacedKind, "non-namespaced", false, "if set, the API kind will be non namespaced")
+	registerGroupFlags(createResourceCmd)
 
 	createResourceCmd.Flags().BoolVar(&skipGenerateResource, "skip-resource", false, "if set, the resources will not be generated")
 	createResourceCmd.Flags().BoolVar(&skipGenerateController, "skip-controller", false, "if set, the controller will not be generated")
@@ -102,7 +103,7 @@ func createResource(boilerplate string) {
 	//
 	a := resourceTemplateArgs{
 		boilerplate,
-		util.Domain,
+		groupDomain,
 		groupName,
 		versionName,
 		kindName,
@@ -132,6 +133,23 @@ func createResource(boilerplate string) {
 			}
 		}()
 
+		if hasInternalVersion() {
+			// creates the internal (hub) type the versioned type converts through
+			typesFileName := fmt.Sprintf("%s_types.go", strings.ToLower(kindName))
+			path := filepath.Join(dir, "pkg", "apis", groupName, typesFileName)
+			if util.WriteIfNotFound(path, "internal-resource-template", internalResourceTemplate, a) {
+				const (
+					scaffoldInternalTypes = "// +kubebuilder:scaffold:internal-types"
+				)
+				registerFile := filepath.Join("pkg", "apis", groupName, "register.go")
+				newTypes := fmt.Sprintf("&%s{}, &%sList{},", kindName, kindName)
+				if err := appendMixin(registerFile, scaffoldInternalTypes, newTypes); err != nil {
+					klog.Fatal(err)
+				}
+				format(registerFile)
+			}
+		}
+
 		func() {
 			// re-render cmd/apiserver/main.go
 			const (
@@ -157,7 +175,7 @@ func createResource(boilerplate string) {
 				scaffoldInstall = "// +kubebuilder:scaffold:install"
 			)
 			registerFile := filepath.Join("pkg", "apis", groupName, versionName, "register.go")
-			fullGroupName := groupName + "." + util.Domain
+			fullGroupName := groupName + "." + groupDomain
 			newRegister := fmt.Sprintf(`
 	scheme.AddKnownTypes(schema.GroupVersion{
 		Group:   "%s",
@@ -169,6 +187,9 @@ func createResource(boilerplate string) {
 			}
 			format(registerFile)
 		}()
+
+		// the kinds of a deprecated version implement the APILifecycle interfaces
+		writeLifecycle(boilerplate, versionName)
 	}
 
 	if !skipGenerateController {
@@ -185,7 +206,7 @@ func createResource(boilerplate string) {
 		scaffolder := scaffolds.NewAPIScaffolder(
 			&config.Config{
 				MultiGroup: true,
-				Domain:     util.Domain,
+				Domain:     groupDomain,
 				Repo:       util.GetRepo(),
 				Version:    config.Version3Alpha,
 			},
@@ -334,3 +355,46 @@ func (in {{.Kind}}Status) CopyTo(parent resource.ObjectWithStatusSubResource) {
 }
 {{- end }}
 `
+
+var internalResourceTemplate = `
+{{.BoilerPlate}}
+
+package {{.Group}}
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// {{.Kind}} is the internal version of {{.Kind}}, every version of the resource converts through it.
+type {{.Kind}} struct {
+	metav1.TypeMeta
+	metav1.ObjectMeta
+
+	Spec   {{.Kind}}Spec
+{{- if .WithStatusSubResource }}
+	Status {{.Kind}}Status
+{{- end }}
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// {{.Kind}}List is the internal version of {{.Kind}}List
+type {{.Kind}}List struct {
+	metav1.TypeMeta
+	metav1.ListMeta
+
+	Items []{{.Kind}}
+}
+
+// {{.Kind}}Spec defines the desired state of {{.Kind}}
+type {{.Kind}}Spec struct {
+}
+{{- if .WithStatusSubResource }}
+
+// {{.Kind}}Status defines the observed state of {{.Kind}}
+type {{.Kind}}Status struct {
+}
+{{- end }}
+`
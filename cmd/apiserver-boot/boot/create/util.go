@@ -70,6 +70,7 @@ func ValidateResourceFlags() {
 	if !kindMatch.MatchString(kindName) {
 		klog.Fatalf("--kind must match regex ^[A-Z]+[A-Za-z0-9]*$ but was (%s)", kindName)
 	}
+	resolveGroupDomain()
 }
 
 func RegisterResourceFlags(cmd *cobra.Command) {
@@ -17,6 +17,7 @@ limitations under the License.
 package create
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -34,12 +35,24 @@ var createVersionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Creates an API group and version",
 	Long:  `Creates an API group and version.  Will not recreate group if already exists.`,
-	Run:   RunCreateVersion,
+	Example: `# Create the v2 version of the insect group, deprecating v1 which will be removed in 1.30
+apiserver-boot create version --group insect --version v2 --deprecates v1 --removed-in 1.30
+
+# Deprecate v1 in 1.27, it will be removed 3 releases later in 1.30
+apiserver-boot create version --group insect --version v2 --deprecates v1 --deprecated-in 1.27`,
+	Run: RunCreateVersion,
 }
 
 func AddCreateVersion(cmd *cobra.Command) {
 	createVersionCmd.Flags().StringVar(&groupName, "group", "", "name of the API group to create")
 	createVersionCmd.Flags().StringVar(&versionName, "version", "", "name of the API version to create")
+	registerGroupFlags(createVersionCmd)
+	createVersionCmd.Flags().StringVar(&deprecatesVersion, "deprecates", "",
+		"existing version of the API group superseded by the new version, its resources return deprecation warnings")
+	createVersionCmd.Flags().StringVar(&removedIn, "removed-in", "",
+		"release the deprecated version will be removed in, e.g. 1.30, defaults to 3 minor releases after --deprecated-in")
+	createVersionCmd.Flags().StringVar(&deprecatedIn, "deprecated-in", "",
+		"release the version is deprecated in, defaults to the release before --removed-in, required if --removed-in is a major release, e.g. 2.0, or isn't set")
 
 	cmd.AddCommand(createVersionCmd)
 	createVersionCmd.AddCommand(createResourceCmd)
@@ -67,12 +80,17 @@ func RunCreateVersion(cmd *cobra.Command, args []string) {
 			"--version has bad format. must match ^v\\d+(alpha\\d+|beta\\d+)*$.  "+
 				"e.g. v1alpha1,v1beta1,v1 was(%s)", versionName)
 	}
+	resolveGroupDomain()
+	validateDeprecationFlags()
 
 	cr := util.GetCopyright(copyright)
 
 	ignoreGroupExists = true
 	createGroup(cr)
 	createVersion(cr)
+	if len(deprecatesVersion) > 0 {
+		deprecateVersion(cr)
+	}
 }
 
 func createVersion(boilerplate string) {
@@ -81,19 +99,24 @@ func createVersion(boilerplate string) {
 		klog.Fatalf("%v", err)
 		os.Exit(-1)
 	}
+	hub := hasInternalVersion()
 	path := filepath.Join(dir, "pkg", "apis", groupName, versionName, "doc.go")
 	created := util.WriteIfNotFound(path, "version-template", versionTemplate, versionTemplateArgs{
 		boilerplate,
-		util.Domain,
+		groupDomain,
 		groupName,
 		versionName,
 		util.GetRepo(),
 	})
 
+	t := registerTemplate
+	if hub {
+		t = hubRegisterTemplate
+	}
 	path = filepath.Join(dir, "pkg", "apis", groupName, versionName, "register.go")
-	created = util.WriteIfNotFound(path, "register-template", registerTemplate, registerTemplateArgs{
+	created = util.WriteIfNotFound(path, "register-template", t, registerTemplateArgs{
 		boilerplate,
-		util.Domain,
+		groupDomain,
 		groupName,
 		versionName,
 	})
@@ -101,6 +124,33 @@ func createVersion(boilerplate string) {
 	if !created && !ignoreVersionExists {
 		klog.Fatalf("API group version %s/%s already exists.", groupName, versionName)
 	}
+
+	if created && hub {
+		// RegisterDefaults is generated by defaulter-gen, which replaces the stub
+		path = filepath.Join(dir, "pkg", "apis", groupName, versionName, "zz_generated.defaults.go")
+		util.WriteIfNotFound(path, "defaults-stub-template", defaultsStubTemplate, registerTemplateArgs{
+			boilerplate,
+			groupDomain,
+			groupName,
+			versionName,
+		})
+		klog.Infof("Run make generate to generate the conversions and defaults of %s/%s", groupName, versionName)
+
+		// add the version to the install package of the group
+		const (
+			scaffoldImports  = "// +kubebuilder:scaffold:install-imports"
+			scaffoldVersions = "// +kubebuilder:scaffold:install-versions"
+		)
+		installFile := filepath.Join("pkg", "apis", groupName, "install", "install.go")
+		newImport := fmt.Sprintf(`%s "%s/pkg/apis/%s/%s"`, versionName, util.GetRepo(), groupName, versionName)
+		if err := appendMixin(installFile, scaffoldImports, newImport); err != nil {
+			klog.Fatal(err)
+		}
+		if err := appendMixin(installFile, scaffoldVersions, versionName+".AddToScheme,"); err != nil {
+			klog.Fatal(err)
+		}
+		format(installFile)
+	}
 }
 
 type versionTemplateArgs struct {
@@ -155,3 +205,56 @@ var AddToScheme = func(scheme *runtime.Scheme) error {
 	return nil
 }
 `
+
+// hubRegisterTemplate registers the versioned types of a group with an internal version.  The
+// conversions generated by conversion-gen register themselves through localSchemeBuilder.
+var hubRegisterTemplate = `
+{{.BoilerPlate}}
+
+package {{.Version}}
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SchemeGroupVersion is group version used to register these objects
+var SchemeGroupVersion = schema.GroupVersion{Group: "{{.Group}}.{{.Domain}}", Version: "{{.Version}}"}
+
+var (
+	SchemeBuilder      runtime.SchemeBuilder
+	localSchemeBuilder = &SchemeBuilder
+	AddToScheme        = localSchemeBuilder.AddToScheme
+)
+
+func init() {
+	localSchemeBuilder.Register(addKnownTypes, RegisterDefaults)
+}
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	// +kubebuilder:scaffold:install
+	return nil
+}
+`
+
+// defaultsStubTemplate lets a new version of a group with an internal version compile until
+// defaulter-gen generates its defaults.
+var defaultsStubTemplate = `// +build !ignore_autogenerated
+
+{{.BoilerPlate}}
+
+// Code generated by apiserver-boot, replaced by defaulter-gen. DO NOT EDIT.
+
+package {{.Version}}
+
+import (
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// RegisterDefaults adds defaulters functions to the given scheme.
+func RegisterDefaults(scheme *runtime.Scheme) error {
+	return nil
+}
+`
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package init_repo
+
+import (
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+// existingProject records which parts of a project were found before running init repo --adopt.
+type existingProject struct {
+	GoMod    bool
+	Project  *util.Project
+	MainGo   bool
+	Config   bool
+	Makefile bool
+}
+
+func detectExistingProject() existingProject {
+	e := existingProject{
+		GoMod:    exists("go.mod"),
+		MainGo:   exists("main.go"),
+		Config:   exists("config"),
+		Makefile: exists("Makefile"),
+	}
+	if exists(util.ProjectFile) {
+		p, err := util.LoadProject(util.ProjectFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		e.Project = p
+	}
+	return e
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// adoptRepo adds the apiserver scaffolding to an existing go or kubebuilder project.  Only
+// cmd/apiserver, pkg/apis/doc.go and the copyright boilerplate (if missing) are written, everything
+// else found in the project is left untouched and reported.
+func adoptRepo() {
+	e := detectExistingProject()
+
+	switch {
+	case len(moduleName) > 0:
+		util.SetRepo(moduleName)
+	case e.GoMod:
+		if err := util.LoadRepoFromGoMod(); err != nil {
+			klog.Fatal(err)
+		}
+	case e.Project != nil && len(e.Project.Repo) > 0:
+		util.SetRepo(e.Project.Repo)
+	default:
+		if err := util.LoadRepoFromGoPathOrGoMod(); err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	if len(domain) == 0 && e.Project != nil {
+		domain = e.Project.Domain
+	}
+	if len(domain) == 0 {
+		klog.Fatal("Must specify --domain, the PROJECT file doesn't contain a domain")
+	}
+	util.Domain = domain
+
+	var created, skipped []string
+	report := func(ok bool, path, reason string) {
+		if ok {
+			created = append(created, path)
+		} else {
+			skipped = append(skipped, path+": "+reason)
+		}
+	}
+
+	hasBoilerplate := exists(copyright)
+	if !hasBoilerplate {
+		createBoilerplate()
+	}
+	report(!hasBoilerplate, copyright, "already exists")
+	cr := util.GetCopyright(copyright)
+
+	report(createApiserver(cr), filepath.Join("cmd", "apiserver", "main.go"), "already exists")
+	report(createAPIs(cr), filepath.Join("pkg", "apis", "doc.go"), "already exists")
+
+	if e.Project == nil {
+		report(createKubeBuilderProjectFile(), util.ProjectFile, "already exists")
+	} else {
+		report(false, util.ProjectFile, "existing kubebuilder project file kept")
+	}
+	if e.GoMod {
+		report(false, "go.mod", "existing module kept, add sigs.k8s.io/apiserver-runtime to it with go get")
+	} else {
+		createGoMod(false)
+		report(true, "go.mod", "")
+	}
+	if e.MainGo {
+		report(false, "main.go", "existing controller-manager kept")
+	}
+	if e.Config {
+		report(false, "config", "existing kustomize config kept")
+	}
+	if e.Makefile {
+		report(false, "Makefile", "existing Makefile kept")
+	}
+	report(false, "WORKSPACE", "bazel files are not generated when adopting a project")
+
+	for _, c := range created {
+		klog.Infof("Created %s", c)
+	}
+	for _, s := range skipped {
+		klog.Infof("Skipped %s", s)
+	}
+}
@@ -18,6 +18,8 @@ package init_repo
 
 import (
 	"github.com/spf13/cobra"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 )
 
 var initCmd = &cobra.Command{
@@ -28,6 +30,12 @@ var initCmd = &cobra.Command{
 # Bootstrap a new repo
 apiserver-boot init repo --domain example.com
 `,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// the project doesn't exist yet, don't search the parent directories for it
+		if err := util.ChdirProjectRoot(false); err != nil {
+			klog.Fatal(err)
+		}
+	},
 	Run: RunInit,
 }
 
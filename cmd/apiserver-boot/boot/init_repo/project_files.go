@@ -0,0 +1,385 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package init_repo
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+const (
+	licenseApache2 = "apache2"
+	licenseMIT     = "mit"
+	licenseNone    = "none"
+)
+
+// Names accepted by --skip for the project files written by init repo.
+const (
+	fileBoilerplate = "boilerplate"
+	fileMakefile    = "makefile"
+	fileDockerfile  = "dockerfile"
+	fileGitignore   = "gitignore"
+	fileCodegen     = "codegen"
+)
+
+var supportedLicenses = []string{licenseApache2, licenseMIT, licenseNone}
+
+var supportedProjectFiles = []string{fileBoilerplate, fileMakefile, fileDockerfile, fileGitignore, fileCodegen}
+
+func validateProjectFileFlags() {
+	if !sets.NewString(supportedLicenses...).Has(license) {
+		klog.Fatalf("--license must be one of %v but was (%s)", supportedLicenses, license)
+	}
+	for _, s := range skipFiles {
+		if !sets.NewString(supportedProjectFiles...).Has(s) {
+			klog.Fatalf("--skip must be one of %v but was (%s)", supportedProjectFiles, s)
+		}
+	}
+}
+
+func shouldSkip(file string) bool {
+	for _, s := range skipFiles {
+		if s == file {
+			return true
+		}
+	}
+	return false
+}
+
+type boilerplateTemplateArguments struct {
+	Year  string
+	Owner string
+}
+
+// createBoilerplate writes the copyright header used by every generated go file.
+// It must run before anything reads the boilerplate through util.GetCopyright.
+func createBoilerplate() {
+	if shouldSkip(fileBoilerplate) {
+		return
+	}
+	var t string
+	switch license {
+	case licenseApache2:
+		t = apache2BoilerplateTemplate
+	case licenseMIT:
+		t = mitBoilerplateTemplate
+	case licenseNone:
+		t = noneBoilerplateTemplate
+	}
+	util.WriteIfNotFound(copyright, "boilerplate-template", t,
+		boilerplateTemplateArguments{
+			Year:  time.Now().Format("2006"),
+			Owner: owner,
+		})
+}
+
+type projectFilesTemplateArguments struct {
+	Repo        string
+	Domain      string
+	Boilerplate string
+}
+
+type toolsTemplateArguments struct {
+	BoilerPlate string
+}
+
+// createProjectFiles writes the Makefile, Dockerfile, .gitignore and codegen scripts.  The
+// Makefile, Dockerfile and .gitignore replace the controller-manager flavored ones written by
+// the kubebuilder scaffolding.  The code generators run by the codegen scripts are imported by
+// hack/tools.go, so the versions go.mod pins for them are kept by go mod tidy.
+func createProjectFiles() {
+	dir, err := os.Getwd()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	a := projectFilesTemplateArguments{
+		Repo:        util.GetRepo(),
+		Domain:      domain,
+		Boilerplate: filepath.ToSlash(copyright),
+	}
+
+	if !shouldSkip(fileMakefile) {
+		util.Overwrite(filepath.Join(dir, "Makefile"), "makefile-template", makefileTemplate, a)
+	}
+	if !shouldSkip(fileDockerfile) {
+		util.Overwrite(filepath.Join(dir, "Dockerfile"), "dockerfile-template", dockerfileTemplate, a)
+	}
+	if !shouldSkip(fileGitignore) {
+		util.Overwrite(filepath.Join(dir, ".gitignore"), "gitignore-template", gitignoreTemplate, a)
+	}
+	if !shouldSkip(fileCodegen) {
+		for name, t := range map[string]string{
+			"update-codegen.sh": updateCodegenTemplate,
+			"verify-codegen.sh": verifyCodegenTemplate,
+		} {
+			path := filepath.Join(dir, "hack", name)
+			if util.WriteIfNotFound(path, name+"-template", t, a) {
+				if err := os.Chmod(path, 0755); err != nil {
+					klog.Fatal(err)
+				}
+			}
+		}
+		util.WriteIfNotFound(filepath.Join(dir, "hack", "tools.go"), "tools-template", toolsTemplate,
+			toolsTemplateArguments{util.GetCopyright(copyright)})
+	}
+}
+
+var apache2BoilerplateTemplate = `/*
+{{- if .Owner }}
+Copyright {{.Year}} {{.Owner}}.
+{{- end }}
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+`
+
+var mitBoilerplateTemplate = `/*
+{{- if .Owner }}
+Copyright {{.Year}} {{.Owner}}.
+{{- end }}
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+`
+
+var noneBoilerplateTemplate = `/*
+{{- if .Owner }}
+Copyright {{.Year}} {{.Owner}}.
+{{- end }}
+*/
+`
+
+var makefileTemplate = `
+# Image URL to use all building/pushing image targets
+IMG ?= apiserver:latest
+
+all: build
+
+# Run tests
+test: generate fmt vet
+	go test ./pkg/... ./controllers/... -coverprofile cover.out
+
+# Generate deepcopy and openapi code for the types under pkg/apis
+generate:
+	./hack/update-codegen.sh
+
+# Fail if the generated code is out of date
+verify:
+	./hack/verify-codegen.sh
+
+# Build the apiserver and controller-manager binaries into bin/
+build: generate fmt vet
+	apiserver-boot build executables
+
+# Run etcd, the apiserver and the controller-manager against the local machine
+run: generate fmt vet
+	apiserver-boot run local
+
+# Run go fmt against code
+fmt:
+	go fmt ./...
+
+# Run go vet against code
+vet:
+	go vet ./...
+
+# Build the docker image
+docker-build:
+	docker build . -t ${IMG}
+
+# Push the docker image
+docker-push:
+	docker push ${IMG}
+
+.PHONY: all test generate verify build run fmt vet docker-build docker-push
+`
+
+var dockerfileTemplate = `
+# Build the apiserver and controller-manager binaries
+FROM golang:1.15 as builder
+
+WORKDIR /workspace
+# Copy the Go Modules manifests
+COPY go.mod go.mod
+COPY go.sum go.sum
+# cache deps before building and copying source so that we don't need to re-download as much
+# and so that source changes don't invalidate our downloaded layer
+RUN go mod download
+
+# Copy the go source
+COPY . .
+
+# Build
+RUN CGO_ENABLED=0 GOOS=linux GOARCH=amd64 GO111MODULE=on go build -a -o apiserver cmd/apiserver/main.go
+RUN CGO_ENABLED=0 GOOS=linux GOARCH=amd64 GO111MODULE=on go build -a -o controller-manager main.go
+
+# Use distroless as minimal base image to package the binaries
+# Refer to https://github.com/GoogleContainerTools/distroless for more details
+FROM gcr.io/distroless/static:nonroot
+WORKDIR /
+COPY --from=builder /workspace/apiserver .
+COPY --from=builder /workspace/controller-manager .
+USER 65532:65532
+`
+
+var gitignoreTemplate = `
+# Binaries for programs and plugins
+*.exe
+*.exe~
+*.dll
+*.so
+*.dylib
+bin
+
+# Test binary, build with ` + "`go test -c`" + `
+*.test
+
+# Output of the go coverage tool, specifically when used with LiteIDE
+*.out
+
+# Local kubeconfig and certificates written by apiserver-boot run local
+kubeconfig
+apiserver.local.config
+config/certificates
+
+# editor and IDE paraphernalia
+.idea
+*.swp
+*.swo
+*~
+`
+
+var updateCodegenTemplate = `#!/usr/bin/env bash
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
+cd "${SCRIPT_ROOT}"
+
+# deepcopy functions for every type under pkg/apis
+go run sigs.k8s.io/controller-tools/cmd/controller-gen \
+  object:headerFile="{{.Boilerplate}}" \
+  paths="./pkg/apis/..."
+
+OUTPUT_BASE="$(mktemp -d)"
+trap 'rm -rf "${OUTPUT_BASE}"' EXIT
+
+# conversions and defaulters for the versions of groups with an internal version,
+# only packages tagged with +k8s:conversion-gen and +k8s:defaulter-gen are generated
+go run k8s.io/code-generator/cmd/conversion-gen \
+  --input-dirs "{{.Repo}}/pkg/apis/..." \
+  --output-base "${OUTPUT_BASE}" \
+  --go-header-file "{{.Boilerplate}}" \
+  -O zz_generated.conversion
+go run k8s.io/code-generator/cmd/defaulter-gen \
+  --input-dirs "{{.Repo}}/pkg/apis/..." \
+  --output-base "${OUTPUT_BASE}" \
+  --go-header-file "{{.Boilerplate}}" \
+  -O zz_generated.defaults
+if [ -d "${OUTPUT_BASE}/{{.Repo}}/pkg/apis" ]; then
+  cp -r "${OUTPUT_BASE}/{{.Repo}}/pkg/apis/." pkg/apis/
+fi
+
+# openapi definitions served by the apiserver
+go run k8s.io/kube-openapi/cmd/openapi-gen \
+  --input-dirs "{{.Repo}}/pkg/apis/...,k8s.io/apimachinery/pkg/apis/meta/v1,k8s.io/apimachinery/pkg/runtime,k8s.io/apimachinery/pkg/version" \
+  --output-package "{{.Repo}}/pkg/generated/openapi" \
+  --output-base "${OUTPUT_BASE}" \
+  --go-header-file "{{.Boilerplate}}" \
+  --report-filename /dev/null \
+  -O zz_generated.openapi
+mkdir -p pkg/generated/openapi
+cp "${OUTPUT_BASE}/{{.Repo}}/pkg/generated/openapi/zz_generated.openapi.go" pkg/generated/openapi/
+`
+
+var toolsTemplate = `//go:build tools
+// +build tools
+
+{{.BoilerPlate}}
+
+// Package tools imports the code generators run by update-codegen.sh so go.mod tracks their versions.
+package tools
+
+import (
+	_ "k8s.io/code-generator/cmd/conversion-gen"
+	_ "k8s.io/code-generator/cmd/defaulter-gen"
+	_ "k8s.io/kube-openapi/cmd/openapi-gen"
+	_ "sigs.k8s.io/controller-tools/cmd/controller-gen"
+)
+`
+
+var verifyCodegenTemplate = `#!/usr/bin/env bash
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
+cd "${SCRIPT_ROOT}"
+
+TMP_DIFFROOT="$(mktemp -d)"
+cleanup() {
+  rm -rf "${TMP_DIFFROOT}"
+}
+trap "cleanup" EXIT SIGINT
+
+cp -a pkg "${TMP_DIFFROOT}/"
+
+./hack/update-codegen.sh
+
+echo "diffing pkg against freshly generated codegen"
+if diff -Naupr "${TMP_DIFFROOT}/pkg" pkg; then
+  echo "pkg up to date."
+else
+  echo "pkg is out of date. Please run hack/update-codegen.sh"
+  cp -a "${TMP_DIFFROOT}/pkg/." pkg/
+  exit 1
+fi
+`
@@ -17,6 +17,7 @@ limitations under the License.
 package init_repo
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -28,16 +29,30 @@ import (
 )
 
 var repoCmd = &cobra.Command{
-	Use:     "repo",
-	Short:   "Initialize a repo with the apiserver scaffolding",
-	Long:    `Initialize a repo with the apiserver scaffolding`,
-	Example: `apiserver-boot init repo --domain mydomain`,
-	Run:     RunInitRepo,
+	Use:   "repo",
+	Short: "Initialize a repo with the apiserver scaffolding",
+	Long:  `Initialize a repo with the apiserver scaffolding`,
+	Example: `apiserver-boot init repo --domain mydomain
+
+# Use the MIT license in the generated boilerplate and don't generate a Makefile
+apiserver-boot init repo --domain mydomain --license mit --owner "Acme" --skip makefile
+
+# Add an aggregated apiserver to an existing kubebuilder project, reusing the domain from its PROJECT file
+apiserver-boot init repo --adopt
+
+# Start the project from an organization-maintained starter tarball
+apiserver-boot init repo --domain mydomain --template https://example.com/starters/apiserver.tar.gz`,
+	Run: RunInitRepo,
 }
 
 var domain string
 var copyright string
 var moduleName string
+var license string
+var owner string
+var skipFiles []string
+var adopt bool
+var starterTemplate string
 
 func AddInitRepo(cmd *cobra.Command) {
 	cmd.AddCommand(repoCmd)
@@ -47,25 +62,51 @@ func AddInitRepo(cmd *cobra.Command) {
 	repoCmd.Flags().StringVar(&copyright, "copyright", filepath.Join("hack", "boilerplate.go.txt"), "Location of copyright boilerplate file.")
 	repoCmd.Flags().StringVar(&moduleName, "module-name", "",
 		"the module name of the go mod project, required if the project uses go module outside GOPATH")
+	repoCmd.Flags().StringVar(&license, "license", licenseApache2,
+		fmt.Sprintf("license to use in the generated boilerplate, supported values: %v", supportedLicenses))
+	repoCmd.Flags().StringVar(&owner, "owner", "", "copyright owner written into the generated boilerplate")
+	repoCmd.Flags().StringSliceVar(&skipFiles, "skip", []string{},
+		fmt.Sprintf("project files not to generate, supported values: %v", supportedProjectFiles))
+	repoCmd.Flags().BoolVar(&adopt, "adopt", false,
+		"if set, add the apiserver scaffolding to an existing go or kubebuilder project without touching its go.mod, PROJECT, main.go and config/")
+	repoCmd.Flags().StringVar(&starterTemplate, "template", "",
+		"path or http(s) url of a gzip-compressed tarball to start the project from, files are rendered with the Domain, Repo and BoilerPlate of the project.  Can't be used with --skip, nor with --license and --owner if the tarball carries the boilerplate")
 }
 
 func RunInitRepo(cmd *cobra.Command, args []string) {
+	validateProjectFileFlags()
+	if adopt && len(starterTemplate) > 0 {
+		klog.Fatal("--adopt and --template can't be used together")
+	}
+	if len(starterTemplate) > 0 && len(skipFiles) > 0 {
+		klog.Fatal("--skip can't be used with --template, the starter template carries the project files")
+	}
+	if adopt {
+		adoptRepo()
+		return
+	}
 	if len(domain) == 0 {
 		klog.Fatal("Must specify --domain")
 	}
 
 	if len(moduleName) == 0 {
-		if err := util.LoadRepoFromGoPath(); err != nil {
+		if err := util.LoadRepoFromGoPathOrGoMod(); err != nil {
 			klog.Fatal(err)
 		}
 	} else {
 		util.SetRepo(moduleName)
 	}
+	if len(starterTemplate) > 0 {
+		initRepoFromTemplate(cmd.Flags().Changed("license") || cmd.Flags().Changed("owner"))
+		return
+	}
+	createBoilerplate()
 	createControllerManager()
 	os.RemoveAll(filepath.Join("config")) // removes kubebuilder config scaffolding
+	createProjectFiles()
 
 	cr := util.GetCopyright(copyright)
-	createGoMod()
+	createGoMod(!shouldSkip(fileCodegen))
 	createKubeBuilderProjectFile()
 	createBazelWorkspace()
 	createApiserver(cr)
@@ -85,13 +126,31 @@ func RunInitRepo(cmd *cobra.Command, args []string) {
 
 }
 
-func createKubeBuilderProjectFile() {
+func initRepoFromTemplate(licenseSet bool) {
+	createBoilerplate()
+	initFromTemplate(util.GetCopyright(copyright), licenseSet)
+
+	// the starter may replace the boilerplate, re-read it before filling in the
+	// files the starter doesn't carry
+	cr := util.GetCopyright(copyright)
+	createKubeBuilderProjectFile()
+	if _, err := os.Stat("go.mod"); os.IsNotExist(err) {
+		createGoMod(false)
+	}
+	createApiserver(cr)
+	createAPIs(cr)
+	validateStarter()
+
+	os.MkdirAll("bin", 0700)
+}
+
+func createKubeBuilderProjectFile() bool {
 	dir, err := os.Getwd()
 	if err != nil {
 		klog.Fatal(err)
 	}
 	path := filepath.Join(dir, "PROJECT")
-	util.WriteIfNotFound(path, "project-template", projectFileTemplate,
+	return util.WriteIfNotFound(path, "project-template", projectFileTemplate,
 		buildTemplateArguments{domain, util.GetRepo()})
 }
 
@@ -153,6 +212,7 @@ import (
 func main() {
 	err := builder.APIServer.
 		// +kubebuilder:scaffold:resource-register
+		WithLocalDebugExtension().
 		Execute()
 	if err != nil {
 		klog.Fatal(err)
@@ -160,13 +220,13 @@ func main() {
 }
 `
 
-func createApiserver(boilerplate string) {
+func createApiserver(boilerplate string) bool {
 	dir, err := os.Getwd()
 	if err != nil {
 		klog.Fatal(err)
 	}
 	path := filepath.Join(dir, "cmd", "apiserver", "main.go")
-	util.WriteIfNotFound(path, "apiserver-template", apiserverTemplate,
+	return util.WriteIfNotFound(path, "apiserver-template", apiserverTemplate,
 		apiserverTemplateArguments{
 			domain,
 			boilerplate,
@@ -204,20 +264,22 @@ package {{.Package}}
 
 `
 
-func createAPIs(boilerplate string) {
+func createAPIs(boilerplate string) bool {
 	dir, err := os.Getwd()
 	if err != nil {
 		klog.Fatal(err)
 	}
 	path := filepath.Join(dir, "pkg", "apis", "doc.go")
-	util.WriteIfNotFound(path, "apis-template", apisDocTemplate,
+	return util.WriteIfNotFound(path, "apis-template", apisDocTemplate,
 		apisDocTemplateArguments{
 			boilerplate,
 			domain,
 		})
 }
 
-func createGoMod() {
+// createGoMod writes the go.mod of the project, codegen pins the versions of the code generators
+// run by hack/update-codegen.sh.
+func createGoMod(codegen bool) {
 	dir, err := os.Getwd()
 	if err != nil {
 		klog.Fatal(err)
@@ -226,6 +288,7 @@ func createGoMod() {
 	util.Overwrite(path, "gomod-template", goModTemplate,
 		goModTemplateArguments{
 			util.GetRepo(),
+			codegen,
 		})
 }
 
@@ -290,7 +353,8 @@ gazelle(
 `
 
 type goModTemplateArguments struct {
-	Repo string
+	Repo    string
+	Codegen bool
 }
 
 var goModTemplate = `
@@ -303,8 +367,17 @@ require (
 	github.com/go-logr/zapr v0.2.0 // indirect
 	k8s.io/apimachinery v0.19.2
 	k8s.io/client-go v0.19.2
+{{- if .Codegen }}
+	k8s.io/code-generator v0.19.2
+{{- end }}
 	k8s.io/klog v1.0.0
+{{- if .Codegen }}
+	k8s.io/kube-openapi v0.0.0-20200805222855-6aeccd4b50c6
+{{- end }}
 	sigs.k8s.io/apiserver-runtime v1.0.1
 	sigs.k8s.io/controller-runtime v0.6.0
+{{- if .Codegen }}
+	sigs.k8s.io/controller-tools v0.4.1
+{{- end }}
 )
 `
@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package init_repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+// starterMarkers are the scaffold markers the create commands append to.  A starter template
+// must carry them for create resource to work in the new project.
+var starterMarkers = map[string][]string{
+	filepath.Join("cmd", "apiserver", "main.go"): {
+		"// +kubebuilder:scaffold:resource-imports",
+		"// +kubebuilder:scaffold:resource-register",
+	},
+	filepath.Join("pkg", "apis", "doc.go"): {
+		"// +domain=",
+	},
+}
+
+type starterTemplateArguments struct {
+	Domain      string
+	Repo        string
+	BoilerPlate string
+}
+
+// initFromTemplate bootstraps the repo from a gzip-compressed starter tarball.  Every file in the
+// tarball is rendered as a go template with the Domain, Repo and BoilerPlate of the new project,
+// files which are not valid templates for that data are extracted verbatim.  The templates may use
+// the title, lower and plural functions of the apiserver-boot templates.  licenseSet is true if
+// --license or --owner were set, which a boilerplate carried by the starter would ignore.
+func initFromTemplate(boilerplate string, licenseSet bool) {
+	data, err := readStarter(starterTemplate)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	names, err := starterFiles(data)
+	if err != nil {
+		klog.Fatalf("failed reading starter template %s: %v", starterTemplate, err)
+	}
+	// the boilerplate of the starter replaces the one written for --license and --owner
+	for _, name := range names {
+		if licenseSet && filepath.Clean(filepath.FromSlash(name)) == filepath.Clean(copyright) {
+			klog.Fatalf("starter template %s carries its own boilerplate %s, --license and --owner "+
+				"can't be used with it", starterTemplate, name)
+		}
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	a := starterTemplateArguments{
+		Domain:      domain,
+		Repo:        util.GetRepo(),
+		BoilerPlate: boilerplate,
+	}
+	renderFunc := map[string]func(io.Reader) io.Reader{}
+	for _, name := range names {
+		renderFunc[filepath.Join(dir, filepath.FromSlash(name))] = renderStarterFile(name, a)
+	}
+	if err := util.Untar(bytes.NewReader(data), dir, renderFunc); err != nil {
+		klog.Fatalf("failed extracting starter template %s: %v", starterTemplate, err)
+	}
+}
+
+func readStarter(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed downloading starter template %s", location)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed downloading starter template %s: %s", location, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading starter template %s", location)
+	}
+	return data, nil
+}
+
+// starterFiles lists the regular files in the gzip-compressed tarball.
+func starterFiles(data []byte) ([]string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("requires gzip-compressed body: %v", err)
+	}
+	tr := tar.NewReader(zr)
+	var names []string
+	for {
+		f, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f.FileInfo().Mode().IsRegular() {
+			names = append(names, f.Name)
+		}
+	}
+	return names, nil
+}
+
+func renderStarterFile(name string, a starterTemplateArguments) func(io.Reader) io.Reader {
+	return func(r io.Reader) io.Reader {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			klog.Fatalf("failed reading %s from starter template: %v", name, err)
+		}
+		t, err := template.New(name).Funcs(util.TemplateFuncs).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			klog.V(1).Infof("Copying %s verbatim, not a template: %v", name, err)
+			return bytes.NewReader(content)
+		}
+		out := &bytes.Buffer{}
+		if err := t.Execute(out, a); err != nil {
+			klog.V(1).Infof("Copying %s verbatim, failed rendering: %v", name, err)
+			return bytes.NewReader(content)
+		}
+		return out
+	}
+}
+
+// validateStarter checks that the files the create commands update contain their scaffold markers.
+func validateStarter() {
+	var missing []string
+	for file, markers := range starterMarkers {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		for _, m := range markers {
+			if !strings.Contains(string(content), m) {
+				missing = append(missing, fmt.Sprintf("%s: missing marker %q", file, m))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		klog.Fatalf("starter template %s can't be used with apiserver-boot create:\n%s",
+			starterTemplate, strings.Join(missing, "\n"))
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/mod/modfile"
+	"k8s.io/klog"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
+)
+
+var release string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the API versions for resources past their removal release",
+	Long: `Check the API versions for resources past their removal release.  Lists the deprecated
+API versions and fails if any of them is still served at or after the release it is removed in.`,
+	Example: `# Check against the kubernetes release of the k8s.io/apiserver dependency in go.mod
+apiserver-boot lint
+
+# Check against a specific kubernetes release
+apiserver-boot lint --release 1.30`,
+	Run: RunLint,
+}
+
+func AddLint(cmd *cobra.Command) {
+	lintCmd.Flags().StringVar(&release, "release", "",
+		"kubernetes release to check against, defaults to the release of the k8s.io/apiserver dependency in go.mod")
+	cmd.AddCommand(lintCmd)
+}
+
+func RunLint(cmd *cobra.Command, args []string) {
+	if _, err := os.Stat(filepath.Join("pkg", "apis")); err != nil {
+		klog.Fatalf("could not find 'pkg/apis' directory.  must run apiserver-boot init before lint")
+	}
+
+	if len(release) == 0 {
+		r, err := releaseFromGoMod()
+		if err != nil {
+			klog.Fatalf("failed detecting the kubernetes release, specify --release: %v", err)
+		}
+		release = r
+	}
+	current, err := util.ParseRelease(release)
+	if err != nil {
+		klog.Fatalf("--release: %v", err)
+	}
+
+	groups, err := ioutil.ReadDir(filepath.Join("pkg", "apis"))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	versionMatch := regexp.MustCompile("^v\\d+(alpha\\d+|beta\\d+)*$")
+	var removed []string
+	for _, g := range groups {
+		if !g.IsDir() {
+			continue
+		}
+		versions, err := ioutil.ReadDir(filepath.Join("pkg", "apis", g.Name()))
+		if err != nil {
+			klog.Fatal(err)
+		}
+		for _, v := range versions {
+			if !v.IsDir() || !versionMatch.MatchString(v.Name()) {
+				continue
+			}
+			l, deprecated, err := util.GetVersionLifecycle(g.Name(), v.Name())
+			if err != nil {
+				klog.Fatal(err)
+			}
+			if !deprecated {
+				continue
+			}
+			kinds, err := util.GetKinds(g.Name(), v.Name())
+			if err != nil {
+				klog.Fatal(err)
+			}
+			if len(kinds) == 0 {
+				// the version itself is still served
+				kinds = []string{"(no resources)"}
+			}
+			for _, k := range kinds {
+				msg := fmt.Sprintf("%s/%s %s: deprecated in %s, removed in %s, use %s",
+					g.Name(), v.Name(), k, l.DeprecatedIn, l.RemovedIn, l.Replacement)
+				if current.Before(l.RemovedIn) {
+					fmt.Println(msg)
+				} else {
+					removed = append(removed, msg)
+				}
+			}
+		}
+	}
+	if len(removed) > 0 {
+		klog.Fatalf("resources past their removal release %s:\n%s", current, strings.Join(removed, "\n"))
+	}
+}
+
+// releaseFromGoMod maps the k8s.io/apiserver (or k8s.io/apimachinery) v0.X module version
+// required by the module of the project to the kubernetes release 1.X.
+func releaseFromGoMod() (string, error) {
+	modDir, err := util.ModuleRoot()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(modDir, "go.mod")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := modfile.Parse(path, data, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, mod := range []string{"k8s.io/apiserver", "k8s.io/apimachinery"} {
+		for _, r := range f.Require {
+			if r.Mod.Path != mod {
+				continue
+			}
+			parts := strings.Split(strings.TrimPrefix(r.Mod.Version, "v"), ".")
+			if len(parts) < 2 || parts[0] != "0" {
+				return "", fmt.Errorf("unexpected version %s of %s", r.Mod.Version, mod)
+			}
+			return "1." + parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("%s requires neither k8s.io/apiserver nor k8s.io/apimachinery", path)
+}
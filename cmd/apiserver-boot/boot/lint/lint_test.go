@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReleaseFromGoMod(t *testing.T) {
+	tests := []struct {
+		name  string
+		goMod string
+		want  string
+		err   bool
+	}{
+		{
+			name: "apiserver",
+			goMod: `module example.com/insect
+
+go 1.15
+
+require (
+	k8s.io/apimachinery v0.19.2
+	k8s.io/apiserver v0.20.1
+)
+`,
+			want: "1.20",
+		},
+		{
+			name:  "apimachinery only",
+			goMod: "module example.com/insect\n\nrequire k8s.io/apimachinery v0.19.2\n",
+			want:  "1.19",
+		},
+		{
+			name:  "prerelease",
+			goMod: "module example.com/insect\n\nrequire k8s.io/apiserver v0.21.0-beta.1\n",
+			want:  "1.21",
+		},
+		{
+			name:  "no kubernetes dependency",
+			goMod: "module example.com/insect\n\nrequire github.com/spf13/cobra v1.1.1\n",
+			err:   true,
+		},
+		{
+			name:  "unexpected version",
+			goMod: "module example.com/insect\n\nrequire k8s.io/apiserver v1.20.1\n",
+			err:   true,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	// lint runs in the project, which may be a subdirectory of the module
+	project := filepath.Join(dir, "project")
+	if err := os.MkdirAll(project, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(project); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(test.goMod), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := releaseFromGoMod()
+		switch {
+		case test.err && err == nil:
+			t.Errorf("%s: releaseFromGoMod() succeeded", test.name)
+		case !test.err && err != nil:
+			t.Errorf("%s: releaseFromGoMod(): %v", test.name, err)
+		case got != test.want:
+			t.Errorf("%s: releaseFromGoMod() = %s want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -64,7 +64,7 @@ func RunInCluster(cmd *cobra.Command, args []string) {
 
 		// Push the image
 		if pushImage {
-			util.DoCmd("docker", "push", build.Image)
+			build.PushImage()
 		}
 	}
 
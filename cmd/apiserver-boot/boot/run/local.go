@@ -30,6 +30,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"k8s.io/apimachinery/pkg/util/wait"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/build"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 )
@@ -39,7 +40,9 @@ var localCmd = &cobra.Command{
 	Short: "run the etcd, apiserver and controller",
 	Long: `run the etcd, apiserver and controller, Note that the aggregated apiserver in the local mode 
 will not be attempting to delegate any requests to an acutal kube-apiserver, hence neither authentication 
-nor authorization will be performed.`,
+nor authorization will be performed, unless --cluster-kubeconfig is set.  With --cluster-kubeconfig the
+apiserver delegates authentication and authorization to that cluster, and can be aggregated into it with
+the config of apiserver-boot build config --local.`,
 	Example: `
 # Regenerate code and build binaries then run them. 
 
@@ -54,7 +57,13 @@ apiserver-boot run local --build=false
 # Create an instance and fetch it
 nano -w samples/<type>.yaml
 kubectl --kubeconfig kubeconfig apply -f samples/<type>.yaml
-kubectl --kubeconfig kubeconfig get <type>`,
+kubectl --kubeconfig kubeconfig get <type>
+
+# Aggregate the locally running server into the kind or minikube cluster of the current context
+apiserver-boot build config --name nameofservice --namespace default --local
+kubectl apply -f config/local
+apiserver-boot run local --cluster-kubeconfig ~/.kube/config
+kubectl get <type>`,
 	Run: RunLocal,
 }
 
@@ -71,6 +80,7 @@ var toRun []string
 var disableMTLS bool
 var certDir string
 var securePort int32
+var clusterKubeconfig string
 
 func AddLocal(cmd *cobra.Command) {
 	localCmd.Flags().StringSliceVar(&toRun, "run", []string{"etcd", "apiserver", "controller"}, "path to apiserver binary to run")
@@ -90,12 +100,19 @@ func AddLocal(cmd *cobra.Command) {
 	localCmd.Flags().BoolVar(&buildBin, "build", true, "if true, build the binaries before running")
 
 	localCmd.Flags().Int32Var(&securePort, "secure-port", 9443, "Secure port from apiserver to serve requests")
-	localCmd.Flags().StringVar(&certDir, "cert-dir", filepath.Join("config", "certificates"), "directory containing apiserver certificates")
+	localCmd.Flags().StringVar(&certDir, "cert-dir", "",
+		"directory containing apiserver certificates, defaults to config/certificates or config/base/certificates")
+	localCmd.Flags().StringVar(&clusterKubeconfig, "cluster-kubeconfig", "",
+		"kubeconfig of the cluster the apiserver is aggregated into with the config of build config --local, "+
+			"the apiserver delegates authentication and authorization to it and serves with mTLS on all addresses")
 
 	cmd.AddCommand(localCmd)
 }
 
 func RunLocal(cmd *cobra.Command, args []string) {
+	if len(certDir) == 0 {
+		certDir = util.CertificatesDir()
+	}
 	if buildBin {
 		build.BuildTargets = toRun
 		build.RunBuildExecutables(cmd, args)
@@ -105,6 +122,7 @@ func RunLocal(cmd *cobra.Command, args []string) {
 
 	// parent context to indicate whether cmds quit
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	ctx = util.CancelWhenSignaled(ctx)
 
 	r := map[string]interface{}{}
@@ -151,6 +169,12 @@ func RunLocal(cmd *cobra.Command, args []string) {
 	<-ctx.Done() // wait forever
 }
 
+// standalone is true if the apiserver serves without mTLS, it always serves with mTLS when it
+// delegates to a cluster
+func standalone() bool {
+	return disableMTLS && len(clusterKubeconfig) == 0
+}
+
 func RunEtcd(ctx context.Context, cancel context.CancelFunc) *exec.Cmd {
 	etcdCmd := exec.Command("etcd")
 	if printetcd {
@@ -169,16 +193,18 @@ func RunApiserver(ctx context.Context, cancel context.CancelFunc) *exec.Cmd {
 	}
 
 	// checking if apiserver supports local running
-	apiserverTestLocalCmd := exec.Command(server, "-h")
-	buf := &bytes.Buffer{}
-	apiserverTestLocalCmd.Stdout = buf
-	runCommon(apiserverTestLocalCmd, ctx, nil)
-	if !strings.Contains(string(buf.Bytes()), "--standalone-debug-mode") {
-		klog.Fatalf(`
+	if standalone() {
+		apiserverTestLocalCmd := exec.Command(server, "-h")
+		buf := &bytes.Buffer{}
+		apiserverTestLocalCmd.Stdout = buf
+		runCommon(apiserverTestLocalCmd, ctx, nil)
+		if !strings.Contains(string(buf.Bytes()), "--standalone-debug-mode") {
+			klog.Fatalf(`
 The apiserver binary doesn't seem to support --standalone-debug-mode, 
 did you have WithLocalDebugExtension() in your apiserver? (if you're using kuberentes-sigs/apiserver-runtime')`)
+		}
+		klog.Info("The apiserver binary supports local-running, proceeding..")
 	}
-	klog.Info("The apiserver binary supports local-running, proceeding..")
 
 	// starting apiserver process
 	flags := []string{
@@ -187,7 +213,15 @@ did you have WithLocalDebugExtension() in your apiserver? (if you're using kuber
 		fmt.Sprintf("--feature-gates=APIPriorityAndFairness=false"), // TODO: remove this line after https://github.com/kubernetes/kubernetes/pull/97957 merged
 	}
 
-	if disableMTLS {
+	if len(clusterKubeconfig) > 0 {
+		flags = append(flags,
+			fmt.Sprintf("--kubeconfig=%s", clusterKubeconfig),
+			fmt.Sprintf("--authentication-kubeconfig=%s", clusterKubeconfig),
+			fmt.Sprintf("--authorization-kubeconfig=%s", clusterKubeconfig),
+		)
+	}
+
+	if standalone() {
 		flags = append(flags, "--standalone-debug-mode")
 		flags = append(flags, "--bind-address=127.0.0.1")
 	} else {
@@ -261,21 +295,33 @@ func runCommon(cmd *exec.Cmd, ctx context.Context, cancel context.CancelFunc) {
 	}
 }
 
+// WriteKubeConfig writes the apiserver cluster, user and context to the kubeconfig.  The other
+// entries, e.g. the users added by apiserver-boot certs issue-user, are kept.
 func WriteKubeConfig() {
 	klog.Infof("Writing kubeconfig to %s", config)
-	// Write a kubeconfig
 	dir, err := os.Getwd()
 	if err != nil {
 		klog.Fatalf("Cannot get working directory %v", err)
-		os.Exit(-1)
 	}
 	path := filepath.Join(dir, certDir)
-	util.WriteIfNotFound(config, "kubeconfig-template", configTemplate,
-		ConfigArgs{
-			DisabltMTLS: disableMTLS,
-			Path:        path,
-			Port:        fmt.Sprintf("%v", securePort),
-		})
+	err = util.UpdateKubeconfig(config, func(c *clientcmdv1.Config) {
+		cluster := clientcmdv1.Cluster{Server: fmt.Sprintf("https://localhost:%v", securePort)}
+		user := clientcmdv1.AuthInfo{}
+		if standalone() {
+			cluster.InsecureSkipTLSVerify = true
+			user.Username = "apiserver"
+		} else {
+			cluster.CertificateAuthority = filepath.Join(path, "apiserver_ca.crt")
+			user.ClientCertificate = filepath.Join(path, "apiserver.crt")
+			user.ClientKey = filepath.Join(path, "apiserver.key")
+		}
+		util.SetKubeconfigCluster(c, "apiserver", cluster)
+		util.SetKubeconfigUser(c, "apiserver", user)
+		util.SetKubeconfigContext(c, "apiserver", clientcmdv1.Context{Cluster: "apiserver", AuthInfo: "apiserver"}, false)
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
 }
 
 func WaitUntilCommandCompleted(cmd *exec.Cmd) {
@@ -289,39 +335,3 @@ func WaitUntilCommandCompleted(cmd *exec.Cmd) {
 	})
 	klog.Infof("Completed %s", cmdName)
 }
-
-type ConfigArgs struct {
-	DisabltMTLS bool
-	Path        string
-	Port        string
-}
-
-var configTemplate = `
-apiVersion: v1
-clusters:
-- cluster:
-{{- if .DisabltMTLS }}
-    insecure-skip-tls-verify: true
-{{- else }}
-    certificate-authority: {{ .Path }}/apiserver_ca.crt
-{{- end }}
-    server: https://localhost:{{ .Port }}
-  name: apiserver
-contexts:
-- context:
-    cluster: apiserver
-    user: apiserver
-  name: apiserver
-current-context: apiserver
-kind: Config
-preferences: {}
-users:
-- name: apiserver
-  user:
-{{- if not .DisabltMTLS }}
-    client-certificate: {{ .Path }}/apiserver.crt
-    client-key: {{ .Path }}/apiserver.key
-{{- else }}
-    username: apiserver
-{{- end }}
-`
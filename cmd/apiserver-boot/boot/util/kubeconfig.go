@@ -0,0 +1,99 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// UpdateKubeconfig applies update to the kubeconfig at path and writes it back, so the entries
+// not touched by update are kept.  A missing kubeconfig is created.
+func UpdateKubeconfig(path string, update func(config *clientcmdv1.Config)) error {
+	config := &clientcmdv1.Config{APIVersion: "v1", Kind: "Config"}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return errors.Wrapf(err, "failed parsing kubeconfig %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	update(config)
+	if data, err = yaml.Marshal(config); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// SetKubeconfigCluster adds or replaces the cluster name of config
+func SetKubeconfigCluster(config *clientcmdv1.Config, name string, cluster clientcmdv1.Cluster) {
+	for i := range config.Clusters {
+		if config.Clusters[i].Name == name {
+			config.Clusters[i].Cluster = cluster
+			return
+		}
+	}
+	config.Clusters = append(config.Clusters, clientcmdv1.NamedCluster{Name: name, Cluster: cluster})
+}
+
+// GetKubeconfigCluster returns the cluster name of config
+func GetKubeconfigCluster(config *clientcmdv1.Config, name string) (clientcmdv1.Cluster, bool) {
+	for _, c := range config.Clusters {
+		if c.Name == name {
+			return c.Cluster, true
+		}
+	}
+	return clientcmdv1.Cluster{}, false
+}
+
+// SetKubeconfigUser adds or replaces the user name of config
+func SetKubeconfigUser(config *clientcmdv1.Config, name string, user clientcmdv1.AuthInfo) {
+	for i := range config.AuthInfos {
+		if config.AuthInfos[i].Name == name {
+			config.AuthInfos[i].AuthInfo = user
+			return
+		}
+	}
+	config.AuthInfos = append(config.AuthInfos, clientcmdv1.NamedAuthInfo{Name: name, AuthInfo: user})
+}
+
+// SetKubeconfigContext adds or replaces the context name of config, and makes it the current
+// context if current is true or config has no valid current context.
+func SetKubeconfigContext(config *clientcmdv1.Config, name string, context clientcmdv1.Context, current bool) {
+	found := false
+	for i := range config.Contexts {
+		if config.Contexts[i].Name == name {
+			config.Contexts[i].Context = context
+			found = true
+		}
+	}
+	if !found {
+		config.Contexts = append(config.Contexts, clientcmdv1.NamedContext{Name: name, Context: context})
+	}
+	valid := false
+	for _, c := range config.Contexts {
+		valid = valid || c.Name == config.CurrentContext
+	}
+	if current || !valid {
+		config.CurrentContext = name
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tags recorded in pkg/apis/<group>/<version>/doc.go by `create version --deprecates`.
+const (
+	DeprecatedInTag = "+apiserver-boot:deprecated-in"
+	RemovedInTag    = "+apiserver-boot:removed-in"
+	ReplacementTag  = "+apiserver-boot:replacement"
+)
+
+// Release is a Kubernetes major.minor release, e.g. 1.30
+type Release struct {
+	Major int
+	Minor int
+}
+
+var releaseMatch = regexp.MustCompile(`^v?(\d+)\.(\d+)$`)
+
+// ParseRelease parses a major.minor release such as 1.30
+func ParseRelease(s string) (Release, error) {
+	l := releaseMatch.FindStringSubmatch(strings.TrimSpace(s))
+	if len(l) < 3 {
+		return Release{}, fmt.Errorf("release %q must be of the form <major>.<minor>, e.g. 1.30", s)
+	}
+	major, _ := strconv.Atoi(l[1])
+	minor, _ := strconv.Atoi(l[2])
+	return Release{major, minor}, nil
+}
+
+func (r Release) String() string {
+	return fmt.Sprintf("%d.%d", r.Major, r.Minor)
+}
+
+// Before returns true if r is an earlier release than o
+func (r Release) Before(o Release) bool {
+	return r.Major < o.Major || (r.Major == o.Major && r.Minor < o.Minor)
+}
+
+// VersionLifecycle is the deprecation metadata of an API version
+type VersionLifecycle struct {
+	DeprecatedIn Release
+	RemovedIn    Release
+	// Replacement is the version of the group superseding the deprecated version
+	Replacement string
+}
+
+// lifecycleTagMatch matches the values of the lifecycle tags
+var lifecycleTagMatch = map[string]*regexp.Regexp{
+	DeprecatedInTag: regexp.MustCompile(regexp.QuoteMeta(DeprecatedInTag) + "=(.*)"),
+	RemovedInTag:    regexp.MustCompile(regexp.QuoteMeta(RemovedInTag) + "=(.*)"),
+	ReplacementTag:  regexp.MustCompile(regexp.QuoteMeta(ReplacementTag) + "=(.*)"),
+}
+
+// GetVersionLifecycle returns the deprecation metadata of the API group version, and false if
+// the version isn't deprecated.
+func GetVersionLifecycle(group, version string) (VersionLifecycle, bool, error) {
+	path := filepath.Join("pkg", "apis", group, version, "doc.go")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return VersionLifecycle{}, false, err
+	}
+	tags := map[string]string{}
+	for t, match := range lifecycleTagMatch {
+		if l := match.FindSubmatch(b); len(l) == 2 {
+			tags[t] = strings.TrimSpace(string(l[1]))
+		}
+	}
+	if len(tags[DeprecatedInTag]) == 0 {
+		return VersionLifecycle{}, false, nil
+	}
+	l := VersionLifecycle{Replacement: tags[ReplacementTag]}
+	if l.DeprecatedIn, err = ParseRelease(tags[DeprecatedInTag]); err != nil {
+		return l, false, fmt.Errorf("%s: %v", path, err)
+	}
+	if l.RemovedIn, err = ParseRelease(tags[RemovedInTag]); err != nil {
+		return l, false, fmt.Errorf("%s: %v", path, err)
+	}
+	return l, true, nil
+}
+
+var kindMatch = regexp.MustCompile(`func \(in \*(\w+)\) GetGroupVersionResource\(\)`)
+
+// GetKinds returns the kinds of the resources defined in the API group version.
+func GetKinds(group, version string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join("pkg", "apis", group, version, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var kinds []string
+	for _, f := range files {
+		if strings.HasPrefix(filepath.Base(f), "zz_generated") || strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range kindMatch.FindAllSubmatch(b, -1) {
+			kinds = append(kinds, string(l[1]))
+		}
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		release string
+		want    Release
+		err     bool
+	}{
+		{release: "1.30", want: Release{1, 30}},
+		{release: "v1.30", want: Release{1, 30}},
+		{release: " 2.0 ", want: Release{2, 0}},
+		{release: "1", err: true},
+		{release: "1.30.1", err: true},
+		{release: "V1.30", err: true},
+		{release: "1.x", err: true},
+		{release: "", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseRelease(test.release)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("ParseRelease(%q) succeeded", test.release)
+		case !test.err && err != nil:
+			t.Errorf("ParseRelease(%q): %v", test.release, err)
+		case !test.err && got != test.want:
+			t.Errorf("ParseRelease(%q) = %v want %v", test.release, got, test.want)
+		}
+	}
+}
+
+// chdirTemp writes the files to a new directory and changes to it
+func chdirTemp(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetVersionLifecycle(t *testing.T) {
+	defer chdirTemp(t, map[string]string{
+		"pkg/apis/insect/v1beta1/doc.go": `// +k8s:deepcopy-gen=package
+// +groupName=insect.example.com
+// +apiserver-boot:deprecated-in=1.27
+// +apiserver-boot:removed-in=v1.30
+// +apiserver-boot:replacement=v1
+package v1beta1
+`,
+		"pkg/apis/insect/v1alpha1/doc.go": `// +apiserver-boot:deprecated-in=1.20
+// +apiserver-boot:removed-in=1.23
+package v1alpha1
+`,
+		"pkg/apis/insect/v1/doc.go": "// +groupName=insect.example.com\npackage v1\n",
+		"pkg/apis/insect/v2alpha1/doc.go": `// +apiserver-boot:deprecated-in=1.27
+package v2alpha1
+`,
+		"pkg/apis/insect/v2beta1/doc.go": `// +apiserver-boot:deprecated-in=1.x
+// +apiserver-boot:removed-in=1.30
+package v2beta1
+`,
+	})()
+
+	tests := []struct {
+		version    string
+		want       VersionLifecycle
+		deprecated bool
+		err        bool
+	}{
+		{
+			version:    "v1beta1",
+			want:       VersionLifecycle{DeprecatedIn: Release{1, 27}, RemovedIn: Release{1, 30}, Replacement: "v1"},
+			deprecated: true,
+		},
+		{
+			version:    "v1alpha1",
+			want:       VersionLifecycle{DeprecatedIn: Release{1, 20}, RemovedIn: Release{1, 23}},
+			deprecated: true,
+		},
+		{version: "v1"},
+		{version: "v2alpha1", err: true},
+		{version: "v2beta1", err: true},
+		{version: "v3", err: true},
+	}
+	for _, test := range tests {
+		got, deprecated, err := GetVersionLifecycle("insect", test.version)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("GetVersionLifecycle(%s) succeeded", test.version)
+		case !test.err && err != nil:
+			t.Errorf("GetVersionLifecycle(%s): %v", test.version, err)
+		case !test.err && (deprecated != test.deprecated || (deprecated && got != test.want)):
+			t.Errorf("GetVersionLifecycle(%s) = %+v %t want %+v %t", test.version, got, deprecated,
+				test.want, test.deprecated)
+		}
+	}
+}
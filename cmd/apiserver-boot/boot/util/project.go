@@ -0,0 +1,52 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// ProjectFile is the name of the kubebuilder project file at the root of the repo.
+const ProjectFile = "PROJECT"
+
+// Project is the subset of the kubebuilder PROJECT file read by apiserver-boot.  Unknown
+// fields are ignored so files written by any kubebuilder version can be loaded.
+type Project struct {
+	Version string `json:"version,omitempty"`
+	Domain  string `json:"domain,omitempty"`
+	Repo    string `json:"repo,omitempty"`
+
+	// GroupPriorityMinimum overrides the groupPriorityMinimum of the APIServices generated by
+	// build config, keyed by API group name.
+	GroupPriorityMinimum map[string]int `json:"groupPriorityMinimum,omitempty"`
+}
+
+// LoadProject reads the PROJECT file at path.
+func LoadProject(path string) (*Project, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading %s", path)
+	}
+	p := &Project{}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, errors.Wrapf(err, "failed parsing %s", path)
+	}
+	return p, nil
+}
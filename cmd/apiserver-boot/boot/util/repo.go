@@ -1,6 +1,8 @@
 package util
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -14,6 +16,10 @@ import (
 
 var repo string
 
+// ProjectRoot is the directory of the apiserver project.  It may differ from the root of the go
+// module containing it, e.g. for projects nested in a monorepo.
+var ProjectRoot string
+
 func LoadRepoFromGoPath() error {
 	gopath := os.Getenv("GOPATH")
 	if len(gopath) == 0 {
@@ -33,17 +39,58 @@ func LoadRepoFromGoPath() error {
 	return nil
 }
 
+// LoadRepoFromGoMod computes the repo from the nearest go.mod in the working directory or its
+// parents.  The repo is the module path joined with the path of the working directory relative to
+// the module root, so projects nested in a module get the right import path.
 func LoadRepoFromGoMod() error {
-	mod, err := ioutil.ReadFile("go.mod")
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	modDir, err := ModuleRoot()
+	if err != nil {
+		return err
+	}
+	r, err := repoFromModule(modDir, wd)
+	if err != nil {
+		return err
+	}
+	repo = r
+	return nil
+}
+
+// ModuleRoot returns the root of the go module of the project, the nearest directory with a
+// go.mod file of the working directory or its parents.  It doesn't read go.work, ChdirProjectRoot
+// changes to the project of a workspace before the module root is looked up from it.
+func ModuleRoot() (string, error) {
+	wd, err := os.Getwd()
 	if err != nil {
-		return errors.Wrap(err, "failed reading go.mod file")
+		return "", err
+	}
+	modDir, ok := findUp(wd, "go.mod")
+	if !ok {
+		return "", fmt.Errorf("failed finding go.mod in %s or any of its parent directories", wd)
+	}
+	return modDir, nil
+}
+
+func repoFromModule(modDir, dir string) (string, error) {
+	mod, err := ioutil.ReadFile(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return "", errors.Wrap(err, "failed reading go.mod file")
 	}
 	modPath := modfile.ModulePath(mod)
 	if len(modPath) == 0 {
-		return fmt.Errorf("failed parsing go.mod, empty module path")
+		return "", fmt.Errorf("failed parsing go.mod, empty module path")
 	}
-	repo = modPath
-	return nil
+	rel, err := filepath.Rel(modDir, dir)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return modPath, nil
+	}
+	return modPath + "/" + filepath.ToSlash(rel), nil
 }
 
 func LoadRepoFromGoPathOrGoMod() error {
@@ -67,3 +114,125 @@ func GetRepo() string {
 func SetRepo(r string) {
 	repo = r
 }
+
+// ChdirProjectRoot changes the working directory to the project root so the relative paths used
+// by the commands resolve against it.  If --project-root wasn't set and discover is true, the
+// project root is the nearest directory containing a PROJECT file, or the only module of the
+// go.work workspace containing one.  Otherwise --project-root is created if it doesn't exist.
+func ChdirProjectRoot(discover bool) error {
+	if len(ProjectRoot) > 0 {
+		if !discover {
+			// bootstrapping a new project
+			if err := os.MkdirAll(ProjectRoot, 0700); err != nil {
+				return err
+			}
+		}
+		return os.Chdir(ProjectRoot)
+	}
+	if !discover {
+		return nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if dir, ok := findUp(wd, ProjectFile); ok {
+		ProjectRoot = dir
+		return os.Chdir(dir)
+	}
+	dir, err := findProjectInWorkspace(wd)
+	if err != nil || len(dir) == 0 {
+		return err
+	}
+	ProjectRoot = dir
+	return os.Chdir(dir)
+}
+
+// findProjectInWorkspace looks for the PROJECT file under the modules used by go.work.  Returns
+// an empty dir if there is no workspace or none of its modules is an apiserver project.
+func findProjectInWorkspace(wd string) (string, error) {
+	work, ok := goWorkFile(wd)
+	if !ok {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(work)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed reading %s", work)
+	}
+	var found []string
+	for _, u := range parseGoWorkUse(data) {
+		dir := filepath.FromSlash(u)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(work), dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ProjectFile)); err == nil {
+			found = append(found, dir)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		klog.Infof("Using project %s from %s", found[0], work)
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("found multiple projects in %s: %s, specify one with --project-root",
+			work, strings.Join(found, ", "))
+	}
+}
+
+// goWorkFile returns the go.work file in effect for dir, honoring $GOWORK the same way the go
+// command does.
+func goWorkFile(dir string) (string, bool) {
+	switch w := os.Getenv("GOWORK"); w {
+	case "off":
+		return "", false
+	case "":
+		d, ok := findUp(dir, "go.work")
+		if !ok {
+			return "", false
+		}
+		return filepath.Join(d, "go.work"), true
+	default:
+		return w, true
+	}
+}
+
+// parseGoWorkUse returns the module directories listed by the use directives of a go.work file.
+func parseGoWorkUse(data []byte) []string {
+	var uses []string
+	inBlock := false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case inBlock && line == ")":
+			inBlock = false
+		case inBlock && len(line) > 0:
+			uses = append(uses, strings.Trim(line, `"`))
+		case strings.HasPrefix(line, "use") && strings.TrimSpace(strings.TrimPrefix(line, "use")) == "(":
+			inBlock = true
+		case strings.HasPrefix(line, "use "):
+			uses = append(uses, strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "use ")), `"`))
+		}
+	}
+	return uses
+}
+
+// findUp returns the first of dir and its parents containing name.
+func findUp(dir, name string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
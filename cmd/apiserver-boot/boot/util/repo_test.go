@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseGoWorkUse(t *testing.T) {
+	tests := []struct {
+		name string
+		work string
+		want []string
+	}{
+		{
+			name: "block",
+			work: `go 1.18
+
+use (
+	./apiserver // the aggregated apiserver
+	"./operator"
+	// ./disabled
+	../shared
+)
+`,
+			want: []string{"./apiserver", "./operator", "../shared"},
+		},
+		{
+			name: "single line",
+			work: "go 1.18\n\nuse ./apiserver\nuse \"/src/operator\" // absolute\n",
+			want: []string{"./apiserver", "/src/operator"},
+		},
+		{
+			name: "block without a space",
+			work: "use(\n\t.\n)\nuse ./tools\n",
+			want: []string{".", "./tools"},
+		},
+		{
+			name: "replace directives",
+			work: "go 1.18\n\n// use ./commented\nreplace example.com/lib => ./lib\nuseless ./x\n",
+		},
+	}
+	for _, test := range tests {
+		if got := parseGoWorkUse([]byte(test.work)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseGoWorkUse() = %q want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// setGoWork sets $GOWORK and returns a func restoring it
+func setGoWork(value string) func() {
+	old, ok := os.LookupEnv("GOWORK")
+	os.Setenv("GOWORK", value)
+	return func() {
+		if ok {
+			os.Setenv("GOWORK", old)
+		} else {
+			os.Unsetenv("GOWORK")
+		}
+	}
+}
+
+func TestGoWorkFile(t *testing.T) {
+	defer chdirTemp(t, map[string]string{
+		"go.work":                   "go 1.18\n\nuse ./apiserver\n",
+		"apiserver/go.mod":          "module example.com/apiserver\n",
+		"apiserver/pkg/apis/doc.go": "package apis\n",
+		"other/go.work":             "go 1.18\n",
+	})()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		gowork string
+		dir    string
+		want   string
+	}{
+		{name: "workspace root", dir: wd, want: filepath.Join(wd, "go.work")},
+		{name: "nested module", dir: filepath.Join(wd, "apiserver", "pkg", "apis"), want: filepath.Join(wd, "go.work")},
+		{name: "off", gowork: "off", dir: wd},
+		{name: "explicit path", gowork: filepath.Join(wd, "other", "go.work"), dir: filepath.Join(wd, "apiserver"),
+			want: filepath.Join(wd, "other", "go.work")},
+		{name: "no workspace", dir: filepath.Dir(wd)},
+	}
+	for _, test := range tests {
+		restore := setGoWork(test.gowork)
+		got, ok := goWorkFile(test.dir)
+		restore()
+		if got != test.want || ok != (len(test.want) > 0) {
+			t.Errorf("%s: goWorkFile(%s) = %s %t want %s", test.name, test.dir, got, ok, test.want)
+		}
+	}
+}
+
+func TestRepoFromModule(t *testing.T) {
+	defer chdirTemp(t, map[string]string{
+		"mono/go.mod":                  "module example.com/mono\n\ngo 1.15\n",
+		"mono/projects/insect/PROJECT": "version: \"1\"\n",
+		"empty/go.mod":                 "go 1.15\n",
+	})()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		modDir string
+		dir    string
+		want   string
+		err    bool
+	}{
+		{modDir: "mono", dir: "mono", want: "example.com/mono"},
+		{modDir: "mono", dir: "mono/projects/insect", want: "example.com/mono/projects/insect"},
+		{modDir: "empty", dir: "empty", err: true},
+		{modDir: "missing", dir: "missing", err: true},
+	}
+	for _, test := range tests {
+		got, err := repoFromModule(filepath.Join(wd, test.modDir), filepath.Join(wd, filepath.FromSlash(test.dir)))
+		switch {
+		case test.err && err == nil:
+			t.Errorf("repoFromModule(%s, %s) succeeded", test.modDir, test.dir)
+		case !test.err && err != nil:
+			t.Errorf("repoFromModule(%s, %s): %v", test.modDir, test.dir, err)
+		case got != test.want:
+			t.Errorf("repoFromModule(%s, %s) = %s want %s", test.modDir, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestFindProjectInWorkspace(t *testing.T) {
+	defer setGoWork("")()
+	defer chdirTemp(t, map[string]string{
+		"none/go.work":            "go 1.18\n\nuse ./operator\n",
+		"none/operator/go.mod":    "module example.com/operator\n",
+		"one/go.work":             "go 1.18\n\nuse (\n\t./apiserver\n\t./operator\n)\n",
+		"one/apiserver/PROJECT":   "version: \"1\"\n",
+		"one/operator/go.mod":     "module example.com/operator\n",
+		"many/go.work":            "go 1.18\n\nuse ./insect\nuse ./bird\n",
+		"many/insect/PROJECT":     "version: \"1\"\n",
+		"many/bird/PROJECT":       "version: \"1\"\n",
+		"nowork/apiserver/go.mod": "module example.com/apiserver\n",
+	})()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an absolute use path
+	abs := filepath.Join(wd, "abs")
+	os.MkdirAll(filepath.Join(abs, "apiserver"), 0700)
+	for name, content := range map[string]string{
+		"go.work":           "go 1.18\n\nuse " + filepath.ToSlash(filepath.Join(abs, "apiserver")) + "\n",
+		"apiserver/PROJECT": "version: \"1\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(abs, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+		err  bool
+	}{
+		{dir: "none"},
+		{dir: "none/operator"},
+		{dir: "one", want: "one/apiserver"},
+		{dir: "one/operator", want: "one/apiserver"},
+		{dir: "many", err: true},
+		{dir: "abs", want: "abs/apiserver"},
+		{dir: "nowork/apiserver"},
+	}
+	for _, test := range tests {
+		got, err := findProjectInWorkspace(filepath.Join(wd, filepath.FromSlash(test.dir)))
+		want := ""
+		if len(test.want) > 0 {
+			want = filepath.Join(wd, filepath.FromSlash(test.want))
+		}
+		switch {
+		case test.err && err == nil:
+			t.Errorf("findProjectInWorkspace(%s) = %s, want an error", test.dir, got)
+		case !test.err && err != nil:
+			t.Errorf("findProjectInWorkspace(%s): %v", test.dir, err)
+		case got != want:
+			t.Errorf("findProjectInWorkspace(%s) = %s want %s", test.dir, got, want)
+		}
+	}
+}
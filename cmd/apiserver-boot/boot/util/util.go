@@ -17,6 +17,7 @@ limitations under the License.
 package util
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -34,6 +35,23 @@ import (
 
 var Domain string
 
+// TemplateFuncs are the functions of the templates rendered by apiserver-boot
+var TemplateFuncs = template.FuncMap{
+	"title":  strings.Title,
+	"lower":  strings.ToLower,
+	"plural": inflect.NewDefaultRuleset().Pluralize,
+}
+
+// Render returns the template executed with data, as written by WriteIfNotFound
+func Render(templateName, templateValue string, data interface{}) string {
+	t := template.Must(template.New(templateName).Funcs(TemplateFuncs).Parse(templateValue))
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		klog.Fatalf("Failed to render %s: %v", templateName, err)
+	}
+	return buf.String()
+}
+
 // writeIfNotFound returns true if the file was created and false if it already exists
 func WriteIfNotFound(path, templateName, templateValue string, data interface{}) bool {
 	// Make sure the directory exists
@@ -48,13 +66,7 @@ func WriteIfNotFound(path, templateName, templateValue string, data interface{})
 	}
 	create(path)
 
-	t := template.Must(template.New(templateName).Funcs(
-		template.FuncMap{
-			"title":  strings.Title,
-			"lower":  strings.ToLower,
-			"plural": inflect.NewDefaultRuleset().Pluralize,
-		},
-	).Parse(templateValue))
+	t := template.Must(template.New(templateName).Funcs(TemplateFuncs).Parse(templateValue))
 
 	f, err := os.OpenFile(path, os.O_WRONLY, 0)
 	if err != nil {
@@ -76,13 +88,7 @@ func Overwrite(path, templateName, templateValue string, data interface{}) bool
 	os.MkdirAll(filepath.Dir(path), 0700)
 
 	create(path)
-	t := template.Must(template.New(templateName).Funcs(
-		template.FuncMap{
-			"title":  strings.Title,
-			"lower":  strings.ToLower,
-			"plural": inflect.NewDefaultRuleset().Pluralize,
-		},
-	).Parse(templateValue))
+	t := template.Must(template.New(templateName).Funcs(TemplateFuncs).Parse(templateValue))
 
 	f, err := os.OpenFile(path, os.O_WRONLY, 0)
 	if err != nil {
@@ -127,6 +133,50 @@ func GetDomain() string {
 	return Domain
 }
 
+// GetGroupDomain returns the effective domain of the API group.  Groups created with
+// `create group --domain` record their own domain in pkg/apis/<group>/doc.go, all other
+// groups live under the project domain.
+func GetGroupDomain(group string) string {
+	b, err := ioutil.ReadFile(filepath.Join("pkg", "apis", group, "doc.go"))
+	if err != nil {
+		return GetDomain()
+	}
+	r := regexp.MustCompile("\\+groupName=(.*)")
+	l := r.FindSubmatch(b)
+	if len(l) < 2 || !strings.HasPrefix(string(l[1]), group+".") {
+		return GetDomain()
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(l[1])), group+".")
+}
+
+// CertificatesDir returns the directory build config wrote the apiserver certificates to,
+// config/certificates or, for the kustomize format, config/base/certificates
+func CertificatesDir() string {
+	for _, dir := range []string{
+		filepath.Join("config", "certificates"),
+		filepath.Join("config", "base", "certificates"),
+	} {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return filepath.Join("config", "certificates")
+}
+
+// OverlayCertificatesDirs returns the directories of the certificates build config issued for the
+// namespaces of the kustomize overlays, next to the base certificates in dir
+func OverlayCertificatesDirs(dir string) []string {
+	base := filepath.Dir(dir)
+	if filepath.Base(base) != "base" {
+		return nil
+	}
+	dirs, err := filepath.Glob(filepath.Join(filepath.Dir(base), "overlays", "*", "namespaced", "certificates"))
+	if err != nil {
+		return nil
+	}
+	return dirs
+}
+
 func create(path string) {
 	f, err := os.Create(path)
 	if err != nil {
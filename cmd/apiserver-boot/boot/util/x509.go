@@ -1,6 +1,10 @@
 package util
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -14,6 +18,8 @@ import (
 	"net"
 	"path/filepath"
 	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
@@ -27,8 +33,17 @@ const (
 	CertificateBlockType = "CERTIFICATE"
 )
 
+const (
+	KeyAlgorithmRSA     = "rsa"
+	KeyAlgorithmECDSA   = "ecdsa"
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
+// KeyAlgorithms are the supported algorithms of the generated private keys
+var KeyAlgorithms = []string{KeyAlgorithmRSA, KeyAlgorithmECDSA, KeyAlgorithmEd25519}
+
 // TryLoadCertAndKeyFromDisk tries to load a cert and a key from the disk and validates that they are valid
-func TryLoadCertAndKeyFromDisk(pkiPath, name string) (*x509.Certificate, *rsa.PrivateKey, error) {
+func TryLoadCertAndKeyFromDisk(pkiPath, name string) (*x509.Certificate, crypto.Signer, error) {
 	cert, err := TryLoadCertFromDisk(pkiPath, name)
 	if err != nil {
 		return nil, nil, err
@@ -68,7 +83,7 @@ func TryLoadCertFromDisk(pkiPath, name string) (*x509.Certificate, error) {
 }
 
 // TryLoadKeyFromDisk tries to load the key from the disk and validates that it is valid
-func TryLoadKeyFromDisk(pkiPath, name string) (*rsa.PrivateKey, error) {
+func TryLoadKeyFromDisk(pkiPath, name string) (crypto.Signer, error) {
 	privateKeyPath := pathForKey(pkiPath, name)
 
 	// Parse the private key from a file
@@ -77,16 +92,12 @@ func TryLoadKeyFromDisk(pkiPath, name string) (*rsa.PrivateKey, error) {
 		return nil, fmt.Errorf("couldn't load the private key file %s: %v", privateKeyPath, err)
 	}
 
-	// Allow RSA format only
-	var key *rsa.PrivateKey
 	switch k := privKey.(type) {
-	case *rsa.PrivateKey:
-		key = k
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return k.(crypto.Signer), nil
 	default:
-		return nil, fmt.Errorf("the private key file %s isn't in RSA format", privateKeyPath)
+		return nil, fmt.Errorf("the private key file %s isn't in RSA, ECDSA or Ed25519 format", privateKeyPath)
 	}
-
-	return key, nil
 }
 
 // CertsFromFile returns the x509.Certificates contained in the given PEM-encoded file.
@@ -103,7 +114,7 @@ func CertsFromFile(file string) ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
-// PrivateKeyFromFile returns the private key in rsa.PrivateKey or ecdsa.PrivateKey format from a given PEM-encoded file.
+// PrivateKeyFromFile returns the private key in rsa.PrivateKey, ecdsa.PrivateKey or ed25519.PrivateKey format from a given PEM-encoded file.
 // Returns an error if the file could not be read or if the private key could not be parsed.
 func PrivateKeyFromFile(file string) (interface{}, error) {
 	data, err := ioutil.ReadFile(file)
@@ -170,7 +181,7 @@ func ParsePrivateKeyPEM(keyData []byte) (interface{}, error) {
 				return key, nil
 			}
 		case PrivateKeyBlockType:
-			// RSA or ECDSA Private Key in unencrypted PKCS#8 format
+			// RSA, ECDSA or Ed25519 Private Key in unencrypted PKCS#8 format
 			if key, err := x509.ParsePKCS8PrivateKey(privateKeyPemBlock.Bytes); err == nil {
 				return key, nil
 			}
@@ -181,7 +192,7 @@ func ParsePrivateKeyPEM(keyData []byte) (interface{}, error) {
 	}
 
 	// we read all the PEM blocks and didn't recognize one
-	return nil, fmt.Errorf("data does not contain a valid RSA or ECDSA private key")
+	return nil, fmt.Errorf("data does not contain a valid RSA, ECDSA or Ed25519 private key")
 }
 
 // EncodeCertPEM returns PEM-endcoded certificate data
@@ -193,13 +204,57 @@ func EncodeCertPEM(cert *x509.Certificate) []byte {
 	return pem.EncodeToMemory(&block)
 }
 
-// EncodePrivateKeyPEM returns PEM-encoded private key data
-func EncodePrivateKeyPEM(key *rsa.PrivateKey) []byte {
-	block := pem.Block{
-		Type:  RSAPrivateKeyBlockType,
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+// EncodePrivateKeyPEM returns PEM-encoded private key data.  RSA keys are encoded in PKCS#1,
+// ECDSA keys in SEC 1 and Ed25519 keys in PKCS#8 format.
+func EncodePrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	block := pem.Block{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block.Type = RSAPrivateKeyBlockType
+		block.Bytes = x509.MarshalPKCS1PrivateKey(k)
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block.Type = ECPrivateKeyBlockType
+		block.Bytes = der
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block.Type = PrivateKeyBlockType
+		block.Bytes = der
+	}
+	return pem.EncodeToMemory(&block), nil
+}
+
+// WriteCertAndKey writes the PEM encoded certificate and key to <name>.crt and <name>.key in pkiPath
+func WriteCertAndKey(pkiPath, name string, cert *x509.Certificate, key crypto.Signer) error {
+	keyData, err := EncodePrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	certPath, keyPath := pathsForCertAndKey(pkiPath, name)
+	if err := ioutil.WriteFile(certPath, EncodeCertPEM(cert), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyPath, keyData, 0600)
+}
+
+// KeyAlgorithmOf returns the algorithm and size of key, as accepted by NewPrivateKey
+func KeyAlgorithmOf(key crypto.PublicKey) (string, int) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return KeyAlgorithmRSA, k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return KeyAlgorithmECDSA, k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return KeyAlgorithmEd25519, 0
+	default:
+		return "", 0
 	}
-	return pem.EncodeToMemory(&block)
 }
 
 func pathsForCertAndKey(pkiPath, name string) (string, string) {
@@ -220,6 +275,12 @@ type Config struct {
 	Organization []string
 	AltNames     AltNames
 	Usages       []x509.ExtKeyUsage
+	// KeyAlgorithm is the algorithm of the private key, one of KeyAlgorithms, defaults to rsa
+	KeyAlgorithm string
+	// KeySize is the RSA modulus or ECDSA curve size in bits, defaults to 2048 and 256
+	KeySize int
+	// Validity is how long the certificate is valid, defaults to one year
+	Validity time.Duration
 }
 
 // AltNames contains the domain names and IP addresses that will be added
@@ -231,8 +292,8 @@ type AltNames struct {
 }
 
 // NewCertAndKey creates new certificate and key by passing the certificate authority certificate and key
-func NewCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config Config) (*x509.Certificate, *rsa.PrivateKey, error) {
-	key, err := NewPrivateKey()
+func NewCertAndKey(caCert *x509.Certificate, caKey crypto.Signer, config Config) (*x509.Certificate, crypto.Signer, error) {
+	key, err := NewPrivateKey(config.KeyAlgorithm, config.KeySize)
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to create private key [%v]", err)
 	}
@@ -245,19 +306,112 @@ func NewCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config Confi
 	return cert, key, nil
 }
 
+// NewCACertAndKey creates a new self-signed certificate authority certificate and key
+func NewCACertAndKey(config Config) (*x509.Certificate, crypto.Signer, error) {
+	key, err := NewPrivateKey(config.KeyAlgorithm, config.KeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create private key [%v]", err)
+	}
+
+	cert, err := NewSelfSignedCACert(config, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create self-signed certificate [%v]", err)
+	}
+
+	return cert, key, nil
+}
+
 const (
 	rsaKeySize   = 2048
+	ecdsaKeySize = 256
 	duration365d = time.Hour * 24 * 365
 )
 
-// NewPrivateKey creates an RSA private key
-func NewPrivateKey() (*rsa.PrivateKey, error) {
-	return rsa.GenerateKey(cryptorand.Reader, rsaKeySize)
+// ValidateKeyConfig checks the key algorithm and size are supported
+func ValidateKeyConfig(algorithm string, size int) error {
+	_, err := keyGenerator(algorithm, size)
+	return err
 }
 
-// NewSignedCert creates a signed certificate using the given CA certificate and key
-func NewSignedCert(cfg Config, key *rsa.PrivateKey, caCert *x509.Certificate, caKey *rsa.PrivateKey) (*x509.Certificate, error) {
-	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+// NewPrivateKey creates a private key of the algorithm and size.  An empty algorithm creates an
+// RSA key, a zero size the default size of the algorithm.
+func NewPrivateKey(algorithm string, size int) (crypto.Signer, error) {
+	generate, err := keyGenerator(algorithm, size)
+	if err != nil {
+		return nil, err
+	}
+	return generate()
+}
+
+func keyGenerator(algorithm string, size int) (func() (crypto.Signer, error), error) {
+	switch algorithm {
+	case KeyAlgorithmRSA, "":
+		if size == 0 {
+			size = rsaKeySize
+		}
+		if size < rsaKeySize {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits, was %d", rsaKeySize, size)
+		}
+		return func() (crypto.Signer, error) { return rsa.GenerateKey(cryptorand.Reader, size) }, nil
+	case KeyAlgorithmECDSA:
+		curves := map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		if size == 0 {
+			size = ecdsaKeySize
+		}
+		curve, ok := curves[size]
+		if !ok {
+			return nil, fmt.Errorf("ECDSA keys must be 256, 384 or 521 bits, was %d", size)
+		}
+		return func() (crypto.Signer, error) { return ecdsa.GenerateKey(curve, cryptorand.Reader) }, nil
+	case KeyAlgorithmEd25519:
+		if size != 0 {
+			return nil, fmt.Errorf("Ed25519 keys have a fixed size")
+		}
+		return func() (crypto.Signer, error) {
+			_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+			return key, err
+		}, nil
+	default:
+		return nil, fmt.Errorf("key algorithm must be one of %v, was %s", KeyAlgorithms, algorithm)
+	}
+}
+
+// NewSelfSignedCACert creates a certificate authority certificate signed by key, which may sign
+// one level of intermediate certificate authorities.
+func NewSelfSignedCACert(cfg Config, key crypto.Signer) (*x509.Certificate, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.CommonName) == 0 {
+		return nil, errors.New("must specify a CommonName")
+	}
+
+	now := time.Now()
+	tmpl := x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		SerialNumber:          serial,
+		NotBefore:             now.Add(-time.Minute).UTC(),
+		NotAfter:              now.Add(validity(cfg)).UTC(),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDERBytes)
+}
+
+// NewSignedCert creates a signed certificate using the given CA certificate and key.  The
+// certificate does not outlive the CA certificate.
+func NewSignedCert(cfg Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
+	serial, err := newSerial()
 	if err != nil {
 		return nil, err
 	}
@@ -268,17 +422,25 @@ func NewSignedCert(cfg Config, key *rsa.PrivateKey, caCert *x509.Certificate, ca
 		return nil, errors.New("must specify at least one ExtKeyUsage")
 	}
 
+	notAfter := time.Now().Add(validity(cfg)).UTC()
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
 	certTmpl := x509.Certificate{
 		Subject: pkix.Name{
 			CommonName:   cfg.CommonName,
 			Organization: cfg.Organization,
 		},
-		DNSNames:     cfg.AltNames.DNSNames,
+		DNSNames:     sets.NewString(cfg.AltNames.DNSNames...).List(),
 		IPAddresses:  cfg.AltNames.IPs,
 		SerialNumber: serial,
 		NotBefore:    caCert.NotBefore,
-		NotAfter:     time.Now().Add(duration365d * 10).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:     notAfter,
+		KeyUsage:     keyUsage,
 		ExtKeyUsage:  cfg.Usages,
 	}
 	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &certTmpl, caCert, key.Public(), caKey)
@@ -287,3 +449,14 @@ func NewSignedCert(cfg Config, key *rsa.PrivateKey, caCert *x509.Certificate, ca
 	}
 	return x509.ParseCertificate(certDERBytes)
 }
+
+func newSerial() (*big.Int, error) {
+	return cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+}
+
+func validity(cfg Config) time.Duration {
+	if cfg.Validity == 0 {
+		return duration365d
+	}
+	return cfg.Validity
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		size      int
+		// wantAlgorithm and wantSize are the algorithm and size of the generated keys
+		wantAlgorithm string
+		wantSize      int
+		// pemType is the PEM block type of the encoded key
+		pemType string
+	}{
+		{algorithm: "", wantAlgorithm: KeyAlgorithmRSA, wantSize: 2048, pemType: RSAPrivateKeyBlockType},
+		{algorithm: KeyAlgorithmRSA, size: 3072, wantAlgorithm: KeyAlgorithmRSA, wantSize: 3072, pemType: RSAPrivateKeyBlockType},
+		{algorithm: KeyAlgorithmECDSA, wantAlgorithm: KeyAlgorithmECDSA, wantSize: 256, pemType: ECPrivateKeyBlockType},
+		{algorithm: KeyAlgorithmECDSA, size: 384, wantAlgorithm: KeyAlgorithmECDSA, wantSize: 384, pemType: ECPrivateKeyBlockType},
+		{algorithm: KeyAlgorithmECDSA, size: 521, wantAlgorithm: KeyAlgorithmECDSA, wantSize: 521, pemType: ECPrivateKeyBlockType},
+		{algorithm: KeyAlgorithmEd25519, wantAlgorithm: KeyAlgorithmEd25519, pemType: PrivateKeyBlockType},
+	}
+
+	dir, err := ioutil.TempDir("", "x509")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		name := fmt.Sprintf("%s-%d", test.wantAlgorithm, test.wantSize)
+		caCert, caKey, err := NewCACertAndKey(Config{
+			CommonName:   "acme-ca",
+			KeyAlgorithm: test.algorithm,
+			KeySize:      test.size,
+		})
+		if err != nil {
+			t.Errorf("%s/%d: NewCACertAndKey: %v", test.algorithm, test.size, err)
+			continue
+		}
+		if err := WriteCertAndKey(dir, name, caCert, caKey); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(pathForKey(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block, _ := pem.Decode(data); block == nil || block.Type != test.pemType {
+			t.Errorf("%s/%d: key encoded as %v want %s", test.algorithm, test.size, block, test.pemType)
+		}
+
+		// sign with the keys read back from the disk
+		loadedCert, loadedKey, err := TryLoadCertAndKeyFromDisk(dir, name)
+		if err != nil {
+			t.Errorf("%s/%d: TryLoadCertAndKeyFromDisk: %v", test.algorithm, test.size, err)
+			continue
+		}
+		if a, s := KeyAlgorithmOf(loadedKey.Public()); a != test.wantAlgorithm || s != test.wantSize {
+			t.Errorf("%s/%d: loaded a %s/%d key want %s/%d", test.algorithm, test.size, a, s,
+				test.wantAlgorithm, test.wantSize)
+		}
+		cert, _, err := NewCertAndKey(loadedCert, loadedKey, Config{
+			CommonName:   "deepone",
+			AltNames:     AltNames{DNSNames: []string{"deepone.acme-system.svc"}},
+			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			KeyAlgorithm: test.algorithm,
+			KeySize:      test.size,
+		})
+		if err != nil {
+			t.Errorf("%s/%d: NewCertAndKey: %v", test.algorithm, test.size, err)
+			continue
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		if _, err := cert.Verify(x509.VerifyOptions{
+			DNSName:   "deepone.acme-system.svc",
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}); err != nil {
+			t.Errorf("%s/%d: verifying the signed certificate: %v", test.algorithm, test.size, err)
+		}
+		encipherment := cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0
+		if encipherment != (test.wantAlgorithm == KeyAlgorithmRSA) {
+			t.Errorf("%s/%d: key encipherment usage %t", test.algorithm, test.size, encipherment)
+		}
+	}
+}
+
+func TestValidateKeyConfig(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		size      int
+		err       bool
+	}{
+		{algorithm: KeyAlgorithmRSA, size: 4096},
+		{algorithm: KeyAlgorithmECDSA, size: 256},
+		{algorithm: KeyAlgorithmEd25519},
+		{algorithm: KeyAlgorithmRSA, size: 1024, err: true},
+		{algorithm: "", size: 2047, err: true},
+		{algorithm: KeyAlgorithmECDSA, size: 224, err: true},
+		{algorithm: KeyAlgorithmECDSA, size: 2048, err: true},
+		{algorithm: KeyAlgorithmEd25519, size: 256, err: true},
+		{algorithm: "dsa", err: true},
+	}
+	for _, test := range tests {
+		err := ValidateKeyConfig(test.algorithm, test.size)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("ValidateKeyConfig(%q, %d) succeeded", test.algorithm, test.size)
+		case !test.err && err != nil:
+			t.Errorf("ValidateKeyConfig(%q, %d): %v", test.algorithm, test.size, err)
+		}
+	}
+}
+
+func TestNewSignedCertValidity(t *testing.T) {
+	caCert, caKey, err := NewCACertAndKey(Config{
+		CommonName:   "acme-ca",
+		KeyAlgorithm: KeyAlgorithmECDSA,
+		Validity:     time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		validity time.Duration
+		// clamped is true if the certificate expires with the CA
+		clamped bool
+	}{
+		{validity: 0, clamped: true},
+		{validity: 2 * time.Hour, clamped: true},
+		{validity: 30 * time.Minute},
+	}
+	for _, test := range tests {
+		start := time.Now()
+		cert, _, err := NewCertAndKey(caCert, caKey, Config{
+			CommonName:   "deepone",
+			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			KeyAlgorithm: KeyAlgorithmECDSA,
+			Validity:     test.validity,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.NotBefore.Equal(caCert.NotBefore) {
+			t.Errorf("validity %s: NotBefore %s want the NotBefore %s of the CA", test.validity,
+				cert.NotBefore, caCert.NotBefore)
+		}
+		switch {
+		case test.clamped && !cert.NotAfter.Equal(caCert.NotAfter):
+			t.Errorf("validity %s: NotAfter %s want the NotAfter %s of the CA", test.validity,
+				cert.NotAfter, caCert.NotAfter)
+		case !test.clamped && (cert.NotAfter.Before(start.Add(test.validity).Add(-time.Second)) ||
+			!cert.NotAfter.Before(caCert.NotAfter)):
+			t.Errorf("validity %s: NotAfter %s want %s before the NotAfter %s of the CA", test.validity,
+				cert.NotAfter, start.Add(test.validity), caCert.NotAfter)
+		}
+	}
+}
@@ -20,18 +20,25 @@ import (
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/build"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/certs"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/create"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/init_repo"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/lint"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/run"
+	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/util"
 	"sigs.k8s.io/apiserver-builder-alpha/cmd/apiserver-boot/boot/version"
 )
 
 func main() {
+	cmd.PersistentFlags().StringVar(&util.ProjectRoot, "project-root", "",
+		"directory of the project, defaults to the nearest parent directory containing a PROJECT file")
 
 	init_repo.AddInit(cmd)
 	create.AddCreate(cmd)
 	build.AddBuild(cmd)
 	run.AddRun(cmd)
+	certs.AddCerts(cmd)
+	lint.AddLint(cmd)
 	version.AddVersion(cmd)
 
 	if err := cmd.Execute(); err != nil {
@@ -43,9 +50,14 @@ var cmd = &cobra.Command{
 	Use:   "apiserver-boot",
 	Short: "apiserver-boot development kit for building Kubernetes extensions in go.",
 	Long:  `apiserver-boot development kit for building Kubernetes extensions in go.`,
-	Example: `# Initialize your repository with scaffolding directories and go files. Specify --module-name if the project works outside GOPATH.
+	Example: `# Initialize your repository with scaffolding directories and go files. Specify --module-name if the project
+# is neither under GOPATH nor inside an existing go module.
 apiserver-boot init repo --domain example.com
 
+# Commands may be run from any subdirectory of the project, use --project-root when the project isn't
+# a parent of the working directory, e.g. from the root of a monorepo.
+apiserver-boot create group --group insect --project-root services/insects
+
 # Create new resource "Bee" in the "insect" group with version "v1beta1"
 apiserver-boot create group version resource --group insect --version v1beta1 --kind Bee
 
@@ -60,6 +72,13 @@ go test ./pkg/...
 # Produces a kubeconfig to talk to the local server
 apiserver-boot run local
 
+# Report the expiry of the certificates generated by build config, and rotate them
+apiserver-boot certs status
+apiserver-boot certs rotate
+
+# List deprecated resources and fail if any is past its removal release
+apiserver-boot lint
+
 # Check the api versions of the locally running server
 kubectl --kubeconfig kubeconfig api-versions
 
@@ -67,6 +86,11 @@ kubectl --kubeconfig kubeconfig api-versions
 # Note: after running this you should clear the discovery service
 # cache before running kubectl with "rm -rf ~/.kube/cache/discovery/"
 apiserver-boot run in-cluster --name creatures --namespace default --image repo/name:tag`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := util.ChdirProjectRoot(true); err != nil {
+			klog.Fatal(err)
+		}
+	},
 	Run: RunMain,
 }
 
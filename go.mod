@@ -25,4 +25,5 @@ require (
 	k8s.io/klog v1.0.0
 	k8s.io/utils v0.0.0-20200912215256-4140de9c8800 // indirect
 	sigs.k8s.io/kubebuilder v1.0.9-0.20200925141511-a2f239880b04
+	sigs.k8s.io/yaml v1.2.0
 )